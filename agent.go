@@ -6,6 +6,10 @@ import (
 	"fmt"
 	"iter"
 	"slices"
+	"strings"
+	"time"
+
+	"github.com/JoshPattman/jpf"
 )
 
 type Agent struct {
@@ -14,63 +18,148 @@ type Agent struct {
 	tools            []Tool
 	skillSelector    SkillSelector
 	dynamicFragments []Skill
+	// The persistent skills as given, before they were rendered
+	persistentSkills []Skill
+	// Persistent skills that could not be rendered when the agent was created, so must be rendered and inserted at the start of the next turn
+	pendingSkills   []Skill
+	skillVars       map[string]any
+	compaction      compactionConfig
+	tokenizer       Tokenizer
+	budget          contextBudget
+	elideAfterTurns int
+	turn            int
+	persistence     conversationPersistence
+	redaction       redactionConfig
+	router          ModelRouter
 }
 
+// Send a message to the agent, returning its final answer once it has finished reasoning and calling tools.
+// If the turn fails, the error is returned with an empty answer.
+// The one exception is with [WithPartialAnswerRecovery], where a final answer that was cut off part way through is returned
+// along with an error wrapping [ErrPartialAnswer], so check for that error before discarding the answer.
 func (ag *Agent) Send(msg string, opts ...SendMessageOpt) (string, error) {
 	kwargs := getKwargs(opts)
 	streamers := kwargs.Streamers()
+	defer streamers.flush()
+	if err := kwargs.ctx.Err(); err != nil {
+		return "", err
+	}
 
-	// Update notifications
-	for _, msg := range kwargs.notifications {
-		ag.addMessages(streamers, notificationMessage{msg})
+	// Retry saving any messages that could not be saved before, such as when the agent was created
+	if err := ag.persist(); err != nil {
+		return "", err
 	}
 
-	// Add the initial user message
-	ag.addMessages(streamers, userMessage{msg})
+	if ag.pendingSkills != nil {
+		skills, err := renderPersistentSkills(ag.pendingSkills, mergeSkillVars(ag.skillVars, kwargs.skillVars))
+		if err != nil {
+			return "", err
+		}
+		ag.pendingSkills = nil
+		if err := ag.addMessages(streamers, skillMessage{Skills: withPersistentSkills(ag.messages, skills)}); err != nil {
+			return "", err
+		}
+	}
+
+	// Compact older turns if the history is getting too long
+	if err := ag.compactIfNeeded(kwargs.ctx); err != nil {
+		return "", err
+	}
+
+	// Update notifications and add the initial user message
+	initialMessages := make([]Message, 0)
+	for _, msg := range kwargs.notifications {
+		initialMessages = append(initialMessages, notificationMessage{Notification: msg})
+	}
+	initialMessages = append(initialMessages, userMessage{Content: msg, Parts: kwargs.attachments})
+	if err := ag.addMessages(streamers, initialMessages...); err != nil {
+		return "", err
+	}
 
 	// Signal we are collecting context and add any relevant fragments
 	if len(ag.dynamicFragments) > 0 {
-		ag.addMessages(streamers, modeSwitchMessage{ModeCollectContext})
-		nextSkills, err := ag.getNextSelectedSkills()
+		if err := ag.addMessages(streamers, modeSwitchMessage{Mode: ModeCollectContext}); err != nil {
+			return "", err
+		}
+		nextSkills, model, err := ag.getNextSelectedSkills(kwargs.ctx, kwargs.skillVars)
 		if err != nil {
 			return "", err
 		}
-		ag.addMessages(streamers, skillMessage{nextSkills})
+		var skillMsg Message = skillMessage{Skills: nextSkills}
+		if model != "" {
+			skillMsg = withMetadata(skillMsg, map[string]any{MetaModel: model})
+		}
+		if err := ag.addMessages(streamers, skillMsg); err != nil {
+			return "", err
+		}
 	}
 
-	ag.addMessages(streamers, modeSwitchMessage{ModeReasonAct})
+	if err := ag.addMessages(streamers, modeSwitchMessage{Mode: ModeReasonAct}); err != nil {
+		return "", err
+	}
 
 	// React loop
-	for {
+	route := RouteContext{Phase: PhaseReAct}
+	for ; ; route.Iteration++ {
 		// Ask agent for any new tool calls and break if there are no calls
-		toolCalls, err := ag.answerReAct()
+		toolCalls, err := ag.answerReAct(kwargs.ctx, streamers, route)
 		if err != nil {
 			return "", err
 		}
-		ag.addMessages(streamers, toolCalls)
+		streamers.emit(ReasoningEvent{Reasoning: toolCalls.Reasoning})
+		if err := ag.addMessages(streamers, toolCalls); err != nil {
+			return "", err
+		}
 		if len(toolCalls.ToolCalls) == 0 {
 			break
 		}
 		// Execute tool calls
-		toolResults := ag.executeToolCalls(toolCalls.ToolCalls)
-		ag.addMessages(streamers, toolResponseMessage{toolResults})
+		if err := kwargs.ctx.Err(); err != nil {
+			return "", err
+		}
+		toolResults, failures := ag.executeToolCalls(kwargs.ctx, toolCalls.ToolCalls, streamers)
+		route.ToolErrors += failures
+		if err := ag.addMessages(streamers, toolResponseMessage{Responses: toolResults}); err != nil {
+			return "", err
+		}
 	}
 
 	// Set the agent to final answer mode and get the response
-	ag.addMessages(streamers, modeSwitchMessage{ModeAnswerUser})
-	finalResp, err := ag.answerFinalResponse(streamers)
+	if err := ag.addMessages(streamers, modeSwitchMessage{Mode: ModeAnswerUser}); err != nil {
+		return "", err
+	}
+	streamers.emit(FinalAnswerStartEvent{})
+	route.Phase = PhaseFinalAnswer
+	finalResp, err := ag.answerFinalResponse(kwargs.ctx, streamers, route)
 	if err != nil {
+		if !kwargs.recoverPartial || finalResp.Content == "" {
+			return "", err
+		}
+		streamers.emit(FinalAnswerEndEvent{Content: finalResp.Content, Partial: true})
+		if err := ag.addMessages(streamers, finalResp); err != nil {
+			return "", err
+		}
+		return finalResp.Content, fmt.Errorf("%w: %w", ErrPartialAnswer, err)
+	}
+	streamers.emit(FinalAnswerEndEvent{Content: finalResp.Content})
+	if err := ag.addMessages(streamers, finalResp); err != nil {
 		return "", err
 	}
-	ag.addMessages(streamers, agentMessage{finalResp})
-	return finalResp, nil
+	streamers.emit(TurnCompleteEvent{Turn: ag.turn, Response: finalResp.Content})
+	return finalResp.Content, nil
 }
 
 func (ag *Agent) Messages() iter.Seq[Message] {
 	return slices.Values(ag.messages)
 }
 
-func (ag *Agent) getNextSelectedSkills() ([]InsertedSkill, error) {
+// Turn returns the number of the latest turn (starting at 1), or 0 if no messages have been sent.
+func (ag *Agent) Turn() int {
+	return ag.turn
+}
+
+// Choose the skills for the turn, also returning the name of the model that selected them if it is known.
+func (ag *Agent) getNextSelectedSkills(ctx context.Context, turnVars map[string]any) ([]InsertedSkill, string, error) {
 	// Find any carry forward skills
 	prevSkills := getLastInsertedSkills(ag.messages)
 	skillsToPersist := make([]InsertedSkill, 0)
@@ -80,66 +169,166 @@ func (ag *Agent) getNextSelectedSkills() ([]InsertedSkill, error) {
 		}
 	}
 	// Select new skills
-	newSkills, err := ag.skillSelector.SelectSkills(ag.dynamicFragments, ag.messages)
+	ctx = withRouteTurn(ctx, ag.turn)
+	msgs := redactMessages(ag.redaction.redactor, ag.messages)
+	newSkills, model, err := selectSkills(ctx, ag.skillSelector, ag.dynamicFragments, msgs)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	vars := mergeSkillVars(ag.skillVars, turnVars)
 	skillsToInsert := make([]InsertedSkill, len(newSkills))
 	for i, s := range newSkills {
+		s, err := renderSkill(s, vars)
+		if err != nil {
+			return nil, "", err
+		}
 		skillsToInsert[i] = InsertedSkill{s, s.RemainFor}
 	}
-	return append(skillsToPersist, skillsToInsert...), nil
+	return append(skillsToPersist, skillsToInsert...), model, nil
 }
 
-func (ag *Agent) answerReAct() (toolCallsMessage, error) {
-	model := ag.modelBuilder.BuildAgentModel(reasonResponse{}, nil, nil)
-	pipeline := getAgentReActPipeline(model)
-	result, _, err := pipeline.Call(context.Background(), ag.messages)
+func (ag *Agent) answerReAct(ctx context.Context, streamers multiStreamers, route RouteContext) (toolCallsMessage, error) {
+	builder, modelName, err := ag.routeModel(route)
+	if err != nil {
+		return toolCallsMessage{}, err
+	}
+	var model jpf.Model
+	if streamers.streamingReAct() {
+		parser := newReActStreamParser(streamers.TrySendReasoningChunk, streamers.TrySendToolCall)
+		// The stream begins again if the model call is retried
+		model = builder.BuildAgentModel(reasonResponse{}, parser.reset, parser.write)
+	} else {
+		model = builder.BuildAgentModel(reasonResponse{}, nil, nil)
+	}
+	pipeline := getAgentReActPipeline(ag.encoder(), model)
+	start := time.Now()
+	result, _, err := pipeline.Call(ctx, ag.messages)
 	if err != nil {
 		return toolCallsMessage{}, err
 	}
-	return toolCallsMessageFromResponse(result), nil
+	msg := toolCallsMessageFromResponse(result)
+	msg.info.Metadata = modelCallMetadata(start, servedModelName(model, modelName))
+	return msg, nil
 }
 
-func (ag *Agent) answerFinalResponse(streamer TextStreamer) (string, error) {
-	model := ag.modelBuilder.BuildAgentModel(nil, nil, streamer.TrySendTextChunk)
-	pipeline := getAgentFinalAnswerPipeline(model)
-	result, _, err := pipeline.Call(context.Background(), ag.messages)
+// Get the final answer, streaming it back.
+// If it fails, the text streamed so far is returned as a partial answer along with the error.
+func (ag *Agent) answerFinalResponse(ctx context.Context, streamers multiStreamers, route RouteContext) (agentMessage, error) {
+	streamed := &strings.Builder{}
+	onInit := func() {
+		streamed.Reset()
+		streamers.beginTextStream()
+	}
+	onData := func(chunk string) {
+		streamed.WriteString(chunk)
+		streamers.TrySendTextChunk(chunk)
+	}
+	builder, modelName, err := ag.routeModel(route)
 	if err != nil {
-		return "", err
+		streamers.failTextStream(err)
+		return agentMessage{}, err
+	}
+	model := builder.BuildAgentModel(nil, onInit, onData)
+	pipeline := getAgentFinalAnswerPipeline(ag.encoder(), model)
+	start := time.Now()
+	result, _, err := pipeline.Call(ctx, ag.messages)
+	if err != nil {
+		streamers.failTextStream(err)
+		result = streamed.String()
+	} else {
+		streamers.endTextStream()
+	}
+	if ag.redaction.rehydrate {
+		result = ag.redaction.redactor.Rehydrate(result)
+	}
+	msg := agentMessage{Content: result}
+	msg.info.Metadata = modelCallMetadata(start, servedModelName(model, modelName))
+	if err != nil {
+		msg.info.Metadata[MetaPartial] = true
 	}
-	return result, nil
+	return msg, err
 }
 
-func (ag *Agent) addMessages(msgStreamer MessageStreamer, msgs ...Message) {
-	ag.messages = append(ag.messages, msgs...)
-	if msgStreamer != nil {
-		for _, msg := range msgs {
+// Metadata describing a call to the named model that began at start.
+func modelCallMetadata(start time.Time, modelName string) map[string]any {
+	meta := map[string]any{
+		MetaLatencyMS: time.Since(start).Milliseconds(),
+	}
+	if modelName != "" {
+		meta[MetaModel] = modelName
+	}
+	return meta
+}
+
+func (ag *Agent) encoder() *messagesEncoder {
+	return &messagesEncoder{
+		tokenizer:       ag.tokenizer,
+		budget:          ag.budget,
+		elideAfterTurns: ag.elideAfterTurns,
+		redactor:        ag.redaction.redactor,
+	}
+}
+
+// Add the messages to the history, streaming them and saving them to the conversation store (if there is one).
+// The messages are always added, even if saving them fails.
+func (ag *Agent) addMessages(msgStreamer MessageStreamer, msgs ...Message) error {
+	for _, msg := range msgs {
+		if _, ok := msg.(userMessage); ok {
+			ag.turn++
+		}
+		msg = stampMessage(msg, map[string]any{MetaTurn: ag.turn})
+		ag.messages = append(ag.messages, msg)
+		if msgStreamer != nil {
 			msgStreamer.TrySendMessage(msg)
 		}
 	}
+	return ag.persist()
 }
 
-func (ag *Agent) executeToolCalls(calls []ToolCall) []ToolResponse {
+// Execute the tool calls, returning their responses and how many of them failed.
+func (ag *Agent) executeToolCalls(ctx context.Context, calls []ToolCall, streamers multiStreamers) ([]ToolResponse, int) {
 	results := make([]ToolResponse, 0)
-	for _, call := range calls {
-		tool := ag.findToolByName(call.ToolName)
-		if tool == nil {
-			results = append(results, ToolResponse{fmt.Sprintf("Could not find tool. with name '%s'", call.ToolName)})
-			continue
-		}
-		args := make(map[string]any)
-		for _, arg := range call.ToolArgs {
-			args[arg.ArgName] = arg.ArgValue
-		}
-		result, err := tool.Call(args)
+	failures := 0
+	for i, call := range calls {
+		streamers.emit(ToolCallStartEvent{Index: i, Call: call})
+		resp, err := ag.executeToolCall(ctx, call)
 		if err != nil {
-			results = append(results, ToolResponse{fmt.Sprintf("There was an error calling the tool: %v", err)})
-			continue
+			failures++
 		}
-		results = append(results, ToolResponse{result})
+		streamers.emit(ToolCallEndEvent{Index: i, Call: call, Response: resp})
+		results = append(results, resp)
+	}
+	return results, failures
+}
+
+// Execute the tool call. If it fails, the response describes the error to the agent, and the error is also returned.
+func (ag *Agent) executeToolCall(ctx context.Context, call ToolCall) (ToolResponse, error) {
+	tool := ag.findToolByName(call.ToolName)
+	if tool == nil {
+		err := fmt.Errorf("could not find tool with name '%s'", call.ToolName)
+		return ToolResponse{Response: fmt.Sprintf("Could not find tool. with name '%s'", call.ToolName)}, err
+	}
+	args := make(map[string]any)
+	for _, arg := range call.ToolArgs {
+		args[arg.ArgName] = arg.ArgValue
+	}
+	if ag.redaction.rehydrate {
+		args = rehydrateValue(ag.redaction.redactor, args).(map[string]any)
+	}
+	var result string
+	var parts []ContentPart
+	var err error
+	if ctxTool, ok := tool.(ContextTool); ok {
+		result, parts, err = ctxTool.CallContext(ctx, args)
+	} else if mmTool, ok := tool.(MultimodalTool); ok {
+		result, parts, err = mmTool.CallMultimodal(args)
+	} else {
+		result, err = tool.Call(args)
+	}
+	if err != nil {
+		return ToolResponse{Response: fmt.Sprintf("There was an error calling the tool: %v", err)}, err
 	}
-	return results
+	return ToolResponse{Response: result, Parts: parts}, nil
 }
 
 func (ag *Agent) findToolByName(toolName string) Tool {
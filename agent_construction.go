@@ -1,46 +1,91 @@
 package react
 
-import _ "embed"
+import (
+	_ "embed"
+	"slices"
+)
 
 func New(mb ModelBuilder, opts ...NewOpt) *Agent {
 	kwargs := getNewKwargs(opts)
+	kwargs.persistence.isNew = true
 	messages := []Message{
 		personalityMessage{
-			kwargs.personality,
+			Personality: kwargs.personality,
 		},
 		systemMessage{
 			Template: createCraigSystemTemplate(),
 		},
 	}
-	return newHelper(mb, messages, kwargs)
+	return newHelper(mb, nil, messages, kwargs)
 }
 
 func NewFromSaved(mb ModelBuilder, messages []Message, opts ...NewOpt) *Agent {
-	return newHelper(mb, messages, getNewKwargs(opts))
+	return newHelper(mb, messages, nil, getNewKwargs(opts))
 }
 
-func newHelper(mb ModelBuilder, messages []Message, kwargs newKwargs) *Agent {
-	// Add persistent skills by default forever
+// Create an agent that continues from the history, adding the initial messages (and any other required messages) to it.
+func newHelper(mb ModelBuilder, history []Message, initial []Message, kwargs newKwargs) *Agent {
+	messages := slices.Clone(initial)
+
+	// Add persistent skills by default forever.
+	// If they cannot be rendered yet, they are added at the start of the next turn instead, where the error can be returned.
+	// When continuing a history, they replace the persistent skills already in it, keeping any dynamic skills that are still active,
+	// and if there are none the skills in the history are left as they are.
 	dyn, pers := getDynamicAndPersistent(kwargs.skills)
-	insertPersistentSkills := make([]InsertedSkill, len(pers))
-	for i, s := range pers {
-		insertPersistentSkills[i] = InsertedSkill{s, 999999999999999999}
+	var pendingSkills []Skill
+	if len(history) == 0 || len(pers) > 0 {
+		if insertPersistentSkills, err := renderPersistentSkills(pers, kwargs.skillVars); err != nil {
+			pendingSkills = pers
+		} else if skills := withPersistentSkills(history, insertPersistentSkills); len(history) == 0 || !sameSkills(skills, getLastInsertedSkills(history)) {
+			messages = append(messages, skillMessage{Skills: skills})
+		}
+	}
+
+	// Give the agent a way to see elided tool outputs again
+	tools := kwargs.tools
+	var retrieveTool *retrieveToolOutputTool
+	if kwargs.elideAfterTurns > 0 {
+		retrieveTool = &retrieveToolOutputTool{}
+		tools = append(slices.Clone(tools), retrieveTool)
 	}
-	messages = append(messages, skillMessage{insertPersistentSkills})
 
 	// Add tool definitions if the tools were changed since the last agent
-	if toolsHaveChanged(messages, kwargs.tools) {
+	if toolsHaveChanged(slices.Concat(history, messages), tools) {
 		messages = append(messages, toolsMessage{
-			getToolDefs(kwargs.tools),
+			Tools: getToolDefs(tools),
 		})
 	}
 
+	skillSelector := kwargs.skillSelector
+	if skillSelector == nil {
+		skillSelector = NewSkillSelector(skillSelectionModelBuilder(mb, kwargs.router))
+	}
+
 	// Build
 	ag := &Agent{
-		messages:         messages,
+		messages:         slices.Clone(history),
 		modelBuilder:     mb,
+		tools:            tools,
 		dynamicFragments: dyn,
-		skillSelector:    NewSkillSelector(mb),
+		persistentSkills: pers,
+		pendingSkills:    pendingSkills,
+		skillSelector:    skillSelector,
+		skillVars:        kwargs.skillVars,
+		compaction:       kwargs.compaction,
+		tokenizer:        kwargs.tokenizer,
+		budget:           kwargs.budget,
+		elideAfterTurns:  kwargs.elideAfterTurns,
+		turn:             getCurrentTurn(history),
+		persistence:      kwargs.persistence,
+		redaction:        kwargs.redaction,
+		router:           kwargs.router,
+	}
+	// The history is assumed to already be in the store.
+	ag.persistence.persisted = len(history)
+	// Errors are ignored here, as any messages that failed to save will be retried when the next messages are added.
+	ag.addMessages(nil, messages...)
+	if retrieveTool != nil {
+		retrieveTool.agent = ag
 	}
 	return ag
 }
@@ -48,6 +93,7 @@ func newHelper(mb ModelBuilder, messages []Message, kwargs newKwargs) *Agent {
 func getNewKwargs(opts []NewOpt) newKwargs {
 	kwargs := newKwargs{
 		personality: "Your name is CRAIG, a helpful assistant.",
+		tokenizer:   NewHeuristicTokenizer(),
 	}
 	for _, o := range opts {
 		o(&kwargs)
@@ -57,6 +103,10 @@ func getNewKwargs(opts []NewOpt) newKwargs {
 
 type NewOpt func(*newKwargs)
 
+// Give the agent skills. Conditional skills are chosen each turn by the [SkillSelector], the rest are always active.
+// Templated persistent skills are rendered once, with the variables from [WithSkillVars], and are not rendered again in later turns.
+// A persistent skill that cannot be rendered does not stop the agent being created,
+// the error is returned by the first Send instead (see [WithSkillVars]).
 func WithSkills(skills ...Skill) func(kw *newKwargs) {
 	return func(kw *newKwargs) { kw.skills = append(kw.skills, skills...) }
 }
@@ -69,10 +119,108 @@ func WithPersonality(personality string) func(kw *newKwargs) {
 	return func(kw *newKwargs) { kw.personality = personality }
 }
 
+// Set variables used to render templated skills.
+// Persistent skills are rendered once when the agent is created, dynamic skills each time they are inserted.
+// If a persistent skill cannot be rendered when the agent is created (such as when a variable is missing),
+// it is rendered at the start of the first turn instead, along with the turn variables, and Send returns the error if that fails too.
+func WithSkillVars(vars map[string]any) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.skillVars = mergeSkillVars(kw.skillVars, vars) }
+}
+
+// Use the provided [SkillSelector] to choose dynamic skills, instead of the default LLM-based one.
+func WithSkillSelector(selector SkillSelector) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.skillSelector = selector }
+}
+
+// Compact the history at the start of a turn once the prompt is estimated (with the agent's [Tokenizer]) to be at least thresholdTokens long.
+// All but the most recent keepTurns turns are replaced with a summary written by the summariser.
+func WithCompaction(summariser Summariser, thresholdTokens int, keepTurns int) func(kw *newKwargs) {
+	return func(kw *newKwargs) {
+		kw.compaction = compactionConfig{summariser, thresholdTokens, keepTurns}
+	}
+}
+
+type compactionConfig struct {
+	summariser Summariser
+	threshold  int
+	keepTurns  int
+}
+
+// Use the provided [Tokenizer] to estimate prompt sizes, instead of the default heuristic one.
+func WithTokenizer(tokenizer Tokenizer) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.tokenizer = tokenizer }
+}
+
+// Limit the prompt sent to the agent model to maxTokens, using the strategy to reduce it when it is too large.
+// The history of the agent is never modified, only the prompt built from it.
+// If the strategy is nil, the agent will error instead of calling the model with a prompt that is too large.
+func WithContextBudget(maxTokens int, strategy BudgetStrategy) func(kw *newKwargs) {
+	if strategy == nil {
+		strategy = NewErrorBudgetStrategy()
+	}
+	return func(kw *newKwargs) { kw.budget = contextBudget{maxTokens, strategy} }
+}
+
+type contextBudget struct {
+	maxTokens int
+	strategy  BudgetStrategy
+}
+
+// Replace tool responses from at least the given number of turns ago with a short placeholder in the prompt.
+// The full responses are kept in the history, and the agent is given a tool to retrieve them again if needed.
+func WithToolOutputElision(afterTurns int) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.elideAfterTurns = afterTurns }
+}
+
+// Append every message to the conversation with the given ID in the store as soon as it is added to the history.
+// When creating an agent from saved messages, those messages are assumed to already be in the store (see [NewFromStore]).
+// With [New], the conversation must not already be in the store, otherwise nothing is saved and Send returns [ErrConversationExists].
+// Use [NewFromStore] to continue an existing conversation instead.
+func WithConversationStore(store ConversationStore, id string) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.persistence = conversationPersistence{store: store, id: id} }
+}
+
+// Redact sensitive content (such as email addresses) from the history before it is sent to any model, while keeping the original content in the history.
+// If rehydrate is true, placeholders in the final answer and in tool call arguments are replaced with the original content.
+// Text that is streamed back is not rehydrated.
+// See [NewRegexRedactor] for how placeholders behave when the agent is recreated from a saved history.
+func WithRedaction(redactor Redactor, rehydrate bool) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.redaction = redactionConfig{redactor, rehydrate} }
+}
+
+type redactionConfig struct {
+	redactor  Redactor
+	rehydrate bool
+}
+
+// Use the router to choose the model for each model call, instead of always using the model builder the agent was created with.
+// Unless a skill selector is given, skills are selected with the model the router chooses for [PhaseSkillSelection].
+// To route summaries, create the summariser with [NewRoutedModelBuilder].
+func WithModelRouter(router ModelRouter) func(kw *newKwargs) {
+	return func(kw *newKwargs) { kw.router = router }
+}
+
+// The model builder to select skills with.
+func skillSelectionModelBuilder(mb ModelBuilder, router ModelRouter) FragmentSelectorModelBuilder {
+	if router == nil {
+		return mb
+	}
+	return NewRoutedModelBuilder(router, PhaseSkillSelection)
+}
+
 type newKwargs struct {
-	skills      []Skill
-	tools       []Tool
-	personality string
+	router          ModelRouter
+	skills          []Skill
+	tools           []Tool
+	personality     string
+	skillVars       map[string]any
+	skillSelector   SkillSelector
+	compaction      compactionConfig
+	tokenizer       Tokenizer
+	budget          contextBudget
+	elideAfterTurns int
+	persistence     conversationPersistence
+	redaction       redactionConfig
 }
 
 //go:embed system.tpl
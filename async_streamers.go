@@ -0,0 +1,209 @@
+package react
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamOverflowPolicy decides what happens when a streamer used with [WithAsyncStreamers] falls behind and its queue is full.
+type StreamOverflowPolicy uint8
+
+const (
+	// Wait for space in the queue, so a slow streamer slows down the agent, but nothing is lost unless the turn is cancelled.
+	OverflowBlock StreamOverflowPolicy = iota
+	// Drop the oldest queued callback to make space for the new one.
+	OverflowDropOldest
+	// Drop the new callback, keeping what is already queued.
+	OverflowDropNewest
+)
+
+func (p StreamOverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop oldest"
+	case OverflowDropNewest:
+		return "drop newest"
+	default:
+		return "unknown overflow policy"
+	}
+}
+
+type asyncStreamConfig struct {
+	queueSize int
+	policy    StreamOverflowPolicy
+}
+
+// A bounded queue of callbacks for a single streamer, delivered in order on its own goroutine.
+// Once the context is cancelled, queued callbacks are dropped and nothing waits for the queue any more,
+// so a streamer that blocks can only hold up the turn until it is cancelled.
+type streamQueue struct {
+	lock      sync.Mutex
+	cond      *sync.Cond
+	callbacks []func()
+	size      int
+	policy    StreamOverflowPolicy
+	closed    bool
+	done      chan struct{}
+	ctx       context.Context
+	stop      func() bool
+}
+
+func newStreamQueue(ctx context.Context, config asyncStreamConfig) *streamQueue {
+	q := &streamQueue{
+		size:   max(config.queueSize, 1),
+		policy: config.policy,
+		done:   make(chan struct{}),
+		ctx:    ctx,
+	}
+	q.cond = sync.NewCond(&q.lock)
+	q.stop = context.AfterFunc(ctx, q.cancel)
+	go q.run()
+	return q
+}
+
+// Drop any queued callbacks and stop accepting new ones.
+func (q *streamQueue) cancel() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.callbacks = nil
+	q.cond.Broadcast()
+}
+
+func (q *streamQueue) push(callback func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.callbacks) >= q.size && !q.closed {
+		switch q.policy {
+		case OverflowDropNewest:
+			return
+		case OverflowDropOldest:
+			q.callbacks = q.callbacks[1:]
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.closed || q.ctx.Err() != nil {
+		return
+	}
+	q.callbacks = append(q.callbacks, callback)
+	q.cond.Broadcast()
+}
+
+func (q *streamQueue) run() {
+	defer close(q.done)
+	for {
+		q.lock.Lock()
+		for len(q.callbacks) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		// The callbacks may not have been dropped yet if the context was only just cancelled
+		if len(q.callbacks) == 0 || q.ctx.Err() != nil {
+			q.lock.Unlock()
+			return
+		}
+		callback := q.callbacks[0]
+		q.callbacks = q.callbacks[1:]
+		q.cond.Broadcast()
+		q.lock.Unlock()
+		callback()
+	}
+}
+
+// Stop accepting callbacks, and wait for the queued ones to be delivered, unless the context is cancelled first.
+func (q *streamQueue) flush() {
+	q.lock.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.lock.Unlock()
+	select {
+	case <-q.done:
+	case <-q.ctx.Done():
+	}
+	q.stop()
+}
+
+type asyncMessageStreamer struct {
+	queue *streamQueue
+	inner MessageStreamer
+}
+
+func (s asyncMessageStreamer) TrySendMessage(msg Message) {
+	s.queue.push(func() { s.inner.TrySendMessage(msg) })
+}
+
+type asyncTextStreamer struct {
+	queue *streamQueue
+	inner TextStreamer
+}
+
+func (s asyncTextStreamer) TrySendTextChunk(chunk string) {
+	s.queue.push(func() { s.inner.TrySendTextChunk(chunk) })
+}
+
+func (s asyncTextStreamer) TryBeginTextStream() {
+	if lifecycle, ok := s.inner.(TextStreamLifecycle); ok {
+		s.queue.push(lifecycle.TryBeginTextStream)
+	}
+}
+
+func (s asyncTextStreamer) TryEndTextStream() {
+	if lifecycle, ok := s.inner.(TextStreamLifecycle); ok {
+		s.queue.push(lifecycle.TryEndTextStream)
+	}
+}
+
+func (s asyncTextStreamer) TryFailTextStream(err error) {
+	if lifecycle, ok := s.inner.(TextStreamLifecycle); ok {
+		s.queue.push(func() { lifecycle.TryFailTextStream(err) })
+	}
+}
+
+type asyncReActStreamer struct {
+	queue *streamQueue
+	inner ReActStreamer
+}
+
+func (s asyncReActStreamer) TrySendReasoningChunk(chunk string) {
+	s.queue.push(func() { s.inner.TrySendReasoningChunk(chunk) })
+}
+
+func (s asyncReActStreamer) TrySendToolCall(call ToolCall) {
+	s.queue.push(func() { s.inner.TrySendToolCall(call) })
+}
+
+// Wrap every streamer and event handler so that it is called from its own queue, until the context is cancelled.
+// The returned streamers must be flushed once the turn is over.
+func (s multiStreamers) async(ctx context.Context, config asyncStreamConfig) multiStreamers {
+	var out multiStreamers
+	newQueue := func() *streamQueue {
+		q := newStreamQueue(ctx, config)
+		out.queues = append(out.queues, q)
+		return q
+	}
+	for _, streamer := range s.msgStreamers {
+		out.msgStreamers = append(out.msgStreamers, asyncMessageStreamer{newQueue(), streamer})
+	}
+	for _, streamer := range s.respStreamers {
+		out.respStreamers = append(out.respStreamers, asyncTextStreamer{newQueue(), streamer})
+	}
+	for _, streamer := range s.reactStreamers {
+		out.reactStreamers = append(out.reactStreamers, asyncReActStreamer{newQueue(), streamer})
+	}
+	for _, handler := range s.eventHandlers {
+		q := newQueue()
+		out.eventHandlers = append(out.eventHandlers, func(e Event) {
+			q.push(func() { handler(e) })
+		})
+	}
+	return out
+}
+
+// Wait for any queued callbacks to be delivered.
+func (s multiStreamers) flush() {
+	for _, q := range s.queues {
+		q.flush()
+	}
+}
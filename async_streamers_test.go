@@ -0,0 +1,91 @@
+package react
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamQueuePolicies(t *testing.T) {
+	cases := []struct {
+		policy StreamOverflowPolicy
+		want   []int
+	}{
+		{OverflowBlock, []int{0, 1, 2, 3, 4}},
+		{OverflowDropOldest, []int{0, 3, 4}},
+		{OverflowDropNewest, []int{0, 1, 2}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			q := newStreamQueue(context.Background(), asyncStreamConfig{2, tc.policy})
+			var lock sync.Mutex
+			var got []int
+			release := make(chan struct{})
+			started := make(chan struct{})
+			// The first callback holds up the queue until the rest have been pushed
+			q.push(func() {
+				close(started)
+				<-release
+				lock.Lock()
+				got = append(got, 0)
+				lock.Unlock()
+			})
+			<-started
+			pushed := make(chan struct{})
+			go func() {
+				for i := 1; i <= 4; i++ {
+					q.push(func() {
+						lock.Lock()
+						got = append(got, i)
+						lock.Unlock()
+					})
+				}
+				close(pushed)
+			}()
+			if tc.policy != OverflowBlock {
+				<-pushed
+			}
+			close(release)
+			<-pushed
+			q.flush()
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestStreamQueueCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := newStreamQueue(ctx, asyncStreamConfig{1, OverflowBlock})
+	hang := make(chan struct{})
+	defer close(hang)
+	started := make(chan struct{})
+	q.push(func() {
+		close(started)
+		<-hang
+	})
+	<-started
+	q.push(func() { t.Error("expected the queued callback to be dropped") })
+	pushed := make(chan struct{})
+	go func() {
+		// Blocks as the queue is full
+		q.push(func() { t.Error("expected the blocked callback to be dropped") })
+		close(pushed)
+	}()
+	cancel()
+	flushed := make(chan struct{})
+	go func() {
+		q.flush()
+		close(flushed)
+	}()
+	for name, ch := range map[string]chan struct{}{"push": pushed, "flush": flushed} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to stop waiting once the context was cancelled", name)
+		}
+	}
+}
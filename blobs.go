@@ -0,0 +1,203 @@
+package react
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores large binary content outside of serialised messages.
+type BlobStore interface {
+	// Store the data, returning a reference that can be used to get it back.
+	Put(data []byte) (string, error)
+	// Get the data for a reference, returning [ErrBlobNotFound] if there is no such blob.
+	Get(ref string) ([]byte, error)
+}
+
+// NewMemoryBlobStore creates a [BlobStore] that keeps blobs in memory.
+func NewMemoryBlobStore() BlobStore {
+	return &memoryBlobStore{blobs: make(map[string][]byte)}
+}
+
+type memoryBlobStore struct {
+	lock  sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memoryBlobStore) Put(data []byte) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ref := blobRef(data)
+	s.blobs[ref] = slices.Clone(data)
+	return ref, nil
+}
+
+func (s *memoryBlobStore) Get(ref string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.blobs[ref]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return slices.Clone(data), nil
+}
+
+// NewDirBlobStore creates a [BlobStore] that keeps each blob as a file in the directory.
+func NewDirBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirBlobStore{dir}, nil
+}
+
+type dirBlobStore struct {
+	dir string
+}
+
+func (s *dirBlobStore) Put(data []byte) (string, error) {
+	ref := blobRef(data)
+	path, err := s.path(ref)
+	if err != nil {
+		return "", err
+	}
+	// Blobs are content addressed, so an existing file already has the same data
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil
+	}
+	// Write to a temporary file first, so a reader never sees a partly written blob
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *dirBlobStore) Get(ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+func (s *dirBlobStore) path(ref string) (string, error) {
+	hash, ok := strings.CutPrefix(ref, "sha256:")
+	if !ok {
+		return "", fmt.Errorf("invalid blob reference '%s'", ref)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid blob reference '%s'", ref)
+	}
+	return filepath.Join(s.dir, hash), nil
+}
+
+func blobRef(data []byte) string {
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// ExternaliseBlobs returns a copy of the serialised messages where the data of every content part
+// that is at least minSize bytes is moved into the store, leaving only a reference.
+func ExternaliseBlobs(smsgs []SerialisedMessage, store BlobStore, minSize int) ([]SerialisedMessage, error) {
+	return mapSerialisedParts(smsgs, func(p ContentPart) (ContentPart, error) {
+		if len(p.Data) < minSize || len(p.Data) == 0 {
+			return p, nil
+		}
+		ref, err := store.Put(p.Data)
+		if err != nil {
+			return ContentPart{}, err
+		}
+		p.Data = nil
+		p.BlobRef = ref
+		return p, nil
+	})
+}
+
+// InternaliseBlobs returns a copy of the serialised messages where the data of every externalised content part
+// is fetched back from the store.
+func InternaliseBlobs(smsgs []SerialisedMessage, store BlobStore) ([]SerialisedMessage, error) {
+	return mapSerialisedParts(smsgs, func(p ContentPart) (ContentPart, error) {
+		if p.BlobRef == "" {
+			return p, nil
+		}
+		data, err := store.Get(p.BlobRef)
+		if err != nil {
+			return ContentPart{}, fmt.Errorf("failed to get blob '%s': %w", p.BlobRef, err)
+		}
+		p.Data = data
+		p.BlobRef = ""
+		return p, nil
+	})
+}
+
+// Copy the serialised messages, transforming every content part (including those in replaced messages).
+func mapSerialisedParts(smsgs []SerialisedMessage, f func(ContentPart) (ContentPart, error)) ([]SerialisedMessage, error) {
+	if smsgs == nil {
+		return nil, nil
+	}
+	mapParts := func(parts []ContentPart) ([]ContentPart, error) {
+		if parts == nil {
+			return nil, nil
+		}
+		result := make([]ContentPart, len(parts))
+		for i, p := range parts {
+			var err error
+			result[i], err = f(p)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+	result := make([]SerialisedMessage, len(smsgs))
+	for i, sm := range smsgs {
+		var err error
+		sm.Parts, err = mapParts(sm.Parts)
+		if err != nil {
+			return nil, err
+		}
+		if sm.Responses != nil {
+			responses := make([]ToolResponse, len(sm.Responses))
+			for j, r := range sm.Responses {
+				r.Parts, err = mapParts(r.Parts)
+				if err != nil {
+					return nil, err
+				}
+				responses[j] = r
+			}
+			sm.Responses = responses
+		}
+		sm.Replaced, err = mapSerialisedParts(sm.Replaced, f)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = sm
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package react
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBlobStores(t *testing.T) {
+	dir := t.TempDir()
+	dirStore, err := NewDirBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name  string
+		store BlobStore
+	}{
+		{"memory", NewMemoryBlobStore()},
+		{"dir", dirStore},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := []byte("some blob data")
+			ref, err := tc.store.Put(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Putting the same data again gives the same reference
+			if again, err := tc.store.Put(data); err != nil || again != ref {
+				t.Fatalf("second put gave %q, %v", again, err)
+			}
+			got, err := tc.store.Get(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %q, want %q", got, data)
+			}
+			if _, err := tc.store.Get(blobRef([]byte("missing"))); !errors.Is(err, ErrBlobNotFound) {
+				t.Errorf("expected ErrBlobNotFound, got %v", err)
+			}
+		})
+	}
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the blob in the directory, got %d entries", len(entries))
+	}
+}
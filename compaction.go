@@ -0,0 +1,204 @@
+package react
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/JoshPattman/jpf"
+)
+
+// Summariser defines an object that can condense a span of conversation into a short summary.
+type Summariser interface {
+	// Summarise the messages, which may include the summaries of even earlier spans.
+	Summarise([]Message) (string, error)
+}
+
+// ContextSummariser is a [Summariser] that can be cancelled.
+// If a summariser implements this, SummariseContext is used instead of Summarise when compacting during a turn, with the context of the turn.
+type ContextSummariser interface {
+	Summariser
+	SummariseContext(context.Context, []Message) (string, error)
+}
+
+// ModelReportingSummariser is a [Summariser] that reports the name of the model that wrote each summary,
+// which is recorded as [MetaModel] on the summary message.
+// If a summariser implements this, SummariseReportingModel is used instead of SummariseContext or Summarise.
+// The summariser created by [NewSummariser] implements this, so summarisers that wrap it should implement it too, forwarding the name of the model.
+type ModelReportingSummariser interface {
+	Summariser
+	SummariseReportingModel(context.Context, []Message) (summary string, model string, err error)
+}
+
+// Summarise the messages with the summariser, passing the context if it accepts one, and returning the name of the model if it reports one.
+func summarise(ctx context.Context, summariser Summariser, msgs []Message) (string, string, error) {
+	switch s := summariser.(type) {
+	case ModelReportingSummariser:
+		return s.SummariseReportingModel(ctx, msgs)
+	case ContextSummariser:
+		summary, err := s.SummariseContext(ctx, msgs)
+		return summary, "", err
+	default:
+		summary, err := s.Summarise(msgs)
+		return summary, "", err
+	}
+}
+
+// NewSummariser creates a [Summariser] that uses the agent model to write summaries.
+func NewSummariser(modelBuilder AgentModelBuilder) Summariser {
+	return &llmSummariser{modelBuilder}
+}
+
+type llmSummariser struct {
+	modelBuilder AgentModelBuilder
+}
+
+func (s *llmSummariser) Summarise(msgs []Message) (string, error) {
+	return s.SummariseContext(context.Background(), msgs)
+}
+
+func (s *llmSummariser) SummariseContext(ctx context.Context, msgs []Message) (string, error) {
+	summary, _, err := s.SummariseReportingModel(ctx, msgs)
+	return summary, err
+}
+
+func (s *llmSummariser) SummariseReportingModel(ctx context.Context, msgs []Message) (string, string, error) {
+	model := s.modelBuilder.BuildAgentModel(nil, nil, nil)
+	pipeline := jpf.NewOneShotPipeline(s, jpf.NewStringParser(), nil, model)
+	result, _, err := pipeline.Call(ctx, msgs)
+	if err != nil {
+		return "", "", err
+	}
+	return result, builtModelName(model, s.modelBuilder), nil
+}
+
+func (s *llmSummariser) BuildInputMessages(msgs []Message) ([]jpf.Message, error) {
+	enc := &transcriptMessageConverter{}
+	VisitMessages(enc, msgs...)
+	systemPrompt := `You summarise the earlier part of a conversation between a user and an AI agent, so the agent can continue the conversation without the full history.
+	- Keep every fact, decision, user preference, and open task that may matter later.
+	- Keep the important results of tool calls, but not their raw output.
+	- Be concise, and respond with only the summary text.`
+	return []jpf.Message{
+		{
+			Role:    jpf.SystemRole,
+			Content: systemPrompt,
+		},
+		{
+			Role:    jpf.UserRole,
+			Content: "Here is the conversation to summarise:\n\n" + strings.Join(enc.lines, "\n"),
+		},
+	}, nil
+}
+
+// An encoder that writes the conversational messages as a plain text transcript
+type transcriptMessageConverter struct {
+	BaseMessageVisitor
+	lines []string
+}
+
+func (conv *transcriptMessageConverter) AddUser(content string, parts []ContentPart) {
+	conv.lines = append(conv.lines, "User: "+joinContent(content, describeParts(parts)))
+}
+func (conv *transcriptMessageConverter) AddAgent(content string) {
+	conv.lines = append(conv.lines, "Agent: "+content)
+}
+func (conv *transcriptMessageConverter) AddToolCalls(reasoning string, toolCalls []ToolCall) {
+	if reasoning != "" {
+		conv.lines = append(conv.lines, "Agent reasoning: "+reasoning)
+	}
+	for _, tc := range toolCalls {
+		args := make(map[string]any)
+		for _, a := range tc.ToolArgs {
+			args[a.ArgName] = a.ArgValue
+		}
+		argsData, _ := json.Marshal(args)
+		conv.lines = append(conv.lines, fmt.Sprintf("Tool call: %s %s", tc.ToolName, argsData))
+	}
+}
+func (conv *transcriptMessageConverter) AddToolResponse(responses []ToolResponse) {
+	for _, r := range responses {
+		conv.lines = append(conv.lines, "Tool response: "+joinContent(r.Response, describeParts(r.Parts)))
+	}
+}
+func (conv *transcriptMessageConverter) AddNotification(kind string, content string) {
+	conv.lines = append(conv.lines, fmt.Sprintf("Notification (%s): %s", kind, content))
+}
+func (conv *transcriptMessageConverter) AddSummary(summary string, replaced []Message) {
+	conv.lines = append(conv.lines, "Summary of earlier conversation: "+summary)
+}
+
+// Compact the history of the agent, replacing all but the most recent turns with a summary.
+// Does nothing if compaction is not enabled, or there are not enough turns to compact.
+func (ag *Agent) Compact() error {
+	return ag.compact(context.Background())
+}
+
+func (ag *Agent) compact(ctx context.Context) error {
+	if ag.compaction.summariser == nil {
+		return nil
+	}
+	kept, replaced, rest := splitForCompaction(ag.messages, ag.compaction.keepTurns)
+	if len(replaced) == 0 {
+		return nil
+	}
+	ctx = withRouteTurn(ctx, ag.turn)
+	summary, model, err := summarise(ctx, ag.compaction.summariser, redactMessages(ag.redaction.redactor, replaced))
+	if err != nil {
+		return err
+	}
+	meta := map[string]any{MetaTurn: ag.turn}
+	if model != "" {
+		meta[MetaModel] = model
+	}
+	summaryMsg := stampMessage(summaryMessage{Summary: summary, Replaced: replaced}, meta)
+	messages := append(kept, summaryMsg)
+	ag.messages = append(messages, rest...)
+	return ag.persistRewrite()
+}
+
+// Compact the history if the estimated prompt size is past the compaction threshold.
+func (ag *Agent) compactIfNeeded(ctx context.Context) error {
+	if ag.compaction.summariser == nil {
+		return nil
+	}
+	if ag.PromptTokens() < ag.compaction.threshold {
+		return nil
+	}
+	return ag.compact(ctx)
+}
+
+// Split the history into the state messages that must be kept from the compacted span,
+// the conversational messages that should be summarised, and the most recent turns which are left untouched.
+func splitForCompaction(msgs []Message, keepTurns int) (kept, replaced, rest []Message) {
+	turnStarts := make([]int, 0)
+	for i, m := range msgs {
+		if _, ok := m.(userMessage); ok {
+			turnStarts = append(turnStarts, i)
+		}
+	}
+	if len(turnStarts) <= keepTurns {
+		return nil, nil, msgs
+	}
+	cut := len(msgs)
+	if keepTurns > 0 {
+		cut = turnStarts[len(turnStarts)-keepTurns]
+		// Notifications for a turn are added just before its user message
+		for cut > 0 {
+			if _, ok := msgs[cut-1].(notificationMessage); !ok {
+				break
+			}
+			cut--
+		}
+	}
+	for _, m := range msgs[:cut] {
+		switch m.(type) {
+		case systemMessage, personalityMessage, skillMessage, toolsMessage:
+			kept = append(kept, m)
+		default:
+			replaced = append(replaced, m)
+		}
+	}
+	return kept, replaced, msgs[cut:]
+}
@@ -0,0 +1,108 @@
+package react
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func labelled(label string, m Message) Message {
+	return m.withInfo(MessageInfo{ID: label})
+}
+
+func labels(msgs []Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Info().ID
+	}
+	return out
+}
+
+func TestSplitForCompaction(t *testing.T) {
+	history := []Message{
+		labelled("system", systemMessage{Template: "You are an agent"}),
+		labelled("personality", personalityMessage{Personality: "Friendly"}),
+		labelled("tools", toolsMessage{}),
+		labelled("skills", skillMessage{}),
+		labelled("n1", notificationMessage{Notification: Notification{Kind: "time", Content: "9am"}}),
+		labelled("u1", userMessage{Content: "One"}),
+		labelled("calls1", toolCallsMessage{Reasoning: "Checking"}),
+		labelled("responses1", toolResponseMessage{}),
+		labelled("a1", agentMessage{Content: "First"}),
+		labelled("n2", notificationMessage{Notification: Notification{Kind: "time", Content: "10am"}}),
+		labelled("u2", userMessage{Content: "Two"}),
+		labelled("a2", agentMessage{Content: "Second"}),
+		labelled("skills2", skillMessage{}),
+		labelled("u3", userMessage{Content: "Three"}),
+		labelled("a3", agentMessage{Content: "Third"}),
+	}
+	cases := []struct {
+		keepTurns    int
+		wantKept     []string
+		wantReplaced []string
+		wantRest     []string
+	}{
+		{0,
+			[]string{"system", "personality", "tools", "skills", "skills2"},
+			[]string{"n1", "u1", "calls1", "responses1", "a1", "n2", "u2", "a2", "u3", "a3"},
+			[]string{},
+		},
+		{1,
+			[]string{"system", "personality", "tools", "skills", "skills2"},
+			[]string{"n1", "u1", "calls1", "responses1", "a1", "n2", "u2", "a2"},
+			[]string{"u3", "a3"},
+		},
+		// The notifications of the second turn stay with it
+		{2,
+			[]string{"system", "personality", "tools", "skills"},
+			[]string{"n1", "u1", "calls1", "responses1", "a1"},
+			[]string{"n2", "u2", "a2", "skills2", "u3", "a3"},
+		},
+		{3, []string{}, []string{}, labels(history)},
+		{5, []string{}, []string{}, labels(history)},
+	}
+	for _, tc := range cases {
+		kept, replaced, rest := splitForCompaction(history, tc.keepTurns)
+		if !slices.Equal(labels(kept), tc.wantKept) {
+			t.Errorf("keeping %d turns: expected to keep %v, got %v", tc.keepTurns, tc.wantKept, labels(kept))
+		}
+		if !slices.Equal(labels(replaced), tc.wantReplaced) {
+			t.Errorf("keeping %d turns: expected to replace %v, got %v", tc.keepTurns, tc.wantReplaced, labels(replaced))
+		}
+		if !slices.Equal(labels(rest), tc.wantRest) {
+			t.Errorf("keeping %d turns: expected to leave %v, got %v", tc.keepTurns, tc.wantRest, labels(rest))
+		}
+	}
+}
+
+func TestSummaryMessageRoundTrip(t *testing.T) {
+	replaced := []Message{
+		stampMessage(userMessage{Content: "Look at this", Parts: []ContentPart{TextPart("an attachment")}}, map[string]any{MetaTurn: 1}),
+		stampMessage(toolResponseMessage{Responses: []ToolResponse{{Response: "sunny"}}}, nil),
+		stampMessage(agentMessage{Content: "It is sunny"}, map[string]any{MetaModel: "fast"}),
+	}
+	summary := stampMessage(summaryMessage{Summary: "The user asked about the weather", Replaced: replaced}, map[string]any{MetaTurn: 2})
+	data, err := json.Marshal(SerialiseMessages([]Message{summary}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var smsgs []SerialisedMessage
+	if err := json.Unmarshal(data, &smsgs); err != nil {
+		t.Fatal(err)
+	}
+	restored := DeserialiseMessages(smsgs)
+	got, ok := restored[0].(summaryMessage)
+	if !ok {
+		t.Fatalf("expected a summary message, got %T", restored[0])
+	}
+	if !slices.Equal(labels(got.Replaced), labels(replaced)) {
+		t.Fatalf("expected the replaced messages %v, got %v", labels(replaced), labels(got.Replaced))
+	}
+	again, err := json.Marshal(SerialiseMessages(restored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Fatalf("expected the summary to serialise the same after a round trip\nwant %s\ngot  %s", data, again)
+	}
+}
@@ -0,0 +1,118 @@
+package react_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+// A model builder that reports the name of its model.
+type namedBuilder struct {
+	*reacttest.ModelBuilder
+	name string
+}
+
+func (b namedBuilder) ModelName() string { return b.name }
+
+// A summariser that wraps another, forwarding the name of the model.
+type wrappingSummariser struct {
+	inner react.ModelReportingSummariser
+}
+
+func (s wrappingSummariser) Summarise(msgs []react.Message) (string, error) {
+	return s.inner.Summarise(msgs)
+}
+
+func (s wrappingSummariser) SummariseReportingModel(ctx context.Context, msgs []react.Message) (string, string, error) {
+	summary, model, err := s.inner.SummariseReportingModel(ctx, msgs)
+	return "Wrapped: " + summary, model, err
+}
+
+func TestCompact(t *testing.T) {
+	cases := []struct {
+		name       string
+		keepTurns  int
+		wrap       bool
+		wantUsers  []string
+		wantPrompt string
+	}{
+		{"keep the last turn", 1, false, []string{"Two"}, "User: One"},
+		{"keep no turns", 0, false, nil, "User: Two"},
+		{"wrapped summariser", 1, true, []string{"Two"}, "User: One"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mb := reacttest.NewModelBuilder(t)
+			var summariser react.Summariser = react.NewSummariser(namedBuilder{mb, "summary-model"})
+			if tc.wrap {
+				summariser = wrappingSummariser{summariser.(react.ModelReportingSummariser)}
+			}
+			// Compaction is only triggered manually
+			ag := react.New(mb,
+				react.WithPersonality("Friendly"),
+				react.WithSkills(react.Skill{Key: "polite", Content: "Always be polite"}),
+				react.WithCompaction(summariser, 1<<30, tc.keepTurns),
+			)
+			for _, msg := range []string{"One", "Two"} {
+				mb.QueueReAct("Done")
+				mb.QueueFinalAnswer("Answer to " + msg)
+				if _, err := ag.Send(msg, react.WithNotifications(react.Notification{Kind: "time", Content: "At " + msg})); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := slices.Collect(ag.Messages())
+			mb.QueueSummary("The user counted")
+			if err := ag.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			mb.AssertPromptContains(reacttest.CallSummary, 0, tc.wantPrompt)
+			mb.AssertExhausted()
+
+			var summary react.SerialisedMessage
+			var users []string
+			kinds := make(map[react.SerialisedMessageKind]int)
+			for _, sm := range react.SerialiseMessages(slices.Collect(ag.Messages())) {
+				kinds[sm.Kind]++
+				switch sm.Kind {
+				case react.KindSummary:
+					summary = sm
+				case react.KindUser:
+					users = append(users, sm.Content)
+				}
+			}
+			if !slices.Equal(users, tc.wantUsers) {
+				t.Errorf("expected the user messages %v to be kept, got %v", tc.wantUsers, users)
+			}
+			for _, kind := range []react.SerialisedMessageKind{react.KindSystem, react.KindPersonality, react.KindSkills, react.KindAvailableTools} {
+				if kinds[kind] == 0 {
+					t.Errorf("expected the %s message to be kept", kind)
+				}
+			}
+			if kinds[react.KindNotification] != len(tc.wantUsers) {
+				t.Errorf("expected the notifications to stay with their turns, got %d", kinds[react.KindNotification])
+			}
+			if want := 2 - len(tc.wantUsers); summary.Replaced == nil || countKind(summary.Replaced, react.KindUser) != want {
+				t.Errorf("expected the summary to replace %d user messages, got %+v", want, summary.Replaced)
+			}
+			if model, _ := summary.Metadata[react.MetaModel].(string); model != "summary-model" {
+				t.Errorf("expected the summary to record its model, got %q", model)
+			}
+			if got := len(slices.Collect(ag.Messages())); got >= len(before) {
+				t.Errorf("expected the history to shrink from %d messages, got %d", len(before), got)
+			}
+		})
+	}
+}
+
+func countKind(smsgs []react.SerialisedMessage, kind react.SerialisedMessageKind) int {
+	n := 0
+	for _, sm := range smsgs {
+		if sm.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
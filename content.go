@@ -0,0 +1,163 @@
+package react
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"github.com/JoshPattman/jpf"
+)
+
+type ContentPartKind string
+
+const (
+	PartText  ContentPartKind = "text"
+	PartImage ContentPartKind = "image"
+	PartFile  ContentPartKind = "file"
+)
+
+// ContentPart is a piece of content, other than the main text, that is attached to a user message or tool response.
+type ContentPart struct {
+	Kind ContentPartKind `json:"kind"`
+	// The text of a text part.
+	Text string `json:"text,omitempty"`
+	// The MIME type of an image or file part.
+	MIMEType string `json:"mime_type,omitempty"`
+	// The raw bytes of an image or file part. May be empty if the content is referenced by URL.
+	Data []byte `json:"data,omitempty"`
+	// A URL (or other reference) pointing to the content of an image or file part.
+	URL string `json:"url,omitempty"`
+	// The name of a file part.
+	Name string `json:"name,omitempty"`
+	// Set instead of Data when the data was moved to a [BlobStore] by [ExternaliseBlobs].
+	BlobRef string `json:"blob_ref,omitempty"`
+}
+
+// TextPart creates a [ContentPart] containing extra text.
+func TextPart(text string) ContentPart {
+	return ContentPart{Kind: PartText, Text: text}
+}
+
+// ImagePart creates a [ContentPart] containing an encoded image (such as a png or jpeg).
+func ImagePart(mimeType string, data []byte) ContentPart {
+	return ContentPart{Kind: PartImage, MIMEType: mimeType, Data: data}
+}
+
+// ImageURLPart creates a [ContentPart] referencing an image by URL.
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Kind: PartImage, URL: url}
+}
+
+// FilePart creates a [ContentPart] containing a file, such as a pdf.
+func FilePart(name, mimeType string, data []byte) ContentPart {
+	return ContentPart{Kind: PartFile, Name: name, MIMEType: mimeType, Data: data}
+}
+
+// FileRefPart creates a [ContentPart] referencing a file by URL (or other reference).
+func FileRefPart(name, mimeType, url string) ContentPart {
+	return ContentPart{Kind: PartFile, Name: name, MIMEType: mimeType, URL: url}
+}
+
+// MultimodalTool is a [Tool] that can also respond with content parts, such as images.
+// If a tool implements this, CallMultimodal is used instead of Call.
+type MultimodalTool interface {
+	Tool
+	// Call the tool, providing a formatted response with any extra content parts, or an error if the tool call failed.
+	CallMultimodal(map[string]any) (string, []ContentPart, error)
+}
+
+// ContextTool is a [Tool] that can be cancelled, and can also respond with content parts.
+// If a tool implements this, CallContext is used instead of Call or CallMultimodal, with the context of the turn.
+type ContextTool interface {
+	Tool
+	// Call the tool like CallMultimodal, stopping early if the context is cancelled.
+	CallContext(context.Context, map[string]any) (string, []ContentPart, error)
+}
+
+// Convert content parts into the extra text and images that jpf supports.
+// Content that jpf cannot represent is described in the text instead.
+func partsToJpf(parts []ContentPart) (string, []jpf.ImageAttachment) {
+	texts := make([]string, 0)
+	images := make([]jpf.ImageAttachment, 0)
+	for _, p := range parts {
+		switch p.Kind {
+		case PartText:
+			texts = append(texts, p.Text)
+		case PartImage:
+			if len(p.Data) > 0 {
+				img, _, err := image.Decode(bytes.NewReader(p.Data))
+				if err == nil {
+					images = append(images, jpf.ImageAttachment{Source: img})
+					continue
+				}
+			}
+			texts = append(texts, describePart(p))
+		case PartFile:
+			if text := inlineTextFile(p); text != "" {
+				texts = append(texts, fmt.Sprintf("[Attached file '%s']\n%s", p.Name, text))
+				continue
+			}
+			texts = append(texts, describePart(p))
+		}
+	}
+	return strings.Join(texts, "\n"), images
+}
+
+// Describe a content part that cannot be shown directly in a short piece of text.
+func describePart(p ContentPart) string {
+	desc := fmt.Sprintf("[Attached %s", p.Kind)
+	if p.Name != "" {
+		desc += fmt.Sprintf(" '%s'", p.Name)
+	}
+	if p.MIMEType != "" {
+		desc += fmt.Sprintf(" (%s)", p.MIMEType)
+	}
+	if p.URL != "" {
+		desc += fmt.Sprintf(" at %s", p.URL)
+	}
+	if len(p.Data) > 0 {
+		desc += fmt.Sprintf(", %d bytes, which cannot be shown", len(p.Data))
+	}
+	return desc + "]"
+}
+
+// Describe all of the content parts in text, without including any data.
+func describeParts(parts []ContentPart) string {
+	descs := make([]string, len(parts))
+	for i, p := range parts {
+		if p.Kind == PartText {
+			descs[i] = p.Text
+		} else {
+			descs[i] = describePart(p)
+		}
+	}
+	return strings.Join(descs, "\n")
+}
+
+// The content of a file part that is shown to the model as text, or an empty string if it is not shown inline.
+func inlineTextFile(p ContentPart) string {
+	if p.Kind != PartFile || len(p.Data) == 0 || !isTextMIMEType(p.MIMEType) {
+		return ""
+	}
+	return string(p.Data)
+}
+
+func isTextMIMEType(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "text/") || mimeType == "application/json" || mimeType == "application/xml"
+}
+
+// Append the extra text to the main text, if there is any.
+func joinContent(content string, extra string) string {
+	if extra == "" {
+		return content
+	}
+	if content == "" {
+		return extra
+	}
+	return content + "\n" + extra
+}
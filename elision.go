@@ -0,0 +1,86 @@
+package react
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const retrieveToolOutputToolName = "retrieve_tool_output"
+
+// Create the placeholder that is shown to the model in place of an elided tool response.
+func elidedToolResponse(toolName string, response string, handle string) string {
+	return fmt.Sprintf(
+		"[The output of tool '%s' (%d bytes) was elided as it is from an old turn. Use the '%s' tool with handle '%s' to see it again]",
+		toolName,
+		len(response),
+		retrieveToolOutputToolName,
+		handle,
+	)
+}
+
+// The handle of the i'th response in the tool response message with the given ID.
+// Message IDs are stable, so handles stay valid when the history is compacted or rewound.
+func toolOutputHandle(messageID string, i int) string {
+	return fmt.Sprintf("%s.%d", messageID, i)
+}
+
+// Find the tool response message with the ID, including any replaced by a summary.
+func findToolResponseMessage(msgs []Message, id string) (toolResponseMessage, bool) {
+	for _, m := range msgs {
+		switch m := m.(type) {
+		case toolResponseMessage:
+			if m.Info().ID == id {
+				return m, true
+			}
+		case summaryMessage:
+			if found, ok := findToolResponseMessage(m.Replaced, id); ok {
+				return found, true
+			}
+		}
+	}
+	return toolResponseMessage{}, false
+}
+
+// A tool that allows the agent to re-fetch a tool response that was elided from its prompt.
+type retrieveToolOutputTool struct {
+	agent *Agent
+}
+
+func (t *retrieveToolOutputTool) Name() string {
+	return retrieveToolOutputToolName
+}
+
+func (t *retrieveToolOutputTool) Description() []string {
+	return []string{
+		"Retrieves the full output of an earlier tool call that was elided from the conversation.",
+		"Takes a single string argument `handle`, which is the handle given in place of the elided output.",
+	}
+}
+
+func (t *retrieveToolOutputTool) Call(args map[string]any) (string, error) {
+	response, parts, err := t.CallMultimodal(args)
+	if err != nil {
+		return "", err
+	}
+	return joinContent(response, describeParts(parts)), nil
+}
+
+// The parts of the output (such as images) are returned too, so they are shown to the agent again.
+func (t *retrieveToolOutputTool) CallMultimodal(args map[string]any) (string, []ContentPart, error) {
+	handle, ok := args["handle"].(string)
+	if !ok {
+		return "", nil, errors.New("the `handle` argument must be a string")
+	}
+	id, index, ok := strings.Cut(handle, ".")
+	i, err := strconv.Atoi(index)
+	if !ok || err != nil {
+		return "", nil, fmt.Errorf("invalid handle '%s'", handle)
+	}
+	m, ok := findToolResponseMessage(t.agent.messages, id)
+	if !ok || i < 0 || i >= len(m.Responses) {
+		return "", nil, fmt.Errorf("there is no tool output with handle '%s'", handle)
+	}
+	return m.Responses[i].Response, m.Responses[i].Parts, nil
+}
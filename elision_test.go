@@ -0,0 +1,71 @@
+package react
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var handlePattern = regexp.MustCompile(`handle '([^']+)'`)
+
+func TestElidedToolOutputHandle(t *testing.T) {
+	stamp := func(m Message) Message { return stampMessage(m, nil) }
+	calls := stamp(toolCallsMessage{ToolCalls: []ToolCall{{ToolName: "a"}, {ToolName: "b"}}})
+	responses := stamp(toolResponseMessage{Responses: []ToolResponse{{Response: "first"}, {Response: "second", Parts: []ContentPart{TextPart("extra")}}}})
+	history := []Message{
+		stamp(systemMessage{Template: "system"}),
+		stamp(userMessage{Content: "one"}),
+		calls,
+		responses,
+		stamp(agentMessage{Content: "done"}),
+		stamp(userMessage{Content: "two"}),
+	}
+	enc := &messagesEncoder{elideAfterTurns: 1}
+	var handles []string
+	for _, m := range enc.encode(history) {
+		for _, match := range handlePattern.FindAllStringSubmatch(m.Content, -1) {
+			handles = append(handles, match[1])
+		}
+	}
+	if len(handles) != 2 {
+		t.Fatalf("expected 2 elided outputs, got %v", handles)
+	}
+
+	cases := []struct {
+		name     string
+		messages []Message
+	}{
+		{"unchanged", history},
+		{"rewound", append([]Message{history[0]}, history[1:4]...)},
+		{"compacted", []Message{history[0], summaryMessage{Summary: "summary", Replaced: history[1:5]}, history[5]}},
+		{"earlier messages dropped", history[3:]},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tool := &retrieveToolOutputTool{&Agent{messages: tc.messages}}
+			for i, want := range []string{"first", "second"} {
+				got, err := tool.Call(map[string]any{"handle": handles[i]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(got, want) {
+					t.Errorf("handle %s: got %q, want %q", handles[i], got, want)
+				}
+			}
+		})
+	}
+
+	tool := &retrieveToolOutputTool{&Agent{messages: history}}
+	_, parts, err := tool.CallMultimodal(map[string]any{"handle": handles[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 1 || parts[0].Text != "extra" {
+		t.Errorf("expected the parts of the output to be retrieved, got %v", parts)
+	}
+	for _, handle := range []string{"nope", responses.Info().ID + ".2", "MISSING.0"} {
+		if _, err := tool.Call(map[string]any{"handle": handle}); err == nil {
+			t.Errorf("expected an error for handle %q", handle)
+		}
+	}
+}
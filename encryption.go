@@ -0,0 +1,183 @@
+package react
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrKeyNotFound = errors.New("encryption key not found")
+var ErrDecryptionFailed = errors.New("failed to decrypt data (wrong key or tampered data)")
+
+// KeyProvider provides the AES keys used to encrypt conversations.
+// Data is always encrypted with the current key, and the id of that key is stored alongside it so the right key can be found to decrypt it.
+// To rotate keys, make a new key current while keeping the old keys available, then re-encrypt any stored data (see [RotateConversationStoreKeys]).
+type KeyProvider interface {
+	// Get the id and the key that new data should be encrypted with.
+	CurrentKey() (string, []byte, error)
+	// Get the key with the id, returning [ErrKeyNotFound] if it does not exist.
+	Key(id string) ([]byte, error)
+}
+
+// NewStaticKeyProvider creates a [KeyProvider] from a fixed set of keys, where currentID is the id of the key to encrypt with.
+// Each key must be 16, 24, or 32 bytes long, to use AES-128, AES-192, or AES-256.
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) (KeyProvider, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("%w: current key '%s'", ErrKeyNotFound, currentID)
+	}
+	for id, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %w", id, err)
+		}
+	}
+	return &staticKeyProvider{currentID, keys}, nil
+}
+
+type staticKeyProvider struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+func (p *staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.currentID, p.keys[p.currentID], nil
+}
+
+func (p *staticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+const encryptionAlgorithm = "aes-gcm"
+
+// The json form of encrypted data.
+type encryptedData struct {
+	Algorithm  string `json:"alg"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptConversation serialises the messages as in [MarshalConversation], then encrypts them with the current key of the provider.
+func EncryptConversation(msgs []Message, keys KeyProvider) ([]byte, error) {
+	data, err := MarshalConversation(msgs)
+	if err != nil {
+		return nil, err
+	}
+	return encryptData(keys, data, nil)
+}
+
+// DecryptConversation decrypts and reads messages written by [EncryptConversation], as in [UnmarshalConversation].
+func DecryptConversation(data []byte, keys KeyProvider) ([]Message, error) {
+	plaintext, err := decryptData(keys, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalConversation(plaintext)
+}
+
+// Encrypt the plaintext with the current key, returning json encrypted data.
+// The additional data is not stored, but the same additional data must be given to decrypt it.
+func encryptData(keys KeyProvider, plaintext, additionalData []byte) ([]byte, error) {
+	keyID, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedData{
+		Algorithm:  encryptionAlgorithm,
+		KeyID:      keyID,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, additionalData),
+	})
+}
+
+// Decrypt json encrypted data written by encryptData.
+func decryptData(keys KeyProvider, data, additionalData []byte) ([]byte, error) {
+	var enc encryptedData
+	if err := json.Unmarshal(data, &enc); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	if enc.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm '%s'", enc.Algorithm)
+	}
+	key, err := keys.Key(enc.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(enc.Nonce) != gcm.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := gcm.Open(nil, enc.Nonce, enc.Ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewEncryptedFileConversationStore creates a [ConversationStore] like [NewFileConversationStore],
+// but each message is encrypted with the current key of the provider before it is written.
+// Each message is bound to its conversation, its position in it, and the header of the file,
+// so messages that are changed, reordered, removed from the middle, or moved between conversations (or between versions of the file) are detected when loading.
+// This does not protect against the last messages being removed, or against the whole file being replaced by an older version of itself.
+// The number and size of the messages, and the ids of the keys, are not encrypted.
+func NewEncryptedFileConversationStore(dir string, keys KeyProvider) (ConversationStore, error) {
+	store, err := NewFileConversationStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	store.(*fileConversationStore).keys = keys
+	return store, nil
+}
+
+// RotateConversationStoreKeys rewrites every conversation in the store.
+// For an encrypted store, this re-encrypts them all with the current key, so old keys can then be removed from the key provider.
+// Stores that implement [ConversationUpdater] (such as the file stores) are updated one conversation at a time,
+// so messages appended through the same store while rotating are not lost.
+// Other stores are loaded then rewritten, so should not be appended to while rotating.
+func RotateConversationStoreKeys(store ConversationStore) error {
+	ids, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if updater, ok := store.(ConversationUpdater); ok {
+			if err := updater.Update(id, func(msgs []Message) ([]Message, error) { return msgs, nil }); err != nil {
+				return fmt.Errorf("failed to rewrite conversation '%s': %w", id, err)
+			}
+			continue
+		}
+		msgs, err := store.Load(id)
+		if err != nil {
+			return fmt.Errorf("failed to load conversation '%s': %w", id, err)
+		}
+		if err := store.Rewrite(id, msgs); err != nil {
+			return fmt.Errorf("failed to rewrite conversation '%s': %w", id, err)
+		}
+	}
+	return nil
+}
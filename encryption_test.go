@@ -0,0 +1,306 @@
+package react
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T, current string, ids ...string) KeyProvider {
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), 32)
+	}
+	provider, err := NewStaticKeyProvider(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func testMessages(n int) []Message {
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msgs[i] = stampMessage(userMessage{Content: string(rune('a' + i))}, nil)
+	}
+	return msgs
+}
+
+func messageContents(msgs []Message) []string {
+	out := make([]string, len(msgs))
+	for i, sm := range SerialiseMessages(msgs) {
+		out[i] = sm.Content
+	}
+	return out
+}
+
+func TestEncryptConversation(t *testing.T) {
+	msgs := testMessages(3)
+	data, err := EncryptConversation(msgs, testKeys(t, "a", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"content"`)) {
+		t.Error("the encrypted conversation contains plaintext")
+	}
+	tampered := bytes.Clone(data)
+	var enc encryptedData
+	json.Unmarshal(tampered, &enc)
+	enc.Ciphertext[0] ^= 1
+	tampered, _ = json.Marshal(enc)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		keys    KeyProvider
+		wantErr error
+	}{
+		{"round trip", data, testKeys(t, "a", "a"), nil},
+		{"rotated provider", data, testKeys(t, "b", "a", "b"), nil},
+		{"missing key", data, testKeys(t, "b", "b"), ErrKeyNotFound},
+		{"tampered", tampered, testKeys(t, "a", "a"), ErrDecryptionFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecryptConversation(tc.data, tc.keys)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if err == nil && !slices.Equal(messageContents(got), messageContents(msgs)) {
+				t.Errorf("got %v, want %v", messageContents(got), messageContents(msgs))
+			}
+		})
+	}
+}
+
+func TestEncryptedFileConversationStoreTampering(t *testing.T) {
+	msgs := testMessages(4)
+	// Write the conversation, returning the lines of its file
+	write := func(t *testing.T, store ConversationStore, dir, id string) [][]byte {
+		if err := store.Append(id, msgs[:2]...); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Append(id, msgs[2:]...); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, id+conversationFileExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	}
+	cases := []struct {
+		name string
+		// Change the lines of conversation "c", given the lines of a different conversation "other",
+		// and the lines of "c" before it was last rewritten.
+		tamper  func(lines, other, old [][]byte) [][]byte
+		wantErr bool
+	}{
+		{"untouched", func(lines, other, old [][]byte) [][]byte { return lines }, false},
+		{"reordered", func(lines, other, old [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, true},
+		{"removed from the middle", func(lines, other, old [][]byte) [][]byte {
+			return slices.Delete(lines, 2, 3)
+		}, true},
+		{"header changed", func(lines, other, old [][]byte) [][]byte {
+			lines[0] = other[0]
+			return lines
+		}, true},
+		{"moved from another conversation", func(lines, other, old [][]byte) [][]byte {
+			lines[1] = other[1]
+			return lines
+		}, true},
+		{"moved from an older version", func(lines, other, old [][]byte) [][]byte {
+			lines[1] = old[1]
+			return lines
+		}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := NewEncryptedFileConversationStore(dir, testKeys(t, "a", "a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other := write(t, store, dir, "other")
+			old := write(t, store, dir, "c")
+			if err := store.Rewrite("c", msgs); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "c"+conversationFileExt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tc.tamper(bytes.Split(bytes.TrimSpace(data), []byte("\n")), other, old)
+			if err := os.WriteFile(filepath.Join(dir, "c"+conversationFileExt), append(bytes.Join(lines, []byte("\n")), '\n'), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.Load("c")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected the tampering to be detected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(messageContents(got), messageContents(msgs)) {
+				t.Errorf("got %v, want %v", messageContents(got), messageContents(msgs))
+			}
+		})
+	}
+}
+
+func TestEncryptedFileConversationStoreLegacyFile(t *testing.T) {
+	// Files written before lines were bound to their position only bind lines to the conversation
+	dir := t.TempDir()
+	keys := testKeys(t, "a", "a")
+	header, _ := json.Marshal(fileConversationHeader{Version: SerialisationVersion, Encrypted: true})
+	file := append(header, '\n')
+	msgs := testMessages(2)
+	for _, sm := range SerialiseMessages(msgs) {
+		line, _ := json.Marshal(sm)
+		enc, err := encryptData(keys, line, []byte("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file = append(append(file, enc...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c"+conversationFileExt), file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewEncryptedFileConversationStore(dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("c", testMessages(3)[2]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(messageContents(got), want) {
+		t.Errorf("got %v, want %v", messageContents(got), want)
+	}
+}
+
+func TestRotateConversationStoreKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldStore, err := NewEncryptedFileConversationStore(dir, testKeys(t, "a", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := testMessages(3)
+	for _, id := range []string{"c1", "c2"} {
+		if err := oldStore.Append(id, msgs...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotating, err := NewEncryptedFileConversationStore(dir, testKeys(t, "b", "a", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RotateConversationStoreKeys(rotating); err != nil {
+		t.Fatal(err)
+	}
+	// The old key is no longer needed
+	newStore, err := NewEncryptedFileConversationStore(dir, testKeys(t, "b", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		got, err := newStore.Load(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(messageContents(got), messageContents(msgs)) {
+			t.Errorf("%s: got %v, want %v", id, messageContents(got), messageContents(msgs))
+		}
+	}
+}
+
+func TestEncryptedFileConversationStoreAppendCache(t *testing.T) {
+	dir := t.TempDir()
+	keys := testKeys(t, "a", "a")
+	first, err := NewEncryptedFileConversationStore(dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewEncryptedFileConversationStore(dir, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := testMessages(6)
+	steps := []struct {
+		write func() error
+		want  []Message
+	}{
+		{func() error { return first.Append("c", msgs[0]) }, msgs[:1]},
+		{func() error { return first.Append("c", msgs[1]) }, msgs[:2]},
+		// Written by another store, so the first store must read the file again
+		{func() error { return second.Append("c", msgs[2]) }, msgs[:3]},
+		{func() error { return first.Append("c", msgs[3]) }, msgs[:4]},
+		{func() error { return second.Rewrite("c", msgs[:3]) }, msgs[:3]},
+		{func() error { return first.Append("c", msgs[4]) }, []Message{msgs[0], msgs[1], msgs[2], msgs[4]}},
+		{func() error { return first.Rewrite("c", msgs[:5]) }, msgs[:5]},
+		// Rewritten by another store to the same size, but with a new header
+		{func() error { return second.Rewrite("c", msgs[:5]) }, msgs[:5]},
+		{func() error { return first.Append("c", msgs[5]) }, msgs},
+	}
+	for i, step := range steps {
+		if err := step.write(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		got, err := second.Load("c")
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if !slices.Equal(messageContents(got), messageContents(step.want)) {
+			t.Fatalf("step %d: got %v, want %v", i, messageContents(got), messageContents(step.want))
+		}
+	}
+}
+
+func TestFileConversationStoreUpdate(t *testing.T) {
+	store, err := NewEncryptedFileConversationStore(t.TempDir(), testKeys(t, "a", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := testMessages(3)
+	if err := store.Append("c", msgs[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	appended := make(chan error)
+	err = store.(ConversationUpdater).Update("c", func(loaded []Message) ([]Message, error) {
+		// Appending while the conversation is being updated must wait until it has been rewritten
+		go func() { appended <- store.Append("c", msgs[2]) }()
+		select {
+		case err := <-appended:
+			t.Fatalf("expected the append to wait for the update, got %v", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		return loaded, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-appended; err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Load("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(messageContents(got), messageContents(msgs)) {
+		t.Fatalf("expected the appended message to be kept, got %v", messageContents(got))
+	}
+}
@@ -0,0 +1,159 @@
+package react
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+)
+
+type EventKind string
+
+const (
+	EventMessageAppended   EventKind = "message_appended"
+	EventReasoningChunk    EventKind = "reasoning_chunk"
+	EventToolCallGenerated EventKind = "tool_call_generated"
+	EventReasoning         EventKind = "reasoning"
+	EventToolCallStart     EventKind = "tool_call_start"
+	EventToolCallEnd       EventKind = "tool_call_end"
+	EventTextStreamBegin   EventKind = "text_stream_begin"
+	EventTextChunk         EventKind = "text_chunk"
+	EventTextStreamEnd     EventKind = "text_stream_end"
+	EventTextStreamFail    EventKind = "text_stream_fail"
+	EventFinalAnswerStart  EventKind = "final_answer_start"
+	EventFinalAnswerEnd    EventKind = "final_answer_end"
+	EventTurnComplete      EventKind = "turn_complete"
+)
+
+// Event is something that happened while the agent was answering a message.
+// Use a type switch to find out which event it is.
+type Event interface {
+	Kind() EventKind
+}
+
+// A message was added to the history.
+type MessageAppendedEvent struct {
+	Message Message
+}
+
+// A chunk of the reasoning of the agent was generated.
+type ReasoningChunkEvent struct {
+	Chunk string
+}
+
+// The agent finished generating a tool call, though it will not be called until all of the tool calls are generated.
+// This is sent before the response is fully parsed, so the call may never be made (see [ReActStreamer]).
+type ToolCallGeneratedEvent struct {
+	Call ToolCall
+}
+
+// The agent reasoned about what to do next, before calling any tools.
+type ReasoningEvent struct {
+	Reasoning string
+}
+
+// The agent is about to call a tool.
+type ToolCallStartEvent struct {
+	// The position of the call within the tool calls the agent made at once.
+	Index int
+	Call  ToolCall
+}
+
+// A tool call finished.
+type ToolCallEndEvent struct {
+	Index    int
+	Call     ToolCall
+	Response ToolResponse
+}
+
+// The final answer started streaming.
+// This happens again if the model call is retried, in which case any text chunks already received should be discarded.
+type TextStreamBeginEvent struct{}
+
+// A chunk of the final answer was streamed back.
+type TextChunkEvent struct {
+	Chunk string
+}
+
+// The final answer finished streaming successfully.
+type TextStreamEndEvent struct{}
+
+// The final answer failed part way through streaming, or before it started.
+type TextStreamFailEvent struct {
+	Err error
+}
+
+// The agent started writing its final answer.
+type FinalAnswerStartEvent struct{}
+
+// The agent finished writing its final answer.
+type FinalAnswerEndEvent struct {
+	Content string
+	// Whether the answer was cut off part way through, and only kept because of [WithPartialAnswerRecovery].
+	// The turn then ends with an error wrapping [ErrPartialAnswer], instead of a [TurnCompleteEvent].
+	Partial bool
+}
+
+// The turn finished successfully. This is always the last event of a turn.
+type TurnCompleteEvent struct {
+	Turn     int
+	Response string
+}
+
+func (MessageAppendedEvent) Kind() EventKind   { return EventMessageAppended }
+func (ReasoningChunkEvent) Kind() EventKind    { return EventReasoningChunk }
+func (ToolCallGeneratedEvent) Kind() EventKind { return EventToolCallGenerated }
+func (ReasoningEvent) Kind() EventKind         { return EventReasoning }
+func (ToolCallStartEvent) Kind() EventKind     { return EventToolCallStart }
+func (ToolCallEndEvent) Kind() EventKind       { return EventToolCallEnd }
+func (TextStreamBeginEvent) Kind() EventKind   { return EventTextStreamBegin }
+func (TextChunkEvent) Kind() EventKind         { return EventTextChunk }
+func (TextStreamEndEvent) Kind() EventKind     { return EventTextStreamEnd }
+func (TextStreamFailEvent) Kind() EventKind    { return EventTextStreamFail }
+func (FinalAnswerStartEvent) Kind() EventKind  { return EventFinalAnswerStart }
+func (FinalAnswerEndEvent) Kind() EventKind    { return EventFinalAnswerEnd }
+func (TurnCompleteEvent) Kind() EventKind      { return EventTurnComplete }
+
+// SendStream sends a message to the agent like [Agent.Send], yielding the events of the turn as they happen.
+// If the turn fails, the last value yielded is the error (with a nil event).
+// If the loop is broken early, the context passed to the models is cancelled, and the iterator waits for the agent to stop before returning,
+// so the agent can safely be used again afterwards.
+// The turn is not rolled back, so the user message (and anything else added before the agent stopped) stays in the history without an answer.
+// Use [Agent.Rewind] with [Agent.Turn] to remove the unfinished turn if it should not be kept.
+// If the agent panics, the panic is recovered and yielded as an error.
+func (ag *Agent) SendStream(ctx context.Context, msg string, opts ...SendMessageOpt) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events := make(chan Event)
+		result := make(chan error, 1)
+		handler := func(e Event) {
+			select {
+			case events <- e:
+			case <-ctx.Done():
+			}
+		}
+		go func() {
+			defer close(events)
+			defer func() {
+				if r := recover(); r != nil {
+					result <- fmt.Errorf("agent panicked: %v", r)
+				}
+			}()
+			_, err := ag.Send(msg, append(slices.Clone(opts), WithContext(ctx), WithEventHandler(handler))...)
+			result <- err
+		}()
+		for e := range events {
+			if !yield(e, nil) {
+				cancel()
+				// Wait for the agent to stop, events are dropped now the context is cancelled
+				for range events {
+				}
+				return
+			}
+		}
+		if err := <-result; err != nil {
+			yield(nil, err)
+		}
+	}
+}
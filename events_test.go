@@ -0,0 +1,261 @@
+package react_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/jpf"
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func TestSendStream(t *testing.T) {
+	errModel := errors.New("model unavailable")
+	cases := []struct {
+		name    string
+		script  func(mb *reacttest.ModelBuilder)
+		tool    *reacttest.Tool
+		wantErr string
+	}{
+		{"completes", func(mb *reacttest.ModelBuilder) {
+			mb.QueueReAct("I need the time", reacttest.Call("time", nil))
+			mb.QueueReAct("I have the time")
+			mb.QueueFinalAnswer("It is 12:00")
+		}, reacttest.NewTool("time", "12:00"), ""},
+		{"model error", func(mb *reacttest.ModelBuilder) {
+			mb.QueueError(reacttest.CallReAct, errModel)
+		}, reacttest.NewTool("time", "12:00"), errModel.Error()},
+		{"panicking tool", func(mb *reacttest.ModelBuilder) {
+			mb.QueueReAct("I need the time", reacttest.Call("time", nil))
+		}, reacttest.NewToolFunc("time", func(map[string]any) (string, error) { panic("clock broke") }), "clock broke"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mb := reacttest.NewModelBuilder(t)
+			tc.script(mb)
+			ag := react.New(mb, react.WithTools(tc.tool))
+			var last react.Event
+			var err error
+			for e, eErr := range ag.SendStream(context.Background(), "What is the time?") {
+				if eErr != nil {
+					err = eErr
+					continue
+				}
+				last = e
+			}
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := last.(react.TurnCompleteEvent); !ok {
+					t.Fatalf("expected the last event to be turn complete, got %T", last)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing '%s', got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSendStreamDoesNotModifyOptions(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("Hi")
+	ag := react.New(mb)
+	opts := make([]react.SendMessageOpt, 1, 4)
+	opts[0] = react.WithNotifications(react.Notification{Kind: "time", Content: "12:00"})
+	for _, err := range ag.SendStream(context.Background(), "Hello", opts...) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, o := range opts[:cap(opts)] {
+		if i > 0 && o != nil {
+			t.Fatalf("option %d was written into the caller's slice", i)
+		}
+	}
+}
+
+// A tool that waits for its context to be cancelled.
+type waitingTool struct {
+	started chan struct{}
+	err     error
+}
+
+func (t *waitingTool) Name() string          { return "wait" }
+func (t *waitingTool) Description() []string { return []string{"Waits"} }
+func (t *waitingTool) Call(map[string]any) (string, error) {
+	return "", errors.New("expected CallContext to be used")
+}
+func (t *waitingTool) CallContext(ctx context.Context, _ map[string]any) (string, []react.ContentPart, error) {
+	close(t.started)
+	<-ctx.Done()
+	t.err = ctx.Err()
+	return "", nil, t.err
+}
+
+func TestSendStreamBreakCancelsTools(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueReAct("I will wait", reacttest.Call("wait", nil))
+	tool := &waitingTool{started: make(chan struct{})}
+	ag := react.New(mb, react.WithTools(tool))
+	for e, err := range ag.SendStream(context.Background(), "Wait for me") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := e.(react.ToolCallStartEvent); ok {
+			break
+		}
+	}
+	if !errors.Is(tool.err, context.Canceled) {
+		t.Fatalf("expected the tool context to be cancelled, got %v", tool.err)
+	}
+	// The unfinished turn is kept until it is rewound
+	if _, ok := reacttest.FinalAnswer(ag); ok {
+		t.Fatal("expected no final answer")
+	}
+	if err := ag.Rewind(ag.Turn()); err != nil {
+		t.Fatal(err)
+	}
+	if ag.Turn() != 0 {
+		t.Fatalf("expected the unfinished turn to be removed, got turn %d", ag.Turn())
+	}
+}
+
+type turnKey struct{}
+
+type contextSkillSelector struct{ got any }
+
+func (s *contextSkillSelector) SelectSkills([]react.Skill, []react.Message) ([]react.Skill, error) {
+	return nil, errors.New("expected SelectSkillsContext to be used")
+}
+func (s *contextSkillSelector) SelectSkillsContext(ctx context.Context, _ []react.Skill, _ []react.Message) ([]react.Skill, error) {
+	s.got = ctx.Value(turnKey{})
+	return nil, nil
+}
+
+type contextSummariser struct{ got any }
+
+func (s *contextSummariser) Summarise([]react.Message) (string, error) {
+	return "", errors.New("expected SummariseContext to be used")
+}
+func (s *contextSummariser) SummariseContext(ctx context.Context, _ []react.Message) (string, error) {
+	s.got = ctx.Value(turnKey{})
+	return "The user said hello", nil
+}
+
+func TestSendPassesContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), turnKey{}, "turn")
+	selector := &contextSkillSelector{}
+	summariser := &contextSummariser{}
+	var toolGot any
+	tool := &contextToolFunc{func(ctx context.Context) { toolGot = ctx.Value(turnKey{}) }}
+	mb := reacttest.NewModelBuilder(t)
+	ag := react.New(mb,
+		react.WithTools(tool),
+		react.WithSkills(react.Skill{Key: "weather", When: "the user asks about the weather", Content: "Use celsius"}),
+		react.WithSkillSelector(selector),
+		react.WithCompaction(summariser, 1, 0),
+	)
+	for range 2 {
+		mb.QueueReAct("Checking", reacttest.Call("check", nil))
+		mb.QueueReAct("Done")
+		mb.QueueFinalAnswer("Hello")
+		if _, err := ag.Send("Hello", react.WithContext(ctx)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, got := range map[string]any{"skill selector": selector.got, "summariser": summariser.got, "tool": toolGot} {
+		if got != "turn" {
+			t.Errorf("expected the %s to get the context of the turn, got value %v", name, got)
+		}
+	}
+	mb.AssertExhausted()
+}
+
+type contextToolFunc struct{ fn func(context.Context) }
+
+func (t *contextToolFunc) Name() string          { return "check" }
+func (t *contextToolFunc) Description() []string { return []string{"Checks"} }
+func (t *contextToolFunc) Call(map[string]any) (string, error) {
+	return "", errors.New("expected CallContext to be used")
+}
+func (t *contextToolFunc) CallContext(ctx context.Context, _ map[string]any) (string, []react.ContentPart, error) {
+	t.fn(ctx)
+	return "ok", nil, nil
+}
+
+// Streams the start of the final answer, then fails.
+type cutOffBuilder struct {
+	*reacttest.ModelBuilder
+}
+
+func (b cutOffBuilder) BuildAgentModel(responseType any, onInit func(), onData func(string)) jpf.Model {
+	if responseType != nil {
+		return b.ModelBuilder.BuildAgentModel(responseType, onInit, onData)
+	}
+	return cutOffModel{onInit, onData}
+}
+
+type cutOffModel struct {
+	onInit func()
+	onData func(string)
+}
+
+func (m cutOffModel) Respond(context.Context, []jpf.Message) (jpf.ModelResponse, error) {
+	m.onInit()
+	m.onData("It is ")
+	return jpf.ModelResponse{}, errors.New("connection reset")
+}
+
+func TestSendStreamPartialAnswer(t *testing.T) {
+	cases := []struct {
+		name        string
+		opts        []react.SendMessageOpt
+		wantAnswer  string
+		wantPartial bool
+	}{
+		{"without recovery", nil, "", false},
+		{"with recovery", []react.SendMessageOpt{react.WithPartialAnswerRecovery()}, "It is ", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mb := reacttest.NewModelBuilder(t)
+			mb.QueueReAct("I know the time")
+			ag := react.New(cutOffBuilder{mb})
+			var kinds []react.EventKind
+			var end react.FinalAnswerEndEvent
+			var err error
+			for e, eErr := range ag.SendStream(context.Background(), "What is the time?", tc.opts...) {
+				if eErr != nil {
+					err = eErr
+					continue
+				}
+				kinds = append(kinds, e.Kind())
+				if e, ok := e.(react.FinalAnswerEndEvent); ok {
+					end = e
+				}
+			}
+			if !slices.Contains(kinds, react.EventTextStreamFail) || slices.Contains(kinds, react.EventTextStreamEnd) {
+				t.Errorf("expected a text stream fail event and no end event, got %v", kinds)
+			}
+			if slices.Contains(kinds, react.EventTurnComplete) {
+				t.Error("expected no turn complete event")
+			}
+			if errors.Is(err, react.ErrPartialAnswer) != tc.wantPartial {
+				t.Errorf("expected partial answer error %v, got %v", tc.wantPartial, err)
+			}
+			if end.Partial != tc.wantPartial || end.Content != tc.wantAnswer {
+				t.Errorf("expected final answer end %q (partial %v), got %q (partial %v)", tc.wantAnswer, tc.wantPartial, end.Content, end.Partial)
+			}
+			if answer, _ := reacttest.FinalAnswer(ag); answer != tc.wantAnswer {
+				t.Errorf("expected answer %q in the history, got %q", tc.wantAnswer, answer)
+			}
+		})
+	}
+}
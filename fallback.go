@@ -0,0 +1,388 @@
+package react
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/JoshPattman/jpf"
+)
+
+var ErrAllModelsFailed = errors.New("all models in the fallback chain failed")
+var ErrCircuitOpen = errors.New("model skipped as it has failed too many times recently")
+
+// ModelErrorClass is the kind of error a model call failed with, used to decide what a fallback chain does next.
+type ModelErrorClass string
+
+const (
+	ErrorClassTimeout   ModelErrorClass = "timeout"
+	ErrorClassRateLimit ModelErrorClass = "rate_limit"
+	// The provider had an internal error, or is overloaded.
+	ErrorClassServer ModelErrorClass = "server"
+	// The request was rejected as unauthenticated or forbidden, such as for a missing or revoked api key.
+	ErrorClassAuth ModelErrorClass = "auth"
+	// The request was rejected for another reason, such as being invalid.
+	ErrorClassClient ModelErrorClass = "client"
+	// The context of the call was cancelled.
+	ErrorClassCancelled ModelErrorClass = "cancelled"
+	ErrorClassUnknown   ModelErrorClass = "unknown"
+)
+
+// ErrorClassifier decides the class of an error returned by a model.
+type ErrorClassifier func(error) ModelErrorClass
+
+// The status codes in the errors of the jpf OpenAI and Gemini models.
+var httpStatusPattern = regexp.MustCompile(`(?:http status|request failed with status) (\d{3})`)
+
+// HTTPStatusError may optionally be implemented by an error from a model, to give the http status code of the failed request.
+type HTTPStatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// ClassifyModelError is the default [ErrorClassifier].
+// It recognises context errors, network timeouts, errors implementing [HTTPStatusError], and the http status codes in errors from jpf models.
+func ClassifyModelError(err error) ModelErrorClass {
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassCancelled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+	var statusErr HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return classifyHTTPStatus(statusErr.HTTPStatus())
+	}
+	if m := httpStatusPattern.FindStringSubmatch(err.Error()); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return classifyHTTPStatus(status)
+	}
+	return ErrorClassUnknown
+}
+
+func classifyHTTPStatus(status int) ModelErrorClass {
+	switch {
+	case status == 408:
+		return ErrorClassTimeout
+	case status == 429:
+		return ErrorClassRateLimit
+	case status == 401 || status == 403:
+		return ErrorClassAuth
+	case status >= 500:
+		return ErrorClassServer
+	case status >= 400:
+		return ErrorClassClient
+	}
+	return ErrorClassUnknown
+}
+
+// FailurePolicy decides what a fallback chain does when a model fails.
+type FailurePolicy uint8
+
+const (
+	// Try the same model again (up to the retry limit), then fall back to the next model.
+	PolicyRetry FailurePolicy = iota
+	// Try the next model in the chain.
+	PolicyFallBack
+	// Return the error straight away.
+	PolicyFail
+)
+
+func (p FailurePolicy) String() string {
+	switch p {
+	case PolicyRetry:
+		return "retry"
+	case PolicyFallBack:
+		return "fall back"
+	case PolicyFail:
+		return "fail"
+	default:
+		return fmt.Sprintf("unknown policy %d", uint8(p))
+	}
+}
+
+// FallbackAttempt is a single call to a model in a fallback chain.
+type FallbackAttempt struct {
+	Model string
+	// The error the call failed with, or nil if it succeeded.
+	Err   error
+	Class ModelErrorClass
+	// Whether the model was skipped without being called, as its circuit breaker was open.
+	Skipped bool
+}
+
+// FallbackReport describes how a call to a fallback chain was served.
+type FallbackReport struct {
+	// The name of the model that served the call, or an empty string if every model failed.
+	ServedBy string
+	Attempts []FallbackAttempt
+}
+
+// ServedByReporter may optionally be implemented by a model, to report which model actually served its latest call.
+// The agent records this as [MetaModel] instead of the name of the model builder.
+type ServedByReporter interface {
+	ServedBy() string
+}
+
+// The name of the model that served the latest call of the model if it reports it, or otherwise the name of the model builder.
+func servedModelName(model jpf.Model, builderName string) string {
+	if r, ok := model.(ServedByReporter); ok && r.ServedBy() != "" {
+		return r.ServedBy()
+	}
+	return builderName
+}
+
+// The name of the model that served the latest call of a model built by the builder.
+// Unlike servedModelName, the builder is only asked for its name if the model does not report it.
+func builtModelName(model jpf.Model, builder any) string {
+	if name := servedModelName(model, ""); name != "" {
+		return name
+	}
+	return modelName(builder)
+}
+
+type FallbackOpt func(*fallbackKwargs)
+
+// Use the policy for errors of the class, instead of the default.
+// By default, client errors and cancellations fail, rate limits and server errors are retried, and everything else (including auth errors,
+// as another provider will have its own credentials) falls back.
+func WithFailurePolicy(class ModelErrorClass, policy FailurePolicy) func(kw *fallbackKwargs) {
+	return func(kw *fallbackKwargs) { kw.policies[class] = policy }
+}
+
+// Use the classifier to decide the class of errors, instead of [ClassifyModelError].
+func WithErrorClassifier(classifier ErrorClassifier) func(kw *fallbackKwargs) {
+	return func(kw *fallbackKwargs) { kw.classifier = classifier }
+}
+
+// Retry a model up to maxRetries times when the policy is [PolicyRetry], waiting backoff before the first retry and doubling it each time.
+// Defaults to 2 retries with a backoff of 1 second.
+func WithFallbackRetries(maxRetries int, backoff time.Duration) func(kw *fallbackKwargs) {
+	return func(kw *fallbackKwargs) {
+		kw.maxRetries = maxRetries
+		kw.backoff = backoff
+	}
+}
+
+// Skip a model for the cooldown once it has failed failureThreshold calls in a row (after any retries).
+// After the cooldown, a single call tries the model again while any other calls keep skipping it,
+// and the model is skipped for another cooldown if that call fails.
+// Errors with the [PolicyFail] policy do not count as failures.
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) func(kw *fallbackKwargs) {
+	return func(kw *fallbackKwargs) {
+		kw.breakerThreshold = failureThreshold
+		kw.breakerCooldown = cooldown
+	}
+}
+
+// Call the reporter after every call to a model built by the chain.
+func WithFallbackReporter(reporter func(FallbackReport)) func(kw *fallbackKwargs) {
+	return func(kw *fallbackKwargs) { kw.reporters = append(kw.reporters, reporter) }
+}
+
+type fallbackKwargs struct {
+	policies         map[ModelErrorClass]FailurePolicy
+	classifier       ErrorClassifier
+	maxRetries       int
+	backoff          time.Duration
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	reporters        []func(FallbackReport)
+}
+
+// NewFallbackModelBuilder creates a [ModelBuilder] whose models try each model in the chain in order, until one succeeds.
+// The names of the routes are used to report which model served each call (see [ServedByReporter]).
+// Circuit breakers are shared by all models built by the builder.
+func NewFallbackModelBuilder(chain []ModelRoute, opts ...FallbackOpt) ModelBuilder {
+	if len(chain) == 0 {
+		panic("NewFallbackModelBuilder requires at least one model")
+	}
+	kwargs := fallbackKwargs{
+		policies: map[ModelErrorClass]FailurePolicy{
+			ErrorClassRateLimit: PolicyRetry,
+			ErrorClassServer:    PolicyRetry,
+			ErrorClassClient:    PolicyFail,
+			ErrorClassCancelled: PolicyFail,
+		},
+		classifier: ClassifyModelError,
+		maxRetries: 2,
+		backoff:    time.Second,
+	}
+	for _, o := range opts {
+		o(&kwargs)
+	}
+	breakers := make([]*circuitBreaker, len(chain))
+	for i := range breakers {
+		breakers[i] = &circuitBreaker{}
+	}
+	return &fallbackModelBuilder{chain, breakers, kwargs}
+}
+
+type fallbackModelBuilder struct {
+	chain    []ModelRoute
+	breakers []*circuitBreaker
+	kwargs   fallbackKwargs
+}
+
+func (b *fallbackModelBuilder) BuildAgentModel(responseType any, onInitFinalStream func(), onDataFinalStream func(string)) jpf.Model {
+	return &fallbackModel{builder: b, build: func(mb ModelBuilder) jpf.Model {
+		return mb.BuildAgentModel(responseType, onInitFinalStream, onDataFinalStream)
+	}}
+}
+
+func (b *fallbackModelBuilder) BuildFragmentSelectorModel(responseType any) jpf.Model {
+	return &fallbackModel{builder: b, build: func(mb ModelBuilder) jpf.Model {
+		return mb.BuildFragmentSelectorModel(responseType)
+	}}
+}
+
+// The name of the primary model.
+func (b *fallbackModelBuilder) ModelName() string {
+	return b.chain[0].name()
+}
+
+func (b *fallbackModelBuilder) policy(class ModelErrorClass) FailurePolicy {
+	if policy, ok := b.kwargs.policies[class]; ok {
+		return policy
+	}
+	return PolicyFallBack
+}
+
+type fallbackModel struct {
+	builder *fallbackModelBuilder
+	// Build the model of a route in the chain
+	build    func(ModelBuilder) jpf.Model
+	lock     sync.Mutex
+	servedBy string
+}
+
+func (m *fallbackModel) ServedBy() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.servedBy
+}
+
+func (m *fallbackModel) Respond(ctx context.Context, msgs []jpf.Message) (jpf.ModelResponse, error) {
+	resp, report, err := m.respond(ctx, msgs)
+	m.lock.Lock()
+	m.servedBy = report.ServedBy
+	m.lock.Unlock()
+	for _, reporter := range m.builder.kwargs.reporters {
+		reporter(report)
+	}
+	return resp, err
+}
+
+// Try each model in the chain, returning the response with the usage of every attempt.
+func (m *fallbackModel) respond(ctx context.Context, msgs []jpf.Message) (jpf.ModelResponse, FallbackReport, error) {
+	b := m.builder
+	var report FallbackReport
+	var errs []error
+	// The usage of the failed attempts
+	var usage jpf.Usage
+	for i, route := range b.chain {
+		name := route.name()
+		breaker := b.breakers[i]
+		if !breaker.allow(b.kwargs.breakerCooldown) {
+			report.Attempts = append(report.Attempts, FallbackAttempt{Model: name, Skipped: true})
+			errs = append(errs, fmt.Errorf("model '%s': %w", name, ErrCircuitOpen))
+			continue
+		}
+		model := m.build(route.Builder)
+		backoff := b.kwargs.backoff
+		for retry := 0; ; retry++ {
+			resp, err := model.Respond(ctx, msgs)
+			if err == nil {
+				report.Attempts = append(report.Attempts, FallbackAttempt{Model: name})
+				report.ServedBy = name
+				breaker.succeed()
+				return resp.IncludingUsage(usage), report, nil
+			}
+			usage = usage.Add(resp.Usage)
+			class := b.kwargs.classifier(err)
+			report.Attempts = append(report.Attempts, FallbackAttempt{Model: name, Err: err, Class: class})
+			policy := b.policy(class)
+			if ctx.Err() != nil {
+				policy = PolicyFail
+			}
+			if policy == PolicyFail {
+				breaker.release()
+				return jpf.ModelResponse{Usage: usage}, report, fmt.Errorf("model '%s' failed (%s): %w", name, class, err)
+			}
+			if policy == PolicyRetry && retry < b.kwargs.maxRetries {
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					breaker.release()
+					return jpf.ModelResponse{Usage: usage}, report, ctx.Err()
+				}
+				backoff *= 2
+				continue
+			}
+			breaker.fail(b.kwargs.breakerThreshold)
+			errs = append(errs, fmt.Errorf("model '%s' failed (%s): %w", name, class, err))
+			break
+		}
+	}
+	return jpf.ModelResponse{Usage: usage}, report, fmt.Errorf("%w: %w", ErrAllModelsFailed, errors.Join(errs...))
+}
+
+// A circuit breaker that opens after a number of consecutive failures.
+// Once the cooldown has passed it is half open, allowing a single trial call which closes it if it succeeds, or opens it again if it fails.
+type circuitBreaker struct {
+	lock     sync.Mutex
+	failures int
+	openedAt time.Time
+	open     bool
+	// Whether the trial call of a half open breaker is in progress
+	trial bool
+}
+
+// Whether the model can be called. If this allows the trial call of a half open breaker, the call must be followed by succeed, fail, or release.
+func (cb *circuitBreaker) allow(cooldown time.Duration) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if !cb.open {
+		return true
+	}
+	if cb.trial || time.Since(cb.openedAt) < cooldown {
+		return false
+	}
+	cb.trial = true
+	return true
+}
+
+func (cb *circuitBreaker) succeed() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.failures = 0
+	cb.open = false
+	cb.trial = false
+}
+
+func (cb *circuitBreaker) fail(threshold int) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.failures++
+	cb.trial = false
+	if threshold > 0 && (cb.open || cb.failures >= threshold) {
+		cb.open = true
+		cb.openedAt = time.Now()
+	}
+}
+
+// End a call that neither succeeded nor failed, such as one that was cancelled, allowing another trial call if the breaker is half open.
+func (cb *circuitBreaker) release() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.trial = false
+}
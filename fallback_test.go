@@ -0,0 +1,225 @@
+package react_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/jpf"
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+type statusError struct{ status int }
+
+func (e statusError) Error() string   { return "request rejected" }
+func (e statusError) HTTPStatus() int { return e.status }
+
+func TestClassifyModelError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want react.ModelErrorClass
+	}{
+		{context.Canceled, react.ErrorClassCancelled},
+		{fmt.Errorf("call failed: %w", context.DeadlineExceeded), react.ErrorClassTimeout},
+		{errors.New("request failed: http status 503"), react.ErrorClassServer},
+		{errors.New("request failed with status 429: slow down"), react.ErrorClassRateLimit},
+		{errors.New("request failed: http status 408"), react.ErrorClassTimeout},
+		{errors.New("request failed: http status 401"), react.ErrorClassAuth},
+		{errors.New("request failed with status 403: forbidden"), react.ErrorClassAuth},
+		{errors.New("request failed with status 400: bad request"), react.ErrorClassClient},
+		{fmt.Errorf("wrapped: %w", statusError{404}), react.ErrorClassClient},
+		{statusError{500}, react.ErrorClassServer},
+		{errors.New("something went wrong"), react.ErrorClassUnknown},
+	}
+	for _, tc := range cases {
+		if got := react.ClassifyModelError(tc.err); got != tc.want {
+			t.Errorf("%v: expected %s, got %s", tc.err, tc.want, got)
+		}
+	}
+}
+
+func TestFallbackModelBuilder(t *testing.T) {
+	cases := []struct {
+		name         string
+		primary      []error
+		backup       []error
+		wantServedBy string
+		wantErr      error
+	}{
+		{"primary succeeds", []error{nil}, nil, "primary", nil},
+		{"server error is retried", []error{errors.New("http status 500"), nil}, nil, "primary", nil},
+		{"auth error falls back", []error{errors.New("http status 401")}, []error{nil}, "backup", nil},
+		{"unknown error falls back", []error{errors.New("connection reset")}, []error{nil}, "backup", nil},
+		{"client error fails", []error{errors.New("http status 400")}, nil, "", nil},
+		{"all fail", []error{errors.New("http status 500"), errors.New("http status 500")}, []error{errors.New("http status 401")}, "", react.ErrAllModelsFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			primary := reacttest.NewModelBuilder(t)
+			backup := reacttest.NewModelBuilder(t)
+			for _, queue := range []struct {
+				mb   *reacttest.ModelBuilder
+				errs []error
+			}{{primary, tc.primary}, {backup, tc.backup}} {
+				for _, err := range queue.errs {
+					if err != nil {
+						queue.mb.QueueError(reacttest.CallSummary, err)
+					} else {
+						queue.mb.QueueSummary("ok")
+					}
+				}
+			}
+			var report react.FallbackReport
+			builder := react.NewFallbackModelBuilder(
+				[]react.ModelRoute{{Name: "primary", Builder: primary}, {Name: "backup", Builder: backup}},
+				react.WithFallbackRetries(1, 0),
+				react.WithFallbackReporter(func(r react.FallbackReport) { report = r }),
+			)
+			model := builder.BuildAgentModel(nil, nil, nil)
+			_, err := model.Respond(context.Background(), nil)
+			if tc.wantServedBy != "" && err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantServedBy == "" && err == nil {
+				t.Fatal("expected an error")
+			}
+			if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+			if report.ServedBy != tc.wantServedBy || model.(react.ServedByReporter).ServedBy() != tc.wantServedBy {
+				t.Fatalf("expected to be served by %q, got %q", tc.wantServedBy, report.ServedBy)
+			}
+			primary.AssertExhausted()
+			backup.AssertExhausted()
+		})
+	}
+}
+
+// A model that responds by calling a function.
+type funcModel func(context.Context) (jpf.ModelResponse, error)
+
+func (f funcModel) Respond(ctx context.Context, _ []jpf.Message) (jpf.ModelResponse, error) {
+	return f(ctx)
+}
+
+// A model builder that always builds the same model.
+type staticBuilder struct{ model jpf.Model }
+
+func (b staticBuilder) BuildAgentModel(any, func(), func(string)) jpf.Model { return b.model }
+func (b staticBuilder) BuildFragmentSelectorModel(any) jpf.Model            { return b.model }
+
+func TestFallbackUsage(t *testing.T) {
+	failing := funcModel(func(context.Context) (jpf.ModelResponse, error) {
+		return jpf.ModelResponse{Usage: jpf.Usage{InputTokens: 10, FailedCalls: 1}}, errors.New("http status 500")
+	})
+	working := funcModel(func(context.Context) (jpf.ModelResponse, error) {
+		return jpf.ModelResponse{Usage: jpf.Usage{InputTokens: 20, OutputTokens: 5, SuccessfulCalls: 1}}, nil
+	})
+	cases := []struct {
+		name      string
+		chain     []jpf.Model
+		wantUsage jpf.Usage
+	}{
+		{"retried then fell back", []jpf.Model{failing, working}, jpf.Usage{InputTokens: 40, OutputTokens: 5, SuccessfulCalls: 1, FailedCalls: 2}},
+		{"all failed", []jpf.Model{failing, failing}, jpf.Usage{InputTokens: 40, FailedCalls: 4}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := make([]react.ModelRoute, len(tc.chain))
+			for i, m := range tc.chain {
+				chain[i] = react.ModelRoute{Name: fmt.Sprint(i), Builder: staticBuilder{m}}
+			}
+			builder := react.NewFallbackModelBuilder(chain, react.WithFallbackRetries(1, 0))
+			resp, _ := builder.BuildAgentModel(nil, nil, nil).Respond(context.Background(), nil)
+			if resp.Usage != tc.wantUsage {
+				t.Fatalf("expected usage %+v, got %+v", tc.wantUsage, resp.Usage)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	primaryErr := errors.New("connection reset")
+	calls := make(chan chan error)
+	primary := funcModel(func(context.Context) (jpf.ModelResponse, error) {
+		result := make(chan error)
+		calls <- result
+		return jpf.ModelResponse{}, <-result
+	})
+	backup := funcModel(func(context.Context) (jpf.ModelResponse, error) { return jpf.ModelResponse{}, nil })
+	builder := react.NewFallbackModelBuilder(
+		[]react.ModelRoute{{Name: "primary", Builder: staticBuilder{primary}}, {Name: "backup", Builder: staticBuilder{backup}}},
+		react.WithFallbackRetries(0, 0),
+		react.WithCircuitBreaker(1, 20*time.Millisecond),
+	)
+	respond := func() <-chan string {
+		served := make(chan string, 1)
+		go func() {
+			model := builder.BuildAgentModel(nil, nil, nil)
+			model.Respond(context.Background(), nil)
+			served <- model.(react.ServedByReporter).ServedBy()
+		}()
+		return served
+	}
+	expectServed := func(served <-chan string, want string) {
+		t.Helper()
+		if got := <-served; got != want {
+			t.Fatalf("expected to be served by %s, got %s", want, got)
+		}
+	}
+
+	// The failure opens the breaker, so the primary model is skipped
+	served := respond()
+	(<-calls) <- primaryErr
+	expectServed(served, "backup")
+	expectServed(respond(), "backup")
+
+	// After the cooldown a single trial call is let through, which fails and opens the breaker again
+	time.Sleep(30 * time.Millisecond)
+	served = respond()
+	(<-calls) <- primaryErr
+	expectServed(served, "backup")
+	expectServed(respond(), "backup")
+
+	// While a trial call is in progress, other calls skip the model
+	time.Sleep(30 * time.Millisecond)
+	trial := respond()
+	result := <-calls
+	expectServed(respond(), "backup")
+	result <- nil
+	expectServed(trial, "primary")
+
+	// The successful trial closes the breaker
+	served = respond()
+	(<-calls) <- nil
+	expectServed(served, "primary")
+}
+
+func TestAgentFallback(t *testing.T) {
+	primary := reacttest.NewModelBuilder(t)
+	backup := reacttest.NewModelBuilder(t)
+	primary.QueueError(reacttest.CallReAct, errors.New("request failed with status 401: invalid key"))
+	backup.QueueReAct("Nothing to do")
+	primary.QueueFinalAnswer("Hello")
+	builder := react.NewFallbackModelBuilder([]react.ModelRoute{{Name: "primary", Builder: primary}, {Name: "backup", Builder: backup}})
+	ag := react.New(builder)
+	if _, err := ag.Send("Hi"); err != nil {
+		t.Fatal(err)
+	}
+	var models []string
+	for msg := range ag.Messages() {
+		if kind := react.KindOf(msg); kind == react.KindToolCalls || kind == react.KindAgent {
+			model, _ := msg.Info().MetaString(react.MetaModel)
+			models = append(models, model)
+		}
+	}
+	if want := []string{"backup", "primary"}; !reflect.DeepEqual(models, want) {
+		t.Fatalf("expected messages from %v, got %v", want, models)
+	}
+	primary.AssertExhausted()
+	backup.AssertExhausted()
+}
@@ -0,0 +1,283 @@
+package react
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidTranscript = errors.New("transcript cannot be imported")
+
+// The exporters all work from this simpler view of the history, where messages that only steer the agent are left out.
+type transcriptEntryKind uint8
+
+const (
+	entryUser transcriptEntryKind = iota
+	entryAgent
+	entryToolCalls
+	entryToolResponses
+	// Notifications and summaries, which other formats can only show as system messages
+	entrySystemNote
+)
+
+type transcriptEntry struct {
+	kind      transcriptEntryKind
+	content   string
+	toolCalls []ToolCall
+	responses []ToolResponse
+	// Unique per tool calls entry, so that tool call ids can be made
+	callsIndex int
+}
+
+type transcript struct {
+	systemPrompt string
+	entries      []transcriptEntry
+}
+
+// Build the transcript from the history.
+// The system prompt is rendered with the personality and skills as of the end of the history.
+func getTranscript(msgs []Message) transcript {
+	conv := &transcriptConverter{}
+	VisitMessages(conv, msgs...)
+	return transcript{
+		systemPrompt: executeSystemPrompt(conv.systemTemplate, systemPromptData{conv.personality, conv.skills}),
+		entries:      conv.entries,
+	}
+}
+
+type transcriptConverter struct {
+	BaseMessageVisitor
+	systemTemplate string
+	personality    string
+	skills         []InsertedSkill
+	entries        []transcriptEntry
+	numToolCalls   int
+}
+
+func (conv *transcriptConverter) AddSystem(template string) {
+	conv.systemTemplate = template
+}
+func (conv *transcriptConverter) AddPersonality(personality string) {
+	conv.personality = personality
+}
+func (conv *transcriptConverter) AddSkills(skills []InsertedSkill) {
+	conv.skills = skills
+}
+func (conv *transcriptConverter) AddUser(content string, parts []ContentPart) {
+	conv.entries = append(conv.entries, transcriptEntry{kind: entryUser, content: joinContent(content, describeParts(parts))})
+}
+func (conv *transcriptConverter) AddAgent(content string) {
+	conv.entries = append(conv.entries, transcriptEntry{kind: entryAgent, content: content})
+}
+
+// The final tool calls message of each turn has no tool calls, and only exists to end the ReAct loop, so it is left out.
+func (conv *transcriptConverter) AddToolCalls(reasoning string, toolCalls []ToolCall) {
+	if len(toolCalls) == 0 {
+		return
+	}
+	conv.entries = append(conv.entries, transcriptEntry{kind: entryToolCalls, content: reasoning, toolCalls: toolCalls, callsIndex: conv.numToolCalls})
+	conv.numToolCalls++
+}
+func (conv *transcriptConverter) AddToolResponse(responses []ToolResponse) {
+	callsIndex := conv.numToolCalls - 1
+	conv.entries = append(conv.entries, transcriptEntry{kind: entryToolResponses, responses: responses, callsIndex: callsIndex})
+}
+func (conv *transcriptConverter) AddNotification(kind string, content string) {
+	conv.entries = append(conv.entries, transcriptEntry{kind: entrySystemNote, content: fmt.Sprintf(notificationNoteFormat, kind, content)})
+}
+func (conv *transcriptConverter) AddSummary(summary string, replaced []Message) {
+	conv.entries = append(conv.entries, transcriptEntry{kind: entrySystemNote, content: summaryNotePrefix + summary})
+}
+
+// How notifications and summaries are written as system notes, so they can be recognised when importing.
+const (
+	notificationNoteFormat = "Notification of type '%s':\n%s"
+	summaryNotePrefix      = "Summary of earlier conversation:\n"
+)
+
+var notificationNotePattern = regexp.MustCompile(`(?s)^Notification of type '([^'\n]*)':\n(.*)$`)
+
+func toolCallID(callsIndex, i int) string {
+	return fmt.Sprintf("call_%d_%d", callsIndex, i)
+}
+
+func toolResponseText(r ToolResponse) string {
+	return joinContent(r.Response, describeParts(r.Parts))
+}
+
+func toolArgsToMap(args []ToolCallArg) map[string]any {
+	m := make(map[string]any, len(args))
+	for _, a := range args {
+		m[a.ArgName] = a.ArgValue
+	}
+	return m
+}
+
+// Args are sorted by name, as json objects have no order.
+func toolArgsFromMap(m map[string]any) []ToolCallArg {
+	args := make([]ToolCallArg, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		args = append(args, ToolCallArg{ArgName: k, ArgValue: m[k]})
+	}
+	return args
+}
+
+// transcriptImporter builds a history from messages in another format,
+// adding the mode switches and empty tool calls messages that the agent uses to structure each turn.
+type transcriptImporter struct {
+	msgs         []Message
+	inReAct      bool
+	pendingCalls []string
+	pendingResps map[string]ToolResponse
+	seenAnyEntry bool
+	personality  string
+}
+
+func newTranscriptImporter() *transcriptImporter {
+	return &transcriptImporter{pendingResps: make(map[string]ToolResponse)}
+}
+
+// A system message at the start of the conversation becomes the personality, any later ones become notifications.
+// Notifications and summaries that were exported from this package are imported as they were.
+func (imp *transcriptImporter) system(content string) {
+	if !imp.seenAnyEntry {
+		imp.seenAnyEntry = true
+		imp.personality = content
+		return
+	}
+	imp.flushResponses()
+	if match := notificationNotePattern.FindStringSubmatch(content); match != nil {
+		imp.msgs = append(imp.msgs, notificationMessage{Notification: Notification{Kind: match[1], Content: match[2]}})
+	} else if summary, ok := strings.CutPrefix(content, summaryNotePrefix); ok {
+		imp.msgs = append(imp.msgs, summaryMessage{Summary: summary})
+	} else {
+		imp.msgs = append(imp.msgs, notificationMessage{Notification: Notification{Kind: "system", Content: content}})
+	}
+}
+
+func (imp *transcriptImporter) user(content string) {
+	imp.seenAnyEntry = true
+	imp.flushResponses()
+	imp.msgs = append(imp.msgs, userMessage{Content: content})
+	imp.inReAct = false
+}
+
+// Each id must be unique within the transcript, and is used to match up the tool results.
+func (imp *transcriptImporter) toolCalls(reasoning string, ids []string, calls []ToolCall) {
+	imp.seenAnyEntry = true
+	imp.flushResponses()
+	imp.startReAct()
+	imp.msgs = append(imp.msgs, toolCallsMessage{Reasoning: reasoning, ToolCalls: calls})
+	imp.pendingCalls = ids
+}
+
+func (imp *transcriptImporter) toolResult(id string, content string) error {
+	if !slices.Contains(imp.pendingCalls, id) {
+		return fmt.Errorf("%w: tool result for unknown tool call '%s'", ErrInvalidTranscript, id)
+	}
+	imp.pendingResps[id] = ToolResponse{Response: content}
+	return nil
+}
+
+func (imp *transcriptImporter) agent(content string) {
+	imp.seenAnyEntry = true
+	imp.flushResponses()
+	imp.startReAct()
+	imp.msgs = append(imp.msgs,
+		toolCallsMessage{},
+		modeSwitchMessage{Mode: ModeAnswerUser},
+		agentMessage{Content: content},
+	)
+	imp.inReAct = false
+}
+
+func (imp *transcriptImporter) startReAct() {
+	if !imp.inReAct {
+		imp.msgs = append(imp.msgs, modeSwitchMessage{Mode: ModeReasonAct})
+		imp.inReAct = true
+	}
+}
+
+// Add the results of the last tool calls, in the order the tools were called.
+func (imp *transcriptImporter) flushResponses() {
+	if len(imp.pendingCalls) == 0 {
+		return
+	}
+	responses := make([]ToolResponse, len(imp.pendingCalls))
+	for i, id := range imp.pendingCalls {
+		resp, ok := imp.pendingResps[id]
+		if !ok {
+			resp = ToolResponse{Response: "(no tool result was recorded)"}
+		}
+		responses[i] = resp
+	}
+	imp.msgs = append(imp.msgs, toolResponseMessage{Responses: responses})
+	imp.pendingCalls = nil
+	clear(imp.pendingResps)
+}
+
+// Finish the history, starting it with the personality and system prompt of this package.
+func (imp *transcriptImporter) finish() []Message {
+	imp.flushResponses()
+	personality := imp.personality
+	if personality == "" {
+		personality = getNewKwargs(nil).personality
+	}
+	return append([]Message{
+		personalityMessage{Personality: personality},
+		systemMessage{Template: createCraigSystemTemplate()},
+	}, imp.msgs...)
+}
+
+// Read text content that may be a json string, null, or a list of content blocks (as used by OpenAI and Anthropic).
+// Blocks that are not text are described in the text.
+func textFromJsonContent(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("%w: content is not a string or list of content blocks", ErrInvalidTranscript)
+	}
+	texts := make([]string, len(blocks))
+	for i, b := range blocks {
+		if b.Type == "text" {
+			texts[i] = b.Text
+		} else {
+			texts[i] = fmt.Sprintf("[Attached %s, which cannot be imported]", b.Type)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// FineTuningExample is one line of a chat fine-tuning dataset, in the format used by OpenAI.
+type FineTuningExample struct {
+	Messages []OpenAIMessage `json:"messages"`
+}
+
+// WriteFineTuningJSONL writes each conversation as one line of a chat fine-tuning dataset (see [FineTuningExample]).
+// Conversations that have no final answer from the agent are skipped, as there is nothing to learn from them.
+func WriteFineTuningJSONL(w io.Writer, conversations ...[]Message) error {
+	enc := json.NewEncoder(w)
+	for _, conv := range conversations {
+		if !slices.ContainsFunc(conv, func(m Message) bool { _, ok := m.(agentMessage); return ok }) {
+			continue
+		}
+		if err := enc.Encode(FineTuningExample{Messages: ExportOpenAI(conv)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
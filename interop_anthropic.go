@@ -0,0 +1,180 @@
+package react
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// AnthropicConversation is a conversation in the format of the Anthropic messages API, where the system prompt is separate to the messages.
+type AnthropicConversation struct {
+	System   string             `json:"system,omitempty"`
+	Messages []AnthropicMessage `json:"messages"`
+}
+
+type AnthropicMessage struct {
+	// Either "user" or "assistant".
+	Role    string                  `json:"role"`
+	Content []AnthropicContentBlock `json:"content"`
+}
+
+// AnthropicContentBlock is a text, tool_use, or tool_result content block.
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	// The text of a text block.
+	Text string `json:"text,omitempty"`
+	// The id of a tool_use block.
+	ID string `json:"id,omitempty"`
+	// The tool name of a tool_use block.
+	Name string `json:"name,omitempty"`
+	// The arguments of a tool_use block.
+	Input map[string]any `json:"input,omitzero"`
+	// The id of the tool_use block that a tool_result block is the result of.
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	// The result of a tool_result block.
+	Content string `json:"content,omitempty"`
+}
+
+// UnmarshalJSON also accepts content that is a plain string.
+func (m *AnthropicMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	m.Role = raw.Role
+	var s string
+	if err := json.Unmarshal(raw.Content, &s); err == nil {
+		m.Content = []AnthropicContentBlock{{Type: "text", Text: s}}
+		return nil
+	}
+	return json.Unmarshal(raw.Content, &m.Content)
+}
+
+// UnmarshalJSON also accepts tool result content that is a list of content blocks.
+func (b *AnthropicContentBlock) UnmarshalJSON(data []byte) error {
+	type plain AnthropicContentBlock
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	content, err := textFromJsonContent(raw.Content)
+	if err != nil {
+		return err
+	}
+	*b = AnthropicContentBlock(raw.plain)
+	b.Content = content
+	return nil
+}
+
+// Anthropic has no system messages within the conversation, so system notes are sent as user text starting with this.
+const anthropicSystemNotePrefix = "(System message) "
+
+// ExportAnthropic converts the history into an Anthropic messages API conversation.
+// The system prompt has the personality and skills as of the end of the history.
+// As there are no system messages in this format, notifications and summaries are added as text to user messages.
+// Consecutive messages with the same role are merged, as the API requires the roles to alternate.
+func ExportAnthropic(msgs []Message) AnthropicConversation {
+	t := getTranscript(msgs)
+	conv := AnthropicConversation{System: t.systemPrompt, Messages: make([]AnthropicMessage, 0)}
+	add := func(role string, blocks ...AnthropicContentBlock) {
+		if n := len(conv.Messages); n > 0 && conv.Messages[n-1].Role == role {
+			conv.Messages[n-1].Content = append(conv.Messages[n-1].Content, blocks...)
+			return
+		}
+		conv.Messages = append(conv.Messages, AnthropicMessage{Role: role, Content: blocks})
+	}
+	for _, e := range t.entries {
+		switch e.kind {
+		case entryUser:
+			add("user", AnthropicContentBlock{Type: "text", Text: e.content})
+		case entryAgent:
+			add("assistant", AnthropicContentBlock{Type: "text", Text: e.content})
+		case entrySystemNote:
+			add("user", AnthropicContentBlock{Type: "text", Text: anthropicSystemNotePrefix + e.content})
+		case entryToolCalls:
+			blocks := make([]AnthropicContentBlock, 0)
+			if e.content != "" {
+				blocks = append(blocks, AnthropicContentBlock{Type: "text", Text: e.content})
+			}
+			for i, tc := range e.toolCalls {
+				blocks = append(blocks, AnthropicContentBlock{
+					Type:  "tool_use",
+					ID:    toolCallID(e.callsIndex, i),
+					Name:  tc.ToolName,
+					Input: toolArgsToMap(tc.ToolArgs),
+				})
+			}
+			add("assistant", blocks...)
+		case entryToolResponses:
+			blocks := make([]AnthropicContentBlock, len(e.responses))
+			for i, r := range e.responses {
+				blocks[i] = AnthropicContentBlock{Type: "tool_result", ToolUseID: toolCallID(e.callsIndex, i), Content: toolResponseText(r)}
+			}
+			add("user", blocks...)
+		}
+	}
+	return conv
+}
+
+// ImportAnthropic converts an Anthropic messages API conversation into a history that can be passed to [NewFromSaved].
+// The system prompt becomes the personality of the agent.
+// Text before tool_use blocks in an assistant message becomes the reasoning of the tool calls.
+func ImportAnthropic(conv AnthropicConversation) ([]Message, error) {
+	imp := newTranscriptImporter()
+	if conv.System != "" {
+		imp.system(conv.System)
+	}
+	for _, m := range conv.Messages {
+		texts := make([]string, 0)
+		ids := make([]string, 0)
+		calls := make([]ToolCall, 0)
+		for _, b := range m.Content {
+			switch b.Type {
+			case "text":
+				// System notes are merged into user messages when exporting, so are split out again
+				if note, ok := strings.CutPrefix(b.Text, anthropicSystemNotePrefix); ok && m.Role == "user" {
+					if len(texts) > 0 {
+						imp.user(strings.Join(texts, "\n"))
+						texts = texts[:0]
+					}
+					imp.system(note)
+					continue
+				}
+				texts = append(texts, b.Text)
+			case "tool_use":
+				ids = append(ids, b.ID)
+				calls = append(calls, ToolCall{ToolName: b.Name, ToolArgs: toolArgsFromMap(b.Input)})
+			case "tool_result":
+				if err := imp.toolResult(b.ToolUseID, b.Content); err != nil {
+					return nil, err
+				}
+			case "thinking", "redacted_thinking":
+				// Thinking blocks are only meaningful to the model that wrote them
+			default:
+				texts = append(texts, fmt.Sprintf("[Attached %s, which cannot be imported]", b.Type))
+			}
+		}
+		text := strings.Join(texts, "\n")
+		switch m.Role {
+		case "user":
+			if len(texts) > 0 {
+				imp.user(text)
+			}
+		case "assistant":
+			if len(calls) > 0 {
+				imp.toolCalls(text, ids, calls)
+			} else {
+				imp.agent(text)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown role '%s'", ErrInvalidTranscript, m.Role)
+		}
+	}
+	return imp.finish(), nil
+}
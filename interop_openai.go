@@ -0,0 +1,120 @@
+package react
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OpenAIMessage is a message in the format of the OpenAI chat completions API.
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type OpenAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAIFunctionCall struct {
+	Name string `json:"name"`
+	// The arguments, encoded as a json object.
+	Arguments string `json:"arguments"`
+}
+
+// UnmarshalJSON also accepts content that is null or a list of content parts.
+func (m *OpenAIMessage) UnmarshalJSON(data []byte) error {
+	type plain OpenAIMessage
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	content, err := textFromJsonContent(raw.Content)
+	if err != nil {
+		return err
+	}
+	*m = OpenAIMessage(raw.plain)
+	m.Content = content
+	return nil
+}
+
+// ExportOpenAI converts the history into OpenAI chat completions messages.
+// The first message is the system prompt, with the personality and skills as of the end of the history.
+// Notifications and summaries become system messages, and the reasoning of the agent becomes the content of its tool call messages.
+// Content parts are described in text, and mode switches and tool definitions are left out.
+func ExportOpenAI(msgs []Message) []OpenAIMessage {
+	t := getTranscript(msgs)
+	out := []OpenAIMessage{{Role: "system", Content: t.systemPrompt}}
+	for _, e := range t.entries {
+		switch e.kind {
+		case entryUser:
+			out = append(out, OpenAIMessage{Role: "user", Content: e.content})
+		case entryAgent:
+			out = append(out, OpenAIMessage{Role: "assistant", Content: e.content})
+		case entrySystemNote:
+			out = append(out, OpenAIMessage{Role: "system", Content: e.content})
+		case entryToolCalls:
+			calls := make([]OpenAIToolCall, len(e.toolCalls))
+			for i, tc := range e.toolCalls {
+				args, _ := json.Marshal(toolArgsToMap(tc.ToolArgs))
+				calls[i] = OpenAIToolCall{
+					ID:       toolCallID(e.callsIndex, i),
+					Type:     "function",
+					Function: OpenAIFunctionCall{Name: tc.ToolName, Arguments: string(args)},
+				}
+			}
+			out = append(out, OpenAIMessage{Role: "assistant", Content: e.content, ToolCalls: calls})
+		case entryToolResponses:
+			for i, r := range e.responses {
+				out = append(out, OpenAIMessage{Role: "tool", Content: toolResponseText(r), ToolCallID: toolCallID(e.callsIndex, i)})
+			}
+		}
+	}
+	return out
+}
+
+// ImportOpenAI converts OpenAI chat completions messages into a history that can be passed to [NewFromSaved].
+// A system message at the start becomes the personality of the agent, and any later ones become notifications.
+// Notifications and summaries written by [ExportOpenAI] are imported as they were.
+func ImportOpenAI(msgs []OpenAIMessage) ([]Message, error) {
+	imp := newTranscriptImporter()
+	for _, m := range msgs {
+		switch m.Role {
+		case "system", "developer":
+			imp.system(m.Content)
+		case "user":
+			imp.user(m.Content)
+		case "assistant":
+			if len(m.ToolCalls) == 0 {
+				imp.agent(m.Content)
+				continue
+			}
+			ids := make([]string, len(m.ToolCalls))
+			calls := make([]ToolCall, len(m.ToolCalls))
+			for i, tc := range m.ToolCalls {
+				args := make(map[string]any)
+				if tc.Function.Arguments != "" {
+					if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+						return nil, fmt.Errorf("%w: arguments of tool call '%s' are not a json object: %w", ErrInvalidTranscript, tc.ID, err)
+					}
+				}
+				ids[i] = tc.ID
+				calls[i] = ToolCall{ToolName: tc.Function.Name, ToolArgs: toolArgsFromMap(args)}
+			}
+			imp.toolCalls(m.Content, ids, calls)
+		case "tool":
+			if err := imp.toolResult(m.ToolCallID, m.Content); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown role '%s'", ErrInvalidTranscript, m.Role)
+		}
+	}
+	return imp.finish(), nil
+}
@@ -0,0 +1,110 @@
+package react
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ShareGPTConversation is a conversation in the ShareGPT dataset format.
+type ShareGPTConversation struct {
+	Conversations []ShareGPTTurn `json:"conversations"`
+}
+
+// ShareGPTTurn is one message of a ShareGPT conversation.
+// From is one of "system", "human", "gpt", "function_call", or "observation".
+type ShareGPTTurn struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+// The value of a function_call turn is a json list of these.
+type shareGPTFunctionCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// ExportShareGPT converts the history into a ShareGPT conversation.
+// Tool calls become a function_call turn holding a json list of the calls, and their responses become a single observation turn.
+// The reasoning of the agent is left out, as the format has nowhere to put it.
+func ExportShareGPT(msgs []Message) ShareGPTConversation {
+	t := getTranscript(msgs)
+	turns := []ShareGPTTurn{{From: "system", Value: t.systemPrompt}}
+	for _, e := range t.entries {
+		switch e.kind {
+		case entryUser:
+			turns = append(turns, ShareGPTTurn{From: "human", Value: e.content})
+		case entryAgent:
+			turns = append(turns, ShareGPTTurn{From: "gpt", Value: e.content})
+		case entrySystemNote:
+			turns = append(turns, ShareGPTTurn{From: "system", Value: e.content})
+		case entryToolCalls:
+			calls := make([]shareGPTFunctionCall, len(e.toolCalls))
+			for i, tc := range e.toolCalls {
+				calls[i] = shareGPTFunctionCall{Name: tc.ToolName, Arguments: toolArgsToMap(tc.ToolArgs)}
+			}
+			value, _ := json.Marshal(calls)
+			turns = append(turns, ShareGPTTurn{From: "function_call", Value: string(value)})
+		case entryToolResponses:
+			results := make([]string, len(e.responses))
+			for i, r := range e.responses {
+				results[i] = toolResponseText(r)
+			}
+			value, _ := json.Marshal(results)
+			turns = append(turns, ShareGPTTurn{From: "observation", Value: string(value)})
+		}
+	}
+	return ShareGPTConversation{Conversations: turns}
+}
+
+// ImportShareGPT converts a ShareGPT conversation into a history that can be passed to [NewFromSaved].
+// A function_call turn may hold a single call or a json list of calls,
+// and an observation turn may hold a json list with one result per call or a single result.
+func ImportShareGPT(conv ShareGPTConversation) ([]Message, error) {
+	imp := newTranscriptImporter()
+	var ids []string
+	numCalls := 0
+	for _, t := range conv.Conversations {
+		switch t.From {
+		case "system":
+			imp.system(t.Value)
+		case "human", "user":
+			imp.user(t.Value)
+		case "gpt", "assistant":
+			imp.agent(t.Value)
+		case "function_call":
+			var calls []shareGPTFunctionCall
+			value := strings.TrimSpace(t.Value)
+			if strings.HasPrefix(value, "{") {
+				value = "[" + value + "]"
+			}
+			if err := json.Unmarshal([]byte(value), &calls); err != nil {
+				return nil, fmt.Errorf("%w: function_call is not a json function call: %w", ErrInvalidTranscript, err)
+			}
+			ids = make([]string, len(calls))
+			toolCalls := make([]ToolCall, len(calls))
+			for i, c := range calls {
+				ids[i] = toolCallID(numCalls, i)
+				toolCalls[i] = ToolCall{ToolName: c.Name, ToolArgs: toolArgsFromMap(c.Arguments)}
+			}
+			numCalls++
+			imp.toolCalls("", ids, toolCalls)
+		case "observation":
+			var results []string
+			if err := json.Unmarshal([]byte(t.Value), &results); err != nil || len(results) != len(ids) {
+				results = []string{t.Value}
+			}
+			for i, r := range results {
+				if i >= len(ids) {
+					return nil, fmt.Errorf("%w: observation without a function_call", ErrInvalidTranscript)
+				}
+				if err := imp.toolResult(ids[i], r); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown sender '%s'", ErrInvalidTranscript, t.From)
+		}
+	}
+	return imp.finish(), nil
+}
@@ -0,0 +1,155 @@
+package react_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+// Build a conversation with tool calls, notifications, and several turns.
+func interopConversation(t *testing.T) []react.Message {
+	mb := reacttest.NewModelBuilder(t)
+	ag := react.New(mb, react.WithTools(reacttest.NewTool("lookup", "42"), reacttest.NewTool("search", "found it")))
+	mb.QueueReAct("I should look it up", reacttest.Call("lookup", map[string]any{"query": "answer", "limit": 3.0}))
+	mb.QueueReAct("And search too", reacttest.Call("search", map[string]any{"q": "x"}), reacttest.Call("lookup", map[string]any{"query": "y"}))
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("The answer is 42")
+	if _, err := ag.Send("What is the answer?"); err != nil {
+		t.Fatal(err)
+	}
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("Bye")
+	if _, err := ag.Send("Thanks", react.WithNotifications(react.Notification{Kind: "reminder", Content: "be brief"})); err != nil {
+		t.Fatal(err)
+	}
+	return collectMessages(ag)
+}
+
+func collectMessages(ag *react.Agent) []react.Message {
+	var msgs []react.Message
+	for m := range ag.Messages() {
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// Check that the value survives being written to json and read back.
+func jsonRoundTrip[T any](t *testing.T, v T) T {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestInteropRoundTrip(t *testing.T) {
+	msgs := interopConversation(t)
+	cases := []struct {
+		name string
+		// Export the messages, write them to json and back, then import them again.
+		roundTrip func(t *testing.T, msgs []react.Message) []react.Message
+		// The exported form, without the system prompt (which includes the personality, so changes when imported).
+		export func(msgs []react.Message) any
+	}{
+		{"openai",
+			func(t *testing.T, msgs []react.Message) []react.Message {
+				out, err := react.ImportOpenAI(jsonRoundTrip(t, react.ExportOpenAI(msgs)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			},
+			func(msgs []react.Message) any { return react.ExportOpenAI(msgs)[1:] },
+		},
+		{"anthropic",
+			func(t *testing.T, msgs []react.Message) []react.Message {
+				out, err := react.ImportAnthropic(jsonRoundTrip(t, react.ExportAnthropic(msgs)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			},
+			func(msgs []react.Message) any { return react.ExportAnthropic(msgs).Messages },
+		},
+		{"sharegpt",
+			func(t *testing.T, msgs []react.Message) []react.Message {
+				out, err := react.ImportShareGPT(jsonRoundTrip(t, react.ExportShareGPT(msgs)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return out
+			},
+			func(msgs []react.Message) any { return react.ExportShareGPT(msgs).Conversations[1:] },
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			imported := tc.roundTrip(t, msgs)
+			if got, want := tc.export(imported), tc.export(msgs); !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip changed the conversation\ngot:  %+v\nwant: %+v", got, want)
+			}
+			// The imported history can be continued by an agent
+			mb := reacttest.NewModelBuilder(t)
+			ag := react.NewFromSaved(mb, imported)
+			mb.QueueReAct("Nothing to do")
+			mb.QueueFinalAnswer("Hello again")
+			if _, err := ag.Send("Hello"); err != nil {
+				t.Fatal(err)
+			}
+			mb.AssertPromptContains(reacttest.CallReAct, 0, "The answer is 42")
+		})
+	}
+}
+
+func TestInteropInvalidTranscripts(t *testing.T) {
+	cases := []struct {
+		name     string
+		importFn func() error
+	}{
+		{"openai unknown tool result", func() error {
+			_, err := react.ImportOpenAI([]react.OpenAIMessage{{Role: "tool", ToolCallID: "missing", Content: "x"}})
+			return err
+		}},
+		{"sharegpt bad function call", func() error {
+			_, err := react.ImportShareGPT(react.ShareGPTConversation{Conversations: []react.ShareGPTTurn{{From: "function_call", Value: "not json"}}})
+			return err
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.importFn(); err == nil || !strings.Contains(err.Error(), react.ErrInvalidTranscript.Error()) {
+				t.Errorf("expected ErrInvalidTranscript, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteFineTuningJSONL(t *testing.T) {
+	msgs := interopConversation(t)
+	unanswered := msgs[:3]
+	buf := bytes.NewBuffer(nil)
+	if err := react.WriteFineTuningJSONL(buf, msgs, unanswered); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the answered conversation to be written, got %d lines", len(lines))
+	}
+	var example react.FineTuningExample
+	if err := json.Unmarshal([]byte(lines[0]), &example); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(example.Messages, react.ExportOpenAI(msgs)) {
+		t.Error("the example does not match the exported conversation")
+	}
+}
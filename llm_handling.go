@@ -11,23 +11,17 @@ import (
 )
 
 // Create the pipeline to get a react step given the messages
-func getAgentReActPipeline(model jpf.Model) jpf.Pipeline[[]Message, reasonResponse] {
-	enc := getAgentEncoder()
+func getAgentReActPipeline(enc jpf.Encoder[[]Message], model jpf.Model) jpf.Pipeline[[]Message, reasonResponse] {
 	dec := getAgentReActDecoder()
 	return jpf.NewOneShotPipeline(enc, dec, nil, model)
 }
 
 // Create the pipeline to create a final response given the messages
-func getAgentFinalAnswerPipeline(model jpf.Model) jpf.Pipeline[[]Message, string] {
-	enc := getAgentEncoder()
+func getAgentFinalAnswerPipeline(enc jpf.Encoder[[]Message], model jpf.Model) jpf.Pipeline[[]Message, string] {
 	dec := getAgentAnswerDecoder()
 	return jpf.NewOneShotPipeline(enc, dec, nil, model)
 }
 
-func getAgentEncoder() jpf.Encoder[[]Message] {
-	return &messagesEncoder{}
-}
-
 func getAgentReActDecoder() jpf.Parser[reasonResponse] {
 	return jpf.NewJsonParser[reasonResponse]()
 }
@@ -51,12 +45,41 @@ type reasonResponse struct {
 	ToolCalls []toolCall `json:"tool_calls"`
 }
 
-type messagesEncoder struct{}
+type messagesEncoder struct {
+	tokenizer       Tokenizer
+	budget          contextBudget
+	elideAfterTurns int
+	redactor        Redactor
+}
 
 func (m *messagesEncoder) BuildInputMessages(msgs []Message) ([]jpf.Message, error) {
-	converter := &jpfMessageConverter{}
-	convertMessages(converter, msgs)
-	return converter.Messages(), nil
+	msgs = redactMessages(m.redactor, msgs)
+	if m.budget.maxTokens <= 0 {
+		return m.encode(msgs), nil
+	}
+	fits := func(msgs []Message) bool {
+		return CountMessageTokens(m.tokenizer, m.encode(msgs)) <= m.budget.maxTokens
+	}
+	if !fits(msgs) {
+		var err error
+		msgs, err = m.budget.strategy.FitBudget(msgs, fits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m.encode(msgs), nil
+}
+
+func (m *messagesEncoder) encode(msgs []Message) []jpf.Message {
+	converter := &jpfMessageConverter{
+		elideAfterTurns: m.elideAfterTurns,
+		totalTurns:      countTurns(msgs),
+	}
+	for _, msg := range msgs {
+		converter.messageID = msg.Info().ID
+		VisitMessages(converter, msg)
+	}
+	return converter.Messages()
 }
 
 func toolCallsMessageFromResponse(response reasonResponse) toolCallsMessage {
@@ -64,18 +87,22 @@ func toolCallsMessageFromResponse(response reasonResponse) toolCallsMessage {
 		Reasoning: response.Reasoning,
 	}
 	for _, tc := range response.ToolCalls {
-		args := make([]ToolCallArg, 0)
-		for _, a := range tc.ToolArgs {
-			args = append(args, ToolCallArg(a))
-		}
-		finalMessage.ToolCalls = append(finalMessage.ToolCalls, ToolCall{
-			ToolName: tc.ToolName,
-			ToolArgs: args,
-		})
+		finalMessage.ToolCalls = append(finalMessage.ToolCalls, toolCallFromResponse(tc))
 	}
 	return finalMessage
 }
 
+func toolCallFromResponse(tc toolCall) ToolCall {
+	args := make([]ToolCallArg, 0)
+	for _, a := range tc.ToolArgs {
+		args = append(args, ToolCallArg(a))
+	}
+	return ToolCall{
+		ToolName: tc.ToolName,
+		ToolArgs: args,
+	}
+}
+
 func responseFromToolCallsMessage(reasoning string, calls []ToolCall) reasonResponse {
 	finalMessage := reasonResponse{
 		Reasoning: reasoning,
@@ -113,6 +140,13 @@ type jpfMessageConverter struct {
 	personality    string
 	skills         []InsertedSkill
 	activeMessages []jpf.Message
+	// Tool responses from at least this many turns ago are elided (if above 0)
+	elideAfterTurns int
+	totalTurns      int
+	turn            int
+	lastToolCalls   []ToolCall
+	// The ID of the message being converted
+	messageID string
 }
 
 func (c *jpfMessageConverter) Messages() []jpf.Message {
@@ -131,10 +165,13 @@ func (c *jpfMessageConverter) Messages() []jpf.Message {
 func (conv *jpfMessageConverter) AddSystem(template string) {
 	conv.systemTemplate = template
 }
-func (conv *jpfMessageConverter) AddUser(content string) {
+func (conv *jpfMessageConverter) AddUser(content string, parts []ContentPart) {
+	conv.turn++
+	extra, images := partsToJpf(parts)
 	conv.activeMessages = append(conv.activeMessages, jpf.Message{
 		Role:    jpf.UserRole,
-		Content: content,
+		Content: joinContent(content, extra),
+		Images:  images,
 	})
 }
 func (conv *jpfMessageConverter) AddAgent(content string) {
@@ -144,6 +181,7 @@ func (conv *jpfMessageConverter) AddAgent(content string) {
 	})
 }
 func (conv *jpfMessageConverter) AddToolCalls(reasoning string, toolCalls []ToolCall) {
+	conv.lastToolCalls = toolCalls
 	resp := responseFromToolCallsMessage(reasoning, toolCalls)
 	content, _ := json.MarshalIndent(resp, "", "    ")
 	conv.activeMessages = append(conv.activeMessages, jpf.Message{
@@ -152,15 +190,36 @@ func (conv *jpfMessageConverter) AddToolCalls(reasoning string, toolCalls []Tool
 	})
 }
 func (conv *jpfMessageConverter) AddToolResponse(responses []ToolResponse) {
+	// Without an ID (such as in an imported history) the output could not be retrieved again, so is never elided
+	elide := conv.elideAfterTurns > 0 && conv.totalTurns-conv.turn >= conv.elideAfterTurns && conv.messageID != ""
 	results := make([]string, len(responses))
+	images := make([]jpf.ImageAttachment, 0)
 	for i, r := range responses {
-		results[i] = r.Response
+		if elide {
+			toolName := "unknown"
+			if i < len(conv.lastToolCalls) {
+				toolName = conv.lastToolCalls[i].ToolName
+			}
+			results[i] = elidedToolResponse(toolName, r.Response, toolOutputHandle(conv.messageID, i))
+		} else {
+			extra, respImages := partsToJpf(r.Parts)
+			results[i] = joinContent(r.Response, extra)
+			images = append(images, respImages...)
+		}
 	}
 	toolSep := "\n==========\n"
 	conv.activeMessages = append(conv.activeMessages, jpf.Message{
 		Role:    jpf.SystemRole,
 		Content: "Tool Responses:" + toolSep + strings.Join(results, toolSep),
 	})
+	// Not all providers support images in system messages
+	if len(images) > 0 {
+		conv.activeMessages = append(conv.activeMessages, jpf.Message{
+			Role:    jpf.UserRole,
+			Content: "(Automatic message) Here are the images returned by the tools above.",
+			Images:  images,
+		})
+	}
 }
 func (conv *jpfMessageConverter) AddModeSwitch(mode AgentMode) {
 	var resultMsg jpf.Message
@@ -215,3 +274,12 @@ func (conv *jpfMessageConverter) AddToolDefs(defs []AvailableToolDefinition) {
 	}
 	conv.activeMessages = append(conv.activeMessages, resultMsg)
 }
+func (conv *jpfMessageConverter) AddSummary(summary string, replaced []Message) {
+	conv.activeMessages = append(conv.activeMessages, jpf.Message{
+		Role:    jpf.SystemRole,
+		Content: "**Summary of earlier conversation**\n" + summary,
+	})
+}
+
+// Messages of unknown kinds cannot be understood by the agent, so are left out of the prompt
+func (conv *jpfMessageConverter) AddUnknown(msg SerialisedMessage) {}
@@ -0,0 +1,106 @@
+package react
+
+import (
+	"crypto/rand"
+	"maps"
+	"time"
+)
+
+// Keys of the metadata that the agent records on messages.
+const (
+	// The turn (starting at 1) that the message was added in.
+	MetaTurn = "turn"
+	// The name of the model that produced the message, from its [ModelRoute] or if the model builder implements [ModelNamer].
+	MetaModel = "model"
+	// How many milliseconds the model took to produce the message.
+	MetaLatencyMS = "latency_ms"
+	// Set to true on a final answer that was cut off part way through (see [WithPartialAnswerRecovery]).
+	MetaPartial = "partial"
+)
+
+// MessageInfo describes a message in the history, but is never shown to the agent.
+type MessageInfo struct {
+	// A unique ID for the message.
+	ID string
+	// When the message was added to the history.
+	CreatedAt time.Time
+	// Extensible metadata about the message, such as [MetaTurn].
+	// It should be treated as read-only, as it may be shared between copies of a message.
+	Metadata map[string]any
+}
+
+// MetaInt returns a numeric metadata value as an int.
+// Numbers may be decoded as floats after serialisation, so this should be used instead of a type assertion.
+func (info MessageInfo) MetaInt(key string) (int, bool) {
+	switch v := info.Metadata[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// MetaString returns a string metadata value.
+func (info MessageInfo) MetaString(key string) (string, bool) {
+	v, ok := info.Metadata[key].(string)
+	return v, ok
+}
+
+// ModelNamer may optionally be implemented by a model builder, to record which model produced each message.
+type ModelNamer interface {
+	// The name of the model that this builder builds.
+	ModelName() string
+}
+
+func newMessageID() string {
+	return rand.Text()
+}
+
+// Fill in the ID and creation time of the message if they are missing, and add the extra metadata.
+func stampMessage(msg Message, meta map[string]any) Message {
+	info := msg.Info()
+	if info.ID == "" {
+		info.ID = newMessageID()
+	}
+	if info.CreatedAt.IsZero() {
+		info.CreatedAt = time.Now()
+	}
+	metadata := maps.Clone(info.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]any)
+	}
+	for k, v := range meta {
+		if _, ok := metadata[k]; !ok {
+			metadata[k] = v
+		}
+	}
+	info.Metadata = metadata
+	return msg.withInfo(info)
+}
+
+// Add metadata to a message, overwriting any existing values with the same keys.
+func withMetadata(msg Message, meta map[string]any) Message {
+	info := msg.Info()
+	metadata := maps.Clone(info.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]any)
+	}
+	maps.Copy(metadata, meta)
+	info.Metadata = metadata
+	return msg.withInfo(info)
+}
+
+// Find the current turn of the history, preferring the recorded turn metadata as
+// earlier turns may have been compacted away.
+func getCurrentTurn(msgs []Message) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if turn, ok := msgs[i].Info().MetaInt(MetaTurn); ok {
+			return turn
+		}
+	}
+	return countTurns(msgs)
+}
@@ -0,0 +1,73 @@
+package react
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestStampMessage(t *testing.T) {
+	before := time.Now()
+	stamped := stampMessage(userMessage{Content: "Hi"}, map[string]any{MetaTurn: 1})
+	info := stamped.Info()
+	if info.ID == "" {
+		t.Fatal("expected an ID to be generated")
+	}
+	if info.CreatedAt.Before(before) || info.CreatedAt.After(time.Now()) {
+		t.Fatalf("expected the creation time to be now, got %v", info.CreatedAt)
+	}
+	if turn, ok := info.MetaInt(MetaTurn); !ok || turn != 1 {
+		t.Fatalf("expected turn 1, got %v", info.Metadata[MetaTurn])
+	}
+	if other := stampMessage(userMessage{Content: "Hi"}, nil); other.Info().ID == info.ID {
+		t.Fatal("expected each message to be given a different ID")
+	}
+
+	// Stamping again keeps the existing ID, creation time, and metadata, only adding new keys
+	again := stampMessage(stamped, map[string]any{MetaTurn: 2, MetaModel: "fast"}).Info()
+	if again.ID != info.ID || !again.CreatedAt.Equal(info.CreatedAt) {
+		t.Fatalf("expected the ID and creation time to be kept, got %q at %v", again.ID, again.CreatedAt)
+	}
+	if turn, _ := again.MetaInt(MetaTurn); turn != 1 {
+		t.Fatalf("expected the existing turn to be kept, got %d", turn)
+	}
+	if model, _ := again.MetaString(MetaModel); model != "fast" {
+		t.Fatalf("expected the model to be added, got %q", model)
+	}
+	if _, ok := info.Metadata[MetaModel]; ok {
+		t.Fatal("expected the metadata of the original message to be unchanged")
+	}
+}
+
+func TestMessageInfoRoundTrip(t *testing.T) {
+	msg := stampMessage(agentMessage{Content: "Hello"}, map[string]any{MetaTurn: 3, MetaModel: "fast", MetaLatencyMS: int64(120)})
+	data, err := json.Marshal(SerialiseMessages([]Message{msg}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var smsgs []SerialisedMessage
+	if err := json.Unmarshal(data, &smsgs); err != nil {
+		t.Fatal(err)
+	}
+	info := DeserialiseMessages(smsgs)[0].Info()
+	// Numbers are decoded as floats, which MetaInt must still read
+	if _, ok := info.Metadata[MetaTurn].(float64); !ok {
+		t.Fatalf("expected the turn to be decoded as a float64, got %T", info.Metadata[MetaTurn])
+	}
+	if turn, ok := info.MetaInt(MetaTurn); !ok || turn != 3 {
+		t.Fatalf("expected turn 3, got %d", turn)
+	}
+	if latency, ok := info.MetaInt(MetaLatencyMS); !ok || latency != 120 {
+		t.Fatalf("expected a latency of 120ms, got %d", latency)
+	}
+	if model, ok := info.MetaString(MetaModel); !ok || model != "fast" {
+		t.Fatalf("expected the model fast, got %q", model)
+	}
+	want := msg.Info()
+	if info.ID != want.ID || !info.CreatedAt.Equal(want.CreatedAt) {
+		t.Fatalf("expected the ID %q and creation time %v to be kept, got %q and %v", want.ID, want.CreatedAt, info.ID, info.CreatedAt)
+	}
+	if getCurrentTurn(DeserialiseMessages(smsgs)) != 3 {
+		t.Fatalf("expected the current turn to be read from the decoded metadata")
+	}
+}
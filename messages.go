@@ -1,117 +1,258 @@
 package react
 
-// A message converter is an object that reads through a conversation,
-// adding messages in order, without type switching.
-type messageConverter interface {
+import "fmt"
+
+// MessageVisitor is an object that reads through a conversation,
+// being called for each message in order, without type switching.
+// Embed [BaseMessageVisitor] to only handle some kinds of message.
+type MessageVisitor interface {
+	// The template of the system prompt was set.
 	AddSystem(template string)
-	AddUser(content string)
+	// The user sent a message, optionally with some attached content.
+	AddUser(content string, parts []ContentPart)
+	// The agent gave a final answer to the user.
 	AddAgent(content string)
+	// The agent reasoned and (optionally) made some tool calls.
 	AddToolCalls(reasoning string, toolCalls []ToolCall)
+	// The responses of the previous tool calls, in the same order as the calls.
 	AddToolResponse(responses []ToolResponse)
+	// The agent switched mode.
 	AddModeSwitch(mode AgentMode)
+	// A notification was sent to the agent.
 	AddNotification(kind string, content string)
+	// The personality of the agent was set.
 	AddPersonality(personality string)
+	// The set of active skills was changed.
 	AddSkills(skills []InsertedSkill)
+	// The set of available tools was changed.
 	AddToolDefs(defs []AvailableToolDefinition)
+	// An older span of the conversation was replaced by a summary.
+	AddSummary(summary string, replaced []Message)
+	// A message of a kind this version of the package does not know about, which was loaded from a saved conversation.
+	AddUnknown(msg SerialisedMessage)
 }
 
-func convertMessages(converter messageConverter, messages []Message) {
+// VisitMessages calls the visitor with each of the messages, in order.
+func VisitMessages(visitor MessageVisitor, messages ...Message) {
 	for _, m := range messages {
-		m.convert(converter)
+		m.convert(visitor)
 	}
 }
 
+// BaseMessageVisitor is a [MessageVisitor] that does nothing for all message types.
+// Compose on this to only handle the messages you care about.
+type BaseMessageVisitor struct{}
+
+func (BaseMessageVisitor) AddSystem(template string)                           {}
+func (BaseMessageVisitor) AddUser(content string, parts []ContentPart)         {}
+func (BaseMessageVisitor) AddAgent(content string)                             {}
+func (BaseMessageVisitor) AddToolCalls(reasoning string, toolCalls []ToolCall) {}
+func (BaseMessageVisitor) AddToolResponse(responses []ToolResponse)            {}
+func (BaseMessageVisitor) AddModeSwitch(mode AgentMode)                        {}
+func (BaseMessageVisitor) AddNotification(kind string, content string)         {}
+func (BaseMessageVisitor) AddPersonality(personality string)                   {}
+func (BaseMessageVisitor) AddSkills(skills []InsertedSkill)                    {}
+func (BaseMessageVisitor) AddToolDefs(defs []AvailableToolDefinition)          {}
+func (BaseMessageVisitor) AddSummary(summary string, replaced []Message)       {}
+func (BaseMessageVisitor) AddUnknown(msg SerialisedMessage)                    {}
+
 // Message is a sum type defining the structured data that can live in agent history.
+// Use [VisitMessages] with a [MessageVisitor] to read the contents of messages.
 type Message interface {
-	convert(messageConverter)
+	// Get the ID, creation time, and metadata of the message.
+	Info() MessageInfo
+	convert(MessageVisitor)
+	withInfo(MessageInfo) Message
+}
+
+// Embedded in every message type to store its [MessageInfo].
+type messageHeader struct {
+	info MessageInfo
+}
+
+func (h messageHeader) Info() MessageInfo {
+	return h.info
 }
 
 type systemMessage struct {
+	messageHeader
 	Template string
 }
 
-func (m systemMessage) convert(c messageConverter) {
+func (m systemMessage) convert(c MessageVisitor) {
 	c.AddSystem(m.Template)
 }
 
+func (m systemMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type userMessage struct {
+	messageHeader
 	Content string
+	Parts   []ContentPart
 }
 
-func (m userMessage) convert(c messageConverter) {
-	c.AddUser(m.Content)
+func (m userMessage) convert(c MessageVisitor) {
+	c.AddUser(m.Content, m.Parts)
+}
+
+func (m userMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
 }
 
 type agentMessage struct {
+	messageHeader
 	Content string
 }
 
-func (m agentMessage) convert(c messageConverter) {
+func (m agentMessage) convert(c MessageVisitor) {
 	c.AddAgent(m.Content)
 }
 
+func (m agentMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type toolCallsMessage struct {
+	messageHeader
 	Reasoning string
 	ToolCalls []ToolCall
 }
 
-func (m toolCallsMessage) convert(c messageConverter) {
+func (m toolCallsMessage) convert(c MessageVisitor) {
 	c.AddToolCalls(m.Reasoning, m.ToolCalls)
 }
 
+func (m toolCallsMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type toolResponseMessage struct {
+	messageHeader
 	Responses []ToolResponse
 }
 
-func (m toolResponseMessage) convert(c messageConverter) {
+func (m toolResponseMessage) convert(c MessageVisitor) {
 	c.AddToolResponse(m.Responses)
 }
 
+func (m toolResponseMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type modeSwitchMessage struct {
+	messageHeader
 	Mode AgentMode
 }
 
-func (m modeSwitchMessage) convert(c messageConverter) {
+func (m modeSwitchMessage) convert(c MessageVisitor) {
 	c.AddModeSwitch(m.Mode)
 }
 
+func (m modeSwitchMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type Notification struct {
 	Kind    string
 	Content string
 }
 
 type notificationMessage struct {
+	messageHeader
 	Notification
 }
 
-func (m notificationMessage) convert(c messageConverter) {
+func (m notificationMessage) convert(c MessageVisitor) {
 	c.AddNotification(m.Kind, m.Content)
 }
 
+func (m notificationMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type personalityMessage struct {
+	messageHeader
 	Personality string
 }
 
-func (m personalityMessage) convert(c messageConverter) {
+func (m personalityMessage) convert(c MessageVisitor) {
 	c.AddPersonality(m.Personality)
 }
 
+func (m personalityMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type skillMessage struct {
+	messageHeader
 	Skills []InsertedSkill
 }
 
-func (m skillMessage) convert(c messageConverter) {
+func (m skillMessage) convert(c MessageVisitor) {
 	c.AddSkills(m.Skills)
 }
 
+func (m skillMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type toolsMessage struct {
+	messageHeader
 	Tools []AvailableToolDefinition
 }
 
-func (m toolsMessage) convert(c messageConverter) {
+func (m toolsMessage) convert(c MessageVisitor) {
 	c.AddToolDefs(m.Tools)
 }
 
+func (m toolsMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
+// A summary of an older span of the conversation, which replaces that span in the history.
+// The replaced messages are kept for auditing, but are never shown to the model.
+type summaryMessage struct {
+	messageHeader
+	Summary  string
+	Replaced []Message
+}
+
+func (m summaryMessage) convert(c MessageVisitor) {
+	c.AddSummary(m.Summary, m.Replaced)
+}
+
+func (m summaryMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
+// A message of an unknown kind, kept so that it can be serialised again unchanged.
+type unknownMessage struct {
+	messageHeader
+	Serialised SerialisedMessage
+}
+
+func (m unknownMessage) convert(c MessageVisitor) {
+	c.AddUnknown(m.Serialised)
+}
+
+func (m unknownMessage) withInfo(info MessageInfo) Message {
+	m.info = info
+	return m
+}
+
 type AvailableToolDefinition struct {
 	Name        string
 	Description []string
@@ -126,6 +267,9 @@ type Skill struct {
 	Content string
 	// How many turns after the turn it is inserted will the skill remain in context
 	RemainFor int
+	// If true, Content is a text/template that is rendered with the skill variables when the skill is inserted.
+	// The rendered text is what gets stored in the history.
+	Template bool
 }
 
 type InsertedSkill struct {
@@ -147,6 +291,7 @@ type ToolCall struct {
 
 type ToolResponse struct {
 	Response string
+	Parts    []ContentPart `json:",omitempty"`
 }
 
 type AgentMode uint8
@@ -156,3 +301,16 @@ const (
 	ModeReasonAct
 	ModeAnswerUser
 )
+
+func (m AgentMode) String() string {
+	switch m {
+	case ModeCollectContext:
+		return "collect context"
+	case ModeReasonAct:
+		return "reason-act"
+	case ModeAnswerUser:
+		return "answer user"
+	default:
+		return fmt.Sprintf("unknown mode %d", uint8(m))
+	}
+}
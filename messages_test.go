@@ -0,0 +1,98 @@
+package react
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// A visitor that records each call it receives.
+type recordingVisitor struct {
+	calls []string
+}
+
+func (v *recordingVisitor) record(format string, args ...any) {
+	v.calls = append(v.calls, fmt.Sprintf(format, args...))
+}
+
+func (v *recordingVisitor) AddSystem(template string) { v.record("system %s", template) }
+func (v *recordingVisitor) AddUser(content string, parts []ContentPart) {
+	v.record("user %s (%d parts)", content, len(parts))
+}
+func (v *recordingVisitor) AddAgent(content string) { v.record("agent %s", content) }
+func (v *recordingVisitor) AddToolCalls(reasoning string, toolCalls []ToolCall) {
+	v.record("tool calls %s (%d calls)", reasoning, len(toolCalls))
+}
+func (v *recordingVisitor) AddToolResponse(responses []ToolResponse) {
+	v.record("tool responses (%d responses)", len(responses))
+}
+func (v *recordingVisitor) AddModeSwitch(mode AgentMode) { v.record("mode %s", mode) }
+func (v *recordingVisitor) AddNotification(kind string, content string) {
+	v.record("notification %s %s", kind, content)
+}
+func (v *recordingVisitor) AddPersonality(personality string) {
+	v.record("personality %s", personality)
+}
+func (v *recordingVisitor) AddSkills(skills []InsertedSkill) {
+	v.record("skills (%d skills)", len(skills))
+}
+func (v *recordingVisitor) AddToolDefs(defs []AvailableToolDefinition) {
+	v.record("tools (%d tools)", len(defs))
+}
+func (v *recordingVisitor) AddSummary(summary string, replaced []Message) {
+	v.record("summary %s (%d replaced)", summary, len(replaced))
+}
+func (v *recordingVisitor) AddUnknown(msg SerialisedMessage) { v.record("unknown %s", msg.Kind) }
+
+// A visitor that only handles user messages.
+type userVisitor struct {
+	BaseMessageVisitor
+	users []string
+}
+
+func (v *userVisitor) AddUser(content string, parts []ContentPart) {
+	v.users = append(v.users, content)
+}
+
+func TestVisitMessages(t *testing.T) {
+	msgs := []Message{
+		systemMessage{Template: "You are an agent"},
+		personalityMessage{Personality: "Friendly"},
+		toolsMessage{Tools: []AvailableToolDefinition{{Name: "weather"}}},
+		skillMessage{Skills: []InsertedSkill{{Skill: Skill{Key: "polite"}}}},
+		notificationMessage{Notification: Notification{Kind: "time", Content: "9am"}},
+		userMessage{Content: "Hi", Parts: []ContentPart{TextPart("an attachment")}},
+		modeSwitchMessage{Mode: ModeReasonAct},
+		toolCallsMessage{Reasoning: "Checking", ToolCalls: []ToolCall{{ToolName: "weather"}}},
+		toolResponseMessage{Responses: []ToolResponse{{Response: "sunny"}}},
+		agentMessage{Content: "It is sunny"},
+		summaryMessage{Summary: "The user said hi", Replaced: []Message{userMessage{Content: "Hi"}}},
+		unknownMessage{Serialised: SerialisedMessage{Kind: "future"}},
+	}
+	want := []string{
+		"system You are an agent",
+		"personality Friendly",
+		"tools (1 tools)",
+		"skills (1 skills)",
+		"notification time 9am",
+		"user Hi (1 parts)",
+		"mode reason-act",
+		"tool calls Checking (1 calls)",
+		"tool responses (1 responses)",
+		"agent It is sunny",
+		"summary The user said hi (1 replaced)",
+		"unknown future",
+	}
+	visitor := &recordingVisitor{}
+	VisitMessages(visitor, msgs...)
+	if !slices.Equal(visitor.calls, want) {
+		t.Fatalf("expected the visitor to be called with\n%q\ngot\n%q", want, visitor.calls)
+	}
+
+	// The base visitor ignores every kind of message that is not handled
+	users := &userVisitor{}
+	VisitMessages(users, msgs...)
+	if !slices.Equal(users.users, []string{"Hi"}) {
+		t.Fatalf("expected only the user message to be handled, got %v", users.users)
+	}
+}
@@ -0,0 +1,201 @@
+package react
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/JoshPattman/jpf"
+)
+
+var ErrNoRouteBuilder = errors.New("model route has no builder")
+
+// ModelPhase is the part of a turn that a model is being used for.
+type ModelPhase string
+
+const (
+	PhaseSkillSelection ModelPhase = "skill_selection"
+	PhaseReAct          ModelPhase = "react"
+	PhaseFinalAnswer    ModelPhase = "final_answer"
+	PhaseSummary        ModelPhase = "summary"
+)
+
+// RouteContext describes a model call, so a [ModelRouter] can choose which model to use for it.
+type RouteContext struct {
+	Phase ModelPhase
+	// The current turn (starting at 1), or 0 if it is not known.
+	Turn int
+	// How many reason-act steps have already been taken this turn, so 0 for the first step.
+	Iteration int
+	// How many tool calls have failed this turn.
+	ToolErrors int
+}
+
+// ModelRoute is a model chosen by a [ModelRouter].
+type ModelRoute struct {
+	// The name recorded as [MetaModel] on messages the model produces.
+	// If empty, the name of the builder is used if it implements [ModelNamer].
+	Name    string
+	Builder ModelBuilder
+}
+
+// ModelRouter chooses which model to use for each model call of the agent.
+type ModelRouter interface {
+	Route(RouteContext) ModelRoute
+}
+
+// ModelRouterFunc is a function that implements [ModelRouter].
+type ModelRouterFunc func(RouteContext) ModelRoute
+
+func (f ModelRouterFunc) Route(rc RouteContext) ModelRoute {
+	return f(rc)
+}
+
+// EscalationPolicy decides when an escalating router (see [NewEscalatingRouter]) uses the strong model.
+type EscalationPolicy struct {
+	// Use the strong model for reason-act steps once this many steps have been taken in the turn, or never if 0.
+	AfterIterations int
+	// Use the strong model for reason-act steps once this many tool calls have failed in the turn, or never if 0.
+	AfterToolErrors int
+	// Use the strong model for final answers.
+	StrongFinalAnswer bool
+	// Use the strong model for summaries.
+	StrongSummary bool
+}
+
+// NewEscalatingRouter creates a [ModelRouter] that uses the fast model for skill selection and early reason-act steps,
+// escalating to the strong model as the policy describes.
+func NewEscalatingRouter(fast, strong ModelRoute, policy EscalationPolicy) ModelRouter {
+	if fast.Builder == nil || strong.Builder == nil {
+		panic("NewEscalatingRouter requires a builder for both routes")
+	}
+	return &escalatingRouter{fast, strong, policy}
+}
+
+type escalatingRouter struct {
+	fast   ModelRoute
+	strong ModelRoute
+	policy EscalationPolicy
+}
+
+func (r *escalatingRouter) Route(rc RouteContext) ModelRoute {
+	switch rc.Phase {
+	case PhaseReAct:
+		if r.policy.AfterIterations > 0 && rc.Iteration >= r.policy.AfterIterations {
+			return r.strong
+		}
+		if r.policy.AfterToolErrors > 0 && rc.ToolErrors >= r.policy.AfterToolErrors {
+			return r.strong
+		}
+	case PhaseFinalAnswer:
+		if r.policy.StrongFinalAnswer {
+			return r.strong
+		}
+	case PhaseSummary:
+		if r.policy.StrongSummary {
+			return r.strong
+		}
+	}
+	return r.fast
+}
+
+// NewRoutedModelBuilder creates a [ModelBuilder] that builds the model the router chooses for the phase,
+// such as to pass to [NewSummariser] or [NewSkillSelector].
+// The route is chosen once for each call of a built model, and the model reports the name of the route that served it (see [ServedByReporter]).
+// Only the phase and the turn of the route context are set, and the turn is only known when the agent makes the call.
+func NewRoutedModelBuilder(router ModelRouter, phase ModelPhase) ModelBuilder {
+	return &routedModelBuilder{router, phase}
+}
+
+type routedModelBuilder struct {
+	router ModelRouter
+	phase  ModelPhase
+}
+
+func (b *routedModelBuilder) BuildAgentModel(responseType any, onInitFinalStream func(), onDataFinalStream func(string)) jpf.Model {
+	return &routedModel{router: b.router, phase: b.phase, build: func(mb ModelBuilder) jpf.Model {
+		return mb.BuildAgentModel(responseType, onInitFinalStream, onDataFinalStream)
+	}}
+}
+
+func (b *routedModelBuilder) BuildFragmentSelectorModel(responseType any) jpf.Model {
+	return &routedModel{router: b.router, phase: b.phase, build: func(mb ModelBuilder) jpf.Model {
+		return mb.BuildFragmentSelectorModel(responseType)
+	}}
+}
+
+// The name of the model the router chooses for the phase, without a turn.
+// The name of the model that actually served a call is reported by the built model.
+func (b *routedModelBuilder) ModelName() string {
+	return b.router.Route(RouteContext{Phase: b.phase}).name()
+}
+
+// A model that routes each call, building the chosen model for it.
+type routedModel struct {
+	router   ModelRouter
+	phase    ModelPhase
+	build    func(ModelBuilder) jpf.Model
+	lock     sync.Mutex
+	servedBy string
+}
+
+func (m *routedModel) Respond(ctx context.Context, msgs []jpf.Message) (jpf.ModelResponse, error) {
+	route := m.router.Route(RouteContext{Phase: m.phase, Turn: routeTurn(ctx)})
+	if route.Builder == nil {
+		return jpf.ModelResponse{}, fmt.Errorf("%w: %s phase", ErrNoRouteBuilder, m.phase)
+	}
+	model := m.build(route.Builder)
+	resp, err := model.Respond(ctx, msgs)
+	m.lock.Lock()
+	m.servedBy = servedModelName(model, route.name())
+	m.lock.Unlock()
+	return resp, err
+}
+
+func (m *routedModel) ServedBy() string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.servedBy
+}
+
+type routeTurnKey struct{}
+
+// Record the turn in the context, for models built by [NewRoutedModelBuilder].
+func withRouteTurn(ctx context.Context, turn int) context.Context {
+	return context.WithValue(ctx, routeTurnKey{}, turn)
+}
+
+func routeTurn(ctx context.Context) int {
+	turn, _ := ctx.Value(routeTurnKey{}).(int)
+	return turn
+}
+
+// The name of the route, falling back to the name of its builder.
+func (r ModelRoute) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return modelName(r.Builder)
+}
+
+// The name of the model the builder builds, or an empty string if it does not implement [ModelNamer].
+func modelName(builder any) string {
+	if namer, ok := builder.(ModelNamer); ok {
+		return namer.ModelName()
+	}
+	return ""
+}
+
+// Choose the builder for an agent model call, and the name of the model it builds.
+func (ag *Agent) routeModel(rc RouteContext) (AgentModelBuilder, string, error) {
+	if ag.router == nil {
+		return ag.modelBuilder, modelName(ag.modelBuilder), nil
+	}
+	rc.Turn = ag.turn
+	route := ag.router.Route(rc)
+	if route.Builder == nil {
+		return nil, "", fmt.Errorf("%w: %s phase", ErrNoRouteBuilder, rc.Phase)
+	}
+	return route.Builder, route.name(), nil
+}
@@ -0,0 +1,112 @@
+package react_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func TestEscalatingRouter(t *testing.T) {
+	fast := react.ModelRoute{Name: "fast", Builder: reacttest.NewModelBuilder(t)}
+	strong := react.ModelRoute{Name: "strong", Builder: reacttest.NewModelBuilder(t)}
+	router := react.NewEscalatingRouter(fast, strong, react.EscalationPolicy{AfterIterations: 3, AfterToolErrors: 2, StrongFinalAnswer: true})
+	cases := []struct {
+		rc   react.RouteContext
+		want string
+	}{
+		{react.RouteContext{Phase: react.PhaseSkillSelection}, "fast"},
+		{react.RouteContext{Phase: react.PhaseReAct}, "fast"},
+		{react.RouteContext{Phase: react.PhaseReAct, Iteration: 3}, "strong"},
+		{react.RouteContext{Phase: react.PhaseReAct, ToolErrors: 1}, "fast"},
+		{react.RouteContext{Phase: react.PhaseReAct, ToolErrors: 2}, "strong"},
+		{react.RouteContext{Phase: react.PhaseFinalAnswer}, "strong"},
+		{react.RouteContext{Phase: react.PhaseSummary}, "fast"},
+	}
+	for _, tc := range cases {
+		if got := router.Route(tc.rc).Name; got != tc.want {
+			t.Errorf("%+v: expected %s, got %s", tc.rc, tc.want, got)
+		}
+	}
+}
+
+func TestEscalatingRouterRequiresBuilders(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a route without a builder")
+		}
+	}()
+	react.NewEscalatingRouter(react.ModelRoute{Name: "fast"}, react.ModelRoute{Name: "strong", Builder: reacttest.NewModelBuilder(t)}, react.EscalationPolicy{})
+}
+
+func TestAgentRouting(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	var routed []react.RouteContext
+	router := react.ModelRouterFunc(func(rc react.RouteContext) react.ModelRoute {
+		routed = append(routed, rc)
+		return react.ModelRoute{Name: string(rc.Phase), Builder: mb}
+	})
+	ag := react.New(mb,
+		react.WithModelRouter(router),
+		react.WithSkills(react.Skill{Key: "weather", When: "the user asks about the weather", Content: "Use celsius"}),
+		react.WithCompaction(react.NewSummariser(react.NewRoutedModelBuilder(router, react.PhaseSummary)), 1, 0),
+	)
+	mb.QueueSkillSelection("weather")
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("Sunny")
+	mb.QueueSummary("The user asked about the weather")
+	mb.QueueSkillSelection()
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("Rainy")
+	for _, msg := range []string{"Is it sunny?", "Is it raining?"} {
+		if _, err := ag.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mb.AssertExhausted()
+
+	wantRouted := []react.RouteContext{
+		{Phase: react.PhaseSkillSelection, Turn: 1},
+		{Phase: react.PhaseReAct, Turn: 1},
+		{Phase: react.PhaseFinalAnswer, Turn: 1},
+		{Phase: react.PhaseSummary, Turn: 1},
+		{Phase: react.PhaseSkillSelection, Turn: 2},
+		{Phase: react.PhaseReAct, Turn: 2},
+		{Phase: react.PhaseFinalAnswer, Turn: 2},
+	}
+	if !reflect.DeepEqual(routed, wantRouted) {
+		t.Fatalf("expected each call to be routed once with\n%v\ngot\n%v", wantRouted, routed)
+	}
+	wantModels := map[react.SerialisedMessageKind]string{
+		react.KindSkills:    string(react.PhaseSkillSelection),
+		react.KindToolCalls: string(react.PhaseReAct),
+		react.KindAgent:     string(react.PhaseFinalAnswer),
+		react.KindSummary:   string(react.PhaseSummary),
+	}
+	for msg := range ag.Messages() {
+		want, ok := wantModels[react.KindOf(msg)]
+		if !ok {
+			continue
+		}
+		// The skills inserted when the agent was created were not selected by a model
+		if turn, _ := msg.Info().MetaInt(react.MetaTurn); react.KindOf(msg) == react.KindSkills && turn == 0 {
+			continue
+		}
+		if got, _ := msg.Info().MetaString(react.MetaModel); got != want {
+			t.Errorf("expected %s message to be from model %q, got %q", react.KindOf(msg), want, got)
+		}
+	}
+}
+
+func TestAgentRouteWithoutBuilder(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	router := react.ModelRouterFunc(func(rc react.RouteContext) react.ModelRoute {
+		return react.ModelRoute{Name: "missing"}
+	})
+	ag := react.New(mb, react.WithModelRouter(router))
+	if _, err := ag.Send("Hello"); !errors.Is(err, react.ErrNoRouteBuilder) {
+		t.Fatalf("expected ErrNoRouteBuilder, got %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+package react
+
+import (
+	"encoding/json"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ReActStreamer defines a callback interface that can be used to listen to the reasoning and tool calls of the agent as they are generated.
+// If a model call is retried, the reasoning of the new attempt is streamed from the start again.
+type ReActStreamer interface {
+	// Try to send a chunk of reasoning text back, ignoring errors.
+	TrySendReasoningChunk(chunk string)
+	// Try to send a tool call back as soon as it has been fully generated, ignoring errors.
+	// The tools are only called once the agent has finished generating all of its tool calls.
+	// The call is sent before the whole response has been parsed, so it may never be made,
+	// such as when the rest of the response is invalid (failing the turn) or the model call is retried.
+	// The tool name is not checked either, so the call may be for a tool that does not exist.
+	TrySendToolCall(call ToolCall)
+}
+
+// reactStreamParser incrementally parses the json of a reason-act response as it is streamed,
+// reporting the reasoning text as it arrives and each tool call once it is complete.
+type reactStreamParser struct {
+	onReasoning func(string)
+	onToolCall  func(ToolCall)
+
+	raw      []byte
+	started  bool
+	finished bool
+	depth    int
+	inString bool
+	escape   bool
+	// How many hex digits of a \u escape are still to come
+	unicodeLeft int
+	// Whether the next string at depth 1 is a key
+	expectKey bool
+	lastKey   string
+	keyStart  int
+
+	// Where the raw content of the reasoning string starts, or -1 if not in the reasoning
+	reasoningStart int
+	// The end of the longest prefix of the reasoning that is safe to decode
+	reasoningSafe int
+	// The end of the reasoning that has already been decoded and sent
+	reasoningSent int
+
+	inToolCalls bool
+	callStart   int
+}
+
+func newReActStreamParser(onReasoning func(string), onToolCall func(ToolCall)) *reactStreamParser {
+	p := &reactStreamParser{onReasoning: onReasoning, onToolCall: onToolCall}
+	p.reset()
+	return p
+}
+
+// Forget everything parsed so far, ready for a new response.
+func (p *reactStreamParser) reset() {
+	*p = reactStreamParser{
+		onReasoning:    p.onReasoning,
+		onToolCall:     p.onToolCall,
+		reasoningStart: -1,
+	}
+}
+
+// Parse the next chunk of the response.
+func (p *reactStreamParser) write(chunk string) {
+	for i := 0; i < len(chunk); i++ {
+		p.raw = append(p.raw, chunk[i])
+		p.step(len(p.raw) - 1)
+	}
+	p.flushReasoning(false)
+}
+
+func (p *reactStreamParser) step(i int) {
+	c := p.raw[i]
+	if p.finished {
+		return
+	}
+	if !p.started {
+		// Skip anything before the object, such as a markdown code fence
+		if c == '{' {
+			p.started = true
+			p.depth = 1
+			p.expectKey = true
+		}
+		return
+	}
+	if p.inString {
+		p.stepString(i, c)
+		return
+	}
+	switch c {
+	case '"':
+		p.inString = true
+		if p.depth == 1 && p.expectKey {
+			p.keyStart = i
+		} else if p.depth == 1 && p.lastKey == "reasoning" {
+			p.reasoningStart = i + 1
+			p.reasoningSafe = i + 1
+			p.reasoningSent = i + 1
+		}
+	case ':':
+		if p.depth == 1 {
+			p.expectKey = false
+		}
+	case ',':
+		if p.depth == 1 {
+			p.expectKey = true
+		}
+	case '{', '[':
+		p.depth++
+		if c == '[' && p.depth == 2 && p.lastKey == "tool_calls" {
+			p.inToolCalls = true
+		} else if c == '{' && p.depth == 3 && p.inToolCalls {
+			p.callStart = i
+		}
+	case '}', ']':
+		p.depth--
+		if c == '}' && p.depth == 2 && p.inToolCalls {
+			var call toolCall
+			if err := json.Unmarshal(p.raw[p.callStart:i+1], &call); err == nil && p.onToolCall != nil {
+				p.onToolCall(toolCallFromResponse(call))
+			}
+		} else if c == ']' && p.depth == 1 {
+			p.inToolCalls = false
+		} else if p.depth == 0 {
+			p.finished = true
+		}
+	}
+}
+
+func (p *reactStreamParser) stepString(i int, c byte) {
+	switch {
+	case p.unicodeLeft > 0:
+		p.unicodeLeft--
+	case p.escape:
+		p.escape = false
+		if c == 'u' {
+			p.unicodeLeft = 4
+		}
+	case c == '\\':
+		p.escape = true
+	case c == '"':
+		p.inString = false
+		if p.depth == 1 && p.expectKey {
+			json.Unmarshal(p.raw[p.keyStart:i+1], &p.lastKey)
+		} else if p.reasoningStart >= 0 {
+			p.reasoningSafe = i
+			p.flushReasoning(true)
+			p.reasoningStart = -1
+		}
+		return
+	}
+	if p.reasoningStart >= 0 && !p.escape && p.unicodeLeft == 0 {
+		p.reasoningSafe = i + 1
+	}
+}
+
+// Send any newly decoded reasoning text.
+// Only the text since the last flush is decoded, as flushing always stops at the boundary of a character.
+// Unless the reasoning is complete, text that may be part of an unfinished character is held back.
+func (p *reactStreamParser) flushReasoning(complete bool) {
+	if p.reasoningStart < 0 || p.onReasoning == nil {
+		return
+	}
+	raw := p.raw[p.reasoningSent:p.reasoningSafe]
+	// Hold back a high surrogate escape, as it needs the following low surrogate to be decoded
+	if n := len(raw); !complete && n >= 6 && raw[n-6] == '\\' && raw[n-5] == 'u' {
+		if r, err := strconv.ParseUint(string(raw[n-4:]), 16, 16); err == nil && r >= 0xD800 && r < 0xDC00 {
+			raw = raw[:n-6]
+		}
+	}
+	// Hold back any incomplete utf8 character
+	for start := len(raw) - 1; !complete && start >= 0 && start >= len(raw)-utf8.UTFMax; start-- {
+		if utf8.RuneStart(raw[start]) {
+			if !utf8.FullRune(raw[start:]) {
+				raw = raw[:start]
+			}
+			break
+		}
+	}
+	var decoded string
+	if err := json.Unmarshal(append(append([]byte{'"'}, raw...), '"'), &decoded); err != nil {
+		return
+	}
+	p.reasoningSent += len(raw)
+	if decoded != "" {
+		p.onReasoning(decoded)
+	}
+}
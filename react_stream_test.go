@@ -0,0 +1,69 @@
+package react
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReActStreamParser(t *testing.T) {
+	cases := []struct {
+		name          string
+		response      string
+		wantReasoning string
+		wantCalls     []ToolCall
+	}{
+		{"plain", `{"reasoning": "I am done", "tool_calls": []}`, "I am done", nil},
+		{"escapes", `{"reasoning": "line one\nsaid \"hi\"\t\\ ok", "tool_calls": []}`, "line one\nsaid \"hi\"\t\\ ok", nil},
+		{"unicode escapes", `{"reasoning": "café 😀 \ud83dx", "tool_calls": []}`, "café 😀 �x", nil},
+		{"multibyte text", `{"reasoning": "héllo 😀 世界", "tool_calls": []}`, "héllo 😀 世界", nil},
+		{"code fence", "```json\n{\"reasoning\": \"fenced\", \"tool_calls\": []}\n```", "fenced", nil},
+		{"tool calls", `{"tool_calls": [{"tool_name": "search", "tool_args": [{"arg_name": "q", "arg_value": {"nested": ["}", "]"]}}]}, {"tool_name": "time", "tool_args": []}], "reasoning": "after {calls}"}`,
+			"after {calls}",
+			[]ToolCall{
+				{ToolName: "search", ToolArgs: []ToolCallArg{{ArgName: "q", ArgValue: map[string]any{"nested": []any{"}", "]"}}}}},
+				{ToolName: "time", ToolArgs: []ToolCallArg{}},
+			}},
+		{"tool arg named reasoning", `{"tool_calls": [{"tool_name": "note", "tool_args": [{"arg_name": "reasoning", "arg_value": "not this"}]}], "reasoning": "this"}`,
+			"this",
+			[]ToolCall{{ToolName: "note", ToolArgs: []ToolCallArg{{ArgName: "reasoning", ArgValue: "not this"}}}}},
+	}
+	for _, tc := range cases {
+		for _, size := range []int{1, 2, 3, 7, len(tc.response)} {
+			t.Run(tc.name, func(t *testing.T) {
+				var reasoning strings.Builder
+				var calls []ToolCall
+				p := newReActStreamParser(func(chunk string) {
+					if !utf8.ValidString(chunk) {
+						t.Errorf("chunk %q is not valid utf8", chunk)
+					}
+					reasoning.WriteString(chunk)
+				}, func(call ToolCall) {
+					calls = append(calls, call)
+				})
+				for i := 0; i < len(tc.response); i += size {
+					p.write(tc.response[i:min(i+size, len(tc.response))])
+				}
+				if reasoning.String() != tc.wantReasoning {
+					t.Errorf("chunk size %d: expected reasoning %q, got %q", size, tc.wantReasoning, reasoning.String())
+				}
+				if !reflect.DeepEqual(calls, tc.wantCalls) {
+					t.Errorf("chunk size %d: expected calls %v, got %v", size, tc.wantCalls, calls)
+				}
+			})
+		}
+	}
+}
+
+func TestReActStreamParserReset(t *testing.T) {
+	var reasoning strings.Builder
+	p := newReActStreamParser(func(chunk string) { reasoning.WriteString(chunk) }, nil)
+	p.write(`{"reasoning": "first att`)
+	p.reset()
+	reasoning.Reset()
+	p.write(`{"reasoning": "second", "tool_calls": []}`)
+	if reasoning.String() != "second" {
+		t.Fatalf("expected the reasoning of the new attempt, got %q", reasoning.String())
+	}
+}
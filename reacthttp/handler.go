@@ -0,0 +1,246 @@
+// Package reacthttp serves agent turns over HTTP, streaming the events of each turn back as Server-Sent Events.
+//
+// A turn is started by POSTing a json body such as {"message": "Hi", "notifications": [{"kind": "time", "content": "It is 9am"}]}.
+// Each SSE event is named after its [react.EventKind], and its data is a json object:
+//
+//   - message_appended: {"message": <react.SerialisedMessage>}
+//   - reasoning_chunk: {"chunk": "..."}
+//   - tool_call_generated: {"tool_name": "...", "tool_args": {...}}
+//   - reasoning: {"reasoning": "..."}
+//   - tool_call_start: {"index": 0, "tool_name": "...", "tool_args": {...}}
+//   - tool_call_end: {"index": 0, "tool_name": "...", "response": "..."}
+//   - text_stream_begin: {} (sent again if the model call is retried, so any text chunks already received should be discarded)
+//   - text_chunk: {"chunk": "..."}
+//   - text_stream_end: {} (the text finished streaming successfully)
+//   - text_stream_fail: {"error": "..."} (the text failed part way through streaming, or before it started)
+//   - final_answer_start: {}
+//   - final_answer_end: {"content": "...", "partial": true} (partial is only present if the answer was cut off part way through,
+//     in which case the turn ends with an error event rather than turn_complete)
+//   - turn_complete: {"turn": 1, "response": "..."}
+//   - error: {"error": "..."}
+//
+// A turn always ends with either a turn_complete or an error event.
+// If the client disconnects, the turn is cancelled.
+package reacthttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/JoshPattman/react"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionLookup finds the agent that a request is for, returning [ErrSessionNotFound] if there is no such agent.
+type SessionLookup func(r *http.Request) (*react.Agent, error)
+
+type HandlerOpt func(*handlerKwargs)
+
+// Add extra options to every turn, such as streamers or event handlers.
+func WithSendOptions(opts ...react.SendMessageOpt) func(kw *handlerKwargs) {
+	return func(kw *handlerKwargs) { kw.sendOpts = append(kw.sendOpts, opts...) }
+}
+
+// Limit the size of request bodies, which defaults to 1MB.
+func WithMaxBodyBytes(n int64) func(kw *handlerKwargs) {
+	return func(kw *handlerKwargs) { kw.maxBodyBytes = n }
+}
+
+type handlerKwargs struct {
+	sendOpts     []react.SendMessageOpt
+	maxBodyBytes int64
+}
+
+// NewHandler creates an [http.Handler] that sends the posted message to the agent found by the lookup, streaming the turn back as SSE.
+// As agents cannot run more than one turn at once, a request for an agent that is already running a turn is rejected with 409 Conflict.
+// The response writer must support flushing (see [http.ResponseController]), otherwise requests fail with 500 Internal Server Error before the turn is started.
+func NewHandler(lookup SessionLookup, opts ...HandlerOpt) http.Handler {
+	kwargs := handlerKwargs{maxBodyBytes: 1 << 20}
+	for _, o := range opts {
+		o(&kwargs)
+	}
+	return &handler{lookup: lookup, kwargs: kwargs, busy: make(map[*react.Agent]bool)}
+}
+
+type handler struct {
+	lookup SessionLookup
+	kwargs handlerKwargs
+	lock   sync.Mutex
+	// The agents that are currently running a turn
+	busy map[*react.Agent]bool
+}
+
+type request struct {
+	Message       string               `json:"message"`
+	Notifications []react.Notification `json:"notifications,omitempty"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.kwargs.maxBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	agent, err := h.lookup(r)
+	if errors.Is(err, ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !h.tryAcquire(agent) {
+		http.Error(w, "the agent is already running a turn", http.StatusConflict)
+		return
+	}
+	defer h.release(agent)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	rc := http.NewResponseController(w)
+	// Flushing sends the headers, and checks that events can be streamed before the turn is started
+	if err := rc.Flush(); errors.Is(err, http.ErrNotSupported) {
+		w.Header().Del("Cache-Control")
+		w.Header().Del("Connection")
+		http.Error(w, "the response writer does not support streaming", http.StatusInternalServerError)
+		return
+	} else if err != nil {
+		return
+	}
+
+	sendOpts := append([]react.SendMessageOpt{react.WithNotifications(req.Notifications...)}, h.kwargs.sendOpts...)
+	// The request context is cancelled when the client disconnects, which cancels the turn
+	for event, err := range agent.SendStream(r.Context(), req.Message, sendOpts...) {
+		var name string
+		var payload any
+		if err != nil {
+			name, payload = "error", errorPayload{err.Error()}
+		} else {
+			name, payload = string(event.Kind()), eventPayload(event)
+		}
+		if err := writeEvent(w, name, payload); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (h *handler) tryAcquire(agent *react.Agent) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.busy[agent] {
+		return false
+	}
+	h.busy[agent] = true
+	return true
+}
+
+func (h *handler) release(agent *react.Agent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.busy, agent)
+}
+
+func writeEvent(w http.ResponseWriter, name string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+type messageAppendedPayload struct {
+	Message react.SerialisedMessage `json:"message"`
+}
+
+type reasoningPayload struct {
+	Reasoning string `json:"reasoning"`
+}
+
+type toolCallGeneratedPayload struct {
+	ToolName string         `json:"tool_name"`
+	ToolArgs map[string]any `json:"tool_args"`
+}
+
+type toolCallStartPayload struct {
+	Index    int            `json:"index"`
+	ToolName string         `json:"tool_name"`
+	ToolArgs map[string]any `json:"tool_args"`
+}
+
+type toolCallEndPayload struct {
+	Index    int    `json:"index"`
+	ToolName string `json:"tool_name"`
+	Response string `json:"response"`
+}
+
+type textChunkPayload struct {
+	Chunk string `json:"chunk"`
+}
+
+type textStreamFailPayload struct {
+	Error string `json:"error"`
+}
+
+type finalAnswerEndPayload struct {
+	Content string `json:"content"`
+	Partial bool   `json:"partial,omitempty"`
+}
+
+type turnCompletePayload struct {
+	Turn     int    `json:"turn"`
+	Response string `json:"response"`
+}
+
+// Convert the event into its json payload, which is kept separate to the event types so that the json stays stable.
+func eventPayload(event react.Event) any {
+	switch e := event.(type) {
+	case react.MessageAppendedEvent:
+		return messageAppendedPayload{react.SerialiseMessages([]react.Message{e.Message})[0]}
+	case react.ReasoningChunkEvent:
+		return textChunkPayload{e.Chunk}
+	case react.ToolCallGeneratedEvent:
+		return toolCallGeneratedPayload{e.Call.ToolName, toolArgs(e.Call)}
+	case react.ReasoningEvent:
+		return reasoningPayload{e.Reasoning}
+	case react.ToolCallStartEvent:
+		return toolCallStartPayload{e.Index, e.Call.ToolName, toolArgs(e.Call)}
+	case react.ToolCallEndEvent:
+		return toolCallEndPayload{e.Index, e.Call.ToolName, e.Response.Response}
+	case react.TextChunkEvent:
+		return textChunkPayload{e.Chunk}
+	case react.TextStreamFailEvent:
+		return textStreamFailPayload{e.Err.Error()}
+	case react.FinalAnswerEndEvent:
+		return finalAnswerEndPayload{e.Content, e.Partial}
+	case react.TurnCompleteEvent:
+		return turnCompletePayload{e.Turn, e.Response}
+	default:
+		return struct{}{}
+	}
+}
+
+func toolArgs(call react.ToolCall) map[string]any {
+	args := make(map[string]any)
+	for _, a := range call.ToolArgs {
+		args[a.ArgName] = a.ArgValue
+	}
+	return args
+}
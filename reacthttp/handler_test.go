@@ -0,0 +1,220 @@
+package reacthttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+type sseEvent struct {
+	name string
+	data map[string]any
+}
+
+// Read the events of an SSE stream until it ends.
+func readEvents(t *testing.T, r io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.data); err != nil {
+				t.Fatalf("invalid data for event %s: %v", current.name, err)
+			}
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		default:
+			t.Fatalf("unexpected line in event stream: %q", line)
+		}
+	}
+	return events
+}
+
+func serve(t *testing.T, ag *react.Agent) *httptest.Server {
+	srv := httptest.NewServer(NewHandler(func(*http.Request) (*react.Agent, error) { return ag, nil }))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(ctx context.Context, url, body string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func TestEventFraming(t *testing.T) {
+	call := reacttest.Call("search", map[string]any{"query": "weather"})
+	cases := []struct {
+		event react.Event
+		want  string
+	}{
+		{react.ReasoningChunkEvent{Chunk: "I need"}, `{"chunk":"I need"}`},
+		{react.ToolCallGeneratedEvent{Call: call}, `{"tool_name":"search","tool_args":{"query":"weather"}}`},
+		{react.ReasoningEvent{Reasoning: "I need the weather"}, `{"reasoning":"I need the weather"}`},
+		{react.ToolCallStartEvent{Index: 1, Call: call}, `{"index":1,"tool_name":"search","tool_args":{"query":"weather"}}`},
+		{react.ToolCallEndEvent{Index: 1, Call: call, Response: react.ToolResponse{Response: "sunny"}}, `{"index":1,"tool_name":"search","response":"sunny"}`},
+		{react.TextStreamBeginEvent{}, `{}`},
+		{react.TextChunkEvent{Chunk: "It is "}, `{"chunk":"It is "}`},
+		{react.TextStreamEndEvent{}, `{}`},
+		{react.TextStreamFailEvent{Err: errors.New("connection reset")}, `{"error":"connection reset"}`},
+		{react.FinalAnswerStartEvent{}, `{}`},
+		{react.FinalAnswerEndEvent{Content: "It is sunny"}, `{"content":"It is sunny"}`},
+		{react.FinalAnswerEndEvent{Content: "It is ", Partial: true}, `{"content":"It is ","partial":true}`},
+		{react.TurnCompleteEvent{Turn: 2, Response: "It is sunny"}, `{"turn":2,"response":"It is sunny"}`},
+	}
+	for _, tc := range cases {
+		t.Run(string(tc.event.Kind()), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if err := writeEvent(rec, string(tc.event.Kind()), eventPayload(tc.event)); err != nil {
+				t.Fatal(err)
+			}
+			if want := "event: " + string(tc.event.Kind()) + "\ndata: " + tc.want + "\n\n"; rec.Body.String() != want {
+				t.Fatalf("expected %q, got %q", want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandlerStreamsTurn(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueReAct("I need the time", reacttest.Call("time", nil))
+	mb.QueueReAct("I have the time")
+	mb.QueueFinalAnswer("It is 12:00")
+	ag := react.New(mb, react.WithTools(reacttest.NewTool("time", "12:00")))
+	resp, err := post(context.Background(), serve(t, ag).URL, `{"message": "What is the time?"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", ct)
+	}
+	events := readEvents(t, resp.Body)
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.name)
+	}
+	for _, want := range []react.EventKind{
+		react.EventMessageAppended,
+		react.EventToolCallStart,
+		react.EventToolCallEnd,
+		react.EventTextStreamBegin,
+		react.EventTextChunk,
+		react.EventTextStreamEnd,
+		react.EventFinalAnswerEnd,
+	} {
+		if !slices.Contains(kinds, string(want)) {
+			t.Errorf("expected a %s event, got %v", want, kinds)
+		}
+	}
+	last := events[len(events)-1]
+	if last.name != string(react.EventTurnComplete) || last.data["response"] != "It is 12:00" {
+		t.Fatalf("expected the turn to complete with the final answer, got %s %v", last.name, last.data)
+	}
+	mb.AssertExhausted()
+}
+
+func TestHandlerError(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueError(reacttest.CallReAct, errors.New("model unavailable"))
+	resp, err := post(context.Background(), serve(t, react.New(mb)).URL, `{"message": "Hello"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp.Body)
+	last := events[len(events)-1]
+	if last.name != "error" || !strings.Contains(last.data["error"].(string), "model unavailable") {
+		t.Fatalf("expected the turn to end with an error event, got %s %v", last.name, last.data)
+	}
+}
+
+// A tool that waits for its context to be cancelled.
+type waitingTool struct {
+	started   chan struct{}
+	cancelled chan struct{}
+}
+
+func newWaitingTool() *waitingTool {
+	return &waitingTool{make(chan struct{}), make(chan struct{})}
+}
+
+func (t *waitingTool) Name() string          { return "wait" }
+func (t *waitingTool) Description() []string { return []string{"Waits"} }
+func (t *waitingTool) Call(map[string]any) (string, error) {
+	return "", errors.New("expected CallContext to be used")
+}
+func (t *waitingTool) CallContext(ctx context.Context, _ map[string]any) (string, []react.ContentPart, error) {
+	close(t.started)
+	<-ctx.Done()
+	close(t.cancelled)
+	return "", nil, ctx.Err()
+}
+
+func TestHandlerBusyAndDisconnect(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueReAct("I will wait", reacttest.Call("wait", nil))
+	tool := newWaitingTool()
+	srv := serve(t, react.New(mb, react.WithTools(tool)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, err := post(ctx, srv.URL, `{"message": "Wait for me"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Body.Close()
+	<-tool.started
+
+	busy, err := post(context.Background(), srv.URL, `{"message": "Are you there?"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy.Body.Close()
+	if busy.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for a busy agent, got %d", busy.StatusCode)
+	}
+
+	cancel()
+	select {
+	case <-tool.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the turn to be cancelled when the client disconnected")
+	}
+}
+
+// A response writer that hides the flushing of the writer it wraps.
+type unflushableWriter struct {
+	http.ResponseWriter
+}
+
+func TestHandlerRequiresFlushing(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	h := NewHandler(func(*http.Request) (*react.Agent, error) { return react.New(mb), nil })
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(unflushableWriter{rec}, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"message": "Hello"}`)))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when the response cannot be streamed, got %d", rec.Code)
+	}
+	if len(mb.Calls()) != 0 {
+		t.Fatal("expected the turn not to be started")
+	}
+}
@@ -0,0 +1,101 @@
+package reacttest
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/JoshPattman/react"
+)
+
+// Collects the final answers and tool calls from the history.
+type historyCollector struct {
+	react.BaseMessageVisitor
+	answers   []string
+	toolCalls []react.ToolCall
+}
+
+func (c *historyCollector) AddAgent(content string) {
+	c.answers = append(c.answers, content)
+}
+
+func (c *historyCollector) AddToolCalls(reasoning string, toolCalls []react.ToolCall) {
+	c.toolCalls = append(c.toolCalls, toolCalls...)
+}
+
+func collectHistory(ag *react.Agent) *historyCollector {
+	c := &historyCollector{}
+	react.VisitMessages(c, slices.Collect(ag.Messages())...)
+	return c
+}
+
+// FinalAnswer returns the latest final answer of the agent, or false if it has not answered yet.
+func FinalAnswer(ag *react.Agent) (string, bool) {
+	answers := collectHistory(ag).answers
+	if len(answers) == 0 {
+		return "", false
+	}
+	return answers[len(answers)-1], true
+}
+
+// ToolCalls returns every tool call the agent has made, in order.
+func ToolCalls(ag *react.Agent) []react.ToolCall {
+	return collectHistory(ag).toolCalls
+}
+
+// MessageKinds returns the kind of every message in the history of the agent, in order.
+func MessageKinds(ag *react.Agent) []react.SerialisedMessageKind {
+	kinds := make([]react.SerialisedMessageKind, 0)
+	for m := range ag.Messages() {
+		kinds = append(kinds, react.KindOf(m))
+	}
+	return kinds
+}
+
+// AssertFinalAnswer fails the test unless the latest final answer of the agent is want.
+func AssertFinalAnswer(t testing.TB, ag *react.Agent, want string) {
+	t.Helper()
+	got, ok := FinalAnswer(ag)
+	if !ok {
+		t.Errorf("reacttest: wanted final answer %q, but the agent has not answered", want)
+	} else if got != want {
+		t.Errorf("reacttest: wanted final answer %q, got %q", want, got)
+	}
+}
+
+// AssertToolCalled fails the test unless the agent has called the tool at least once.
+func AssertToolCalled(t testing.TB, ag *react.Agent, toolName string) {
+	t.Helper()
+	for _, c := range ToolCalls(ag) {
+		if c.ToolName == toolName {
+			return
+		}
+	}
+	t.Errorf("reacttest: wanted the agent to call tool '%s', but it did not", toolName)
+}
+
+// AssertToolNotCalled fails the test if the agent has called the tool.
+func AssertToolNotCalled(t testing.TB, ag *react.Agent, toolName string) {
+	t.Helper()
+	for _, c := range ToolCalls(ag) {
+		if c.ToolName == toolName {
+			t.Errorf("reacttest: wanted the agent not to call tool '%s', but it did", toolName)
+			return
+		}
+	}
+}
+
+// AssertMessageKinds fails the test unless the history of the agent contains messages of the kinds in order,
+// ignoring any other messages in between.
+func AssertMessageKinds(t testing.TB, ag *react.Agent, kinds ...react.SerialisedMessageKind) {
+	t.Helper()
+	got := MessageKinds(ag)
+	next := 0
+	for _, k := range got {
+		if next < len(kinds) && k == kinds[next] {
+			next++
+		}
+	}
+	if next < len(kinds) {
+		t.Errorf("reacttest: wanted message kinds %v in order, but %v was missing from %v", kinds, kinds[next], got)
+	}
+}
@@ -0,0 +1,440 @@
+package reacttest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/jpf"
+	"github.com/JoshPattman/react"
+)
+
+var ErrUnmatchedRequest = errors.New("reacttest: no recorded interaction matches the request")
+
+// RecordEnvVar is the environment variable that makes [UseCassette] record new cassettes instead of replaying them, when set to 1.
+const RecordEnvVar = "REACTTEST_RECORD"
+
+// Interaction is a single model call recorded in a [Cassette].
+// Tool output handles (such as those shown in place of output elided by [react.WithToolOutputElision]) contain random message IDs,
+// so in the messages and response they are recorded with placeholders such as message-1.0 instead,
+// which are replaced with the handles of the current run when replaying.
+type Interaction struct {
+	// Identifies the request, from its kind, response schema, and messages.
+	Hash     string            `json:"hash"`
+	Kind     CallKind          `json:"kind"`
+	Schema   json.RawMessage   `json:"schema,omitempty"`
+	Messages []CassetteMessage `json:"messages"`
+	Response string            `json:"response"`
+	// The chunks streamed back while the response was generated, replayed to any stream callbacks.
+	Chunks []string `json:"chunks,omitempty"`
+	// If the call failed, the message of the error.
+	Error string `json:"error,omitempty"`
+	// If the call failed, the class of the error from [react.ClassifyModelError],
+	// so that the replayed error is classified the same way (such as by a fallback chain).
+	ErrorClass react.ModelErrorClass `json:"error_class,omitempty"`
+}
+
+// CassetteMessage is a message sent to the model, as stored in a [Cassette].
+type CassetteMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// A hash of each attached image, as the images themselves are not stored.
+	Images []string `json:"images,omitempty"`
+}
+
+// Cassette holds recorded model calls, so they can be replayed instead of calling a real model.
+type Cassette struct {
+	lock         sync.Mutex
+	interactions []Interaction
+	// Which interactions have been replayed
+	replayed []bool
+}
+
+type cassetteFile struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// NewCassette creates an empty [Cassette] to record into.
+func NewCassette() *Cassette {
+	return &Cassette{}
+}
+
+// LoadCassette reads a [Cassette] saved with [Cassette.Save].
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file cassetteFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to read cassette '%s': %w", path, err)
+	}
+	return &Cassette{interactions: file.Interactions, replayed: make([]bool, len(file.Interactions))}, nil
+}
+
+// Save writes the cassette to the file as indented json, so changes to it can be reviewed.
+func (c *Cassette) Save(path string) error {
+	c.lock.Lock()
+	data, err := json.MarshalIndent(cassetteFile{c.interactions}, "", "  ")
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Interactions returns every interaction in the cassette, in the order they were recorded.
+func (c *Cassette) Interactions() []Interaction {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]Interaction{}, c.interactions...)
+}
+
+// Unreplayed returns the interactions that have not been replayed yet.
+func (c *Cassette) Unreplayed() []Interaction {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	unreplayed := make([]Interaction, 0)
+	for i, in := range c.interactions {
+		if !c.replayed[i] {
+			unreplayed = append(unreplayed, in)
+		}
+	}
+	return unreplayed
+}
+
+func (c *Cassette) record(in Interaction) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.interactions = append(c.interactions, in)
+	c.replayed = append(c.replayed, false)
+}
+
+// Take the first interaction with the hash that has not been replayed yet.
+func (c *Cassette) take(hash string) (Interaction, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, in := range c.interactions {
+		if !c.replayed[i] && in.Hash == hash {
+			c.replayed[i] = true
+			return in, true
+		}
+	}
+	return Interaction{}, false
+}
+
+// NewRecorder creates a [react.ModelBuilder] that calls the models of the inner builder, recording every call into the cassette.
+// The prompts are recorded as they were sent to the model, without any redaction.
+func NewRecorder(inner react.ModelBuilder, cassette *Cassette) react.ModelBuilder {
+	return &cassetteBuilder{cassette: cassette, inner: inner}
+}
+
+// NewReplayer creates a [react.ModelBuilder] that serves calls from the cassette, without calling any model.
+// Each call is served by the first interaction with the same request that has not been replayed yet.
+// A call with no matching interaction fails the test and returns [ErrUnmatchedRequest].
+func NewReplayer(t testing.TB, cassette *Cassette) react.ModelBuilder {
+	return &cassetteBuilder{cassette: cassette, t: t}
+}
+
+// UseCassette replays the cassette at the path, or records it by calling the models built by live if [RecordEnvVar] is set to 1.
+// When recording, the cassette is saved once the test finishes, unless the test failed. When replaying, the test fails if any interactions were not replayed.
+// The prompts are stored as they were sent to the model, so use [react.WithRedaction] on the agent to keep sensitive content out of the cassette.
+func UseCassette(t testing.TB, path string, live func() react.ModelBuilder) react.ModelBuilder {
+	t.Helper()
+	if os.Getenv(RecordEnvVar) == "1" {
+		cassette := NewCassette()
+		t.Cleanup(func() {
+			if t.Failed() {
+				t.Logf("reacttest: not saving cassette '%s' as the test failed", path)
+				return
+			}
+			if err := cassette.Save(path); err != nil {
+				t.Errorf("reacttest: failed to save cassette: %v", err)
+			}
+		})
+		return NewRecorder(live(), cassette)
+	}
+	cassette, err := LoadCassette(path)
+	if err != nil {
+		t.Fatalf("reacttest: failed to load cassette (set %s=1 to record it): %v", RecordEnvVar, err)
+	}
+	t.Cleanup(func() {
+		if n := len(cassette.Unreplayed()); n > 0 {
+			t.Errorf("reacttest: %d interactions in cassette '%s' were not replayed", n, path)
+		}
+	})
+	return NewReplayer(t, cassette)
+}
+
+type cassetteBuilder struct {
+	cassette *Cassette
+	// The builder to record from, or nil if replaying
+	inner react.ModelBuilder
+	t     testing.TB
+}
+
+func (b *cassetteBuilder) BuildAgentModel(responseType any, onInitFinalStream func(), onDataFinalStream func(string)) jpf.Model {
+	kind := agentCallKind(responseType, onInitFinalStream, onDataFinalStream)
+	m := &cassetteModel{builder: b, kind: kind, schema: describeSchema(responseType), onInit: onInitFinalStream, onData: onDataFinalStream}
+	if b.inner != nil && kind == CallSummary {
+		// Summaries are not streamed, so nothing is recorded as chunks
+		m.inner = b.inner.BuildAgentModel(responseType, nil, nil)
+	} else if b.inner != nil {
+		m.inner = b.inner.BuildAgentModel(responseType, m.recordInit, m.recordData)
+	}
+	return m
+}
+
+func (b *cassetteBuilder) BuildFragmentSelectorModel(responseType any) jpf.Model {
+	m := &cassetteModel{builder: b, kind: CallSkillSelection, schema: describeSchema(responseType)}
+	if b.inner != nil {
+		m.inner = b.inner.BuildFragmentSelectorModel(responseType)
+	}
+	return m
+}
+
+type cassetteModel struct {
+	builder *cassetteBuilder
+	kind    CallKind
+	schema  json.RawMessage
+	onInit  func()
+	onData  func(string)
+	inner   jpf.Model
+	// The chunks streamed since the latest stream began, while recording
+	chunks []string
+}
+
+func (m *cassetteModel) recordInit() {
+	m.chunks = nil
+	if m.onInit != nil {
+		m.onInit()
+	}
+}
+
+func (m *cassetteModel) recordData(chunk string) {
+	m.chunks = append(m.chunks, chunk)
+	if m.onData != nil {
+		m.onData(chunk)
+	}
+}
+
+func (m *cassetteModel) Respond(ctx context.Context, msgs []jpf.Message) (jpf.ModelResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return jpf.ModelResponse{}, err
+	}
+	messages, err := cassetteMessages(msgs)
+	if err != nil {
+		return jpf.ModelResponse{}, err
+	}
+	ids := newHandleIDs(messages)
+	hash := requestHash(m.kind, m.schema, messages)
+	if m.inner != nil {
+		return m.record(ctx, msgs, ids, Interaction{Hash: hash, Kind: m.kind, Schema: m.schema, Messages: messages})
+	}
+	in, ok := m.builder.cassette.take(hash)
+	if !ok {
+		err := fmt.Errorf("%w: %s call %s, last message: %s", ErrUnmatchedRequest, m.kind, hash, lastContent(messages))
+		m.builder.t.Error(err)
+		return jpf.ModelResponse{}, err
+	}
+	if in.Error != "" {
+		return jpf.ModelResponse{}, &replayedError{in.Error, in.ErrorClass}
+	}
+	if m.onInit != nil {
+		m.onInit()
+	}
+	for _, chunk := range in.Chunks {
+		if m.onData != nil {
+			m.onData(ids.restore(chunk))
+		}
+	}
+	return jpf.ModelResponse{PrimaryMessage: jpf.Message{Role: jpf.AssistantRole, Content: ids.restore(in.Response)}}, nil
+}
+
+func (m *cassetteModel) record(ctx context.Context, msgs []jpf.Message, ids *handleIDs, in Interaction) (jpf.ModelResponse, error) {
+	m.chunks = nil
+	resp, err := m.inner.Respond(ctx, msgs)
+	in.Response = ids.normalise(resp.PrimaryMessage.Content)
+	for _, chunk := range m.chunks {
+		in.Chunks = append(in.Chunks, ids.normalise(chunk))
+	}
+	if err != nil {
+		in.Error = err.Error()
+		in.ErrorClass = react.ClassifyModelError(err)
+	}
+	m.builder.cassette.record(in)
+	return resp, err
+}
+
+func cassetteMessages(msgs []jpf.Message) ([]CassetteMessage, error) {
+	out := make([]CassetteMessage, len(msgs))
+	for i, msg := range msgs {
+		out[i] = CassetteMessage{Role: msg.Role.String(), Content: msg.Content}
+		for _, img := range msg.Images {
+			encoded, err := img.ToBase64Encoded(false)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode image: %w", err)
+			}
+			sum := sha256.Sum256([]byte(encoded))
+			out[i].Images = append(out[i].Images, hex.EncodeToString(sum[:]))
+		}
+	}
+	return out, nil
+}
+
+// Matches a tool output handle, such as those shown in place of elided tool output, which starts with the random ID of a message.
+var toolOutputHandle = regexp.MustCompile(`\b([A-Z2-7]{26})(\.\d+)\b`)
+
+// Matches a tool output handle once its message ID has been replaced with a placeholder.
+var normalisedToolOutputHandle = regexp.MustCompile(`\bmessage-(\d+)(\.\d+)\b`)
+
+// handleIDs replaces the message IDs in tool output handles with placeholders numbered in the order they appear in a request,
+// so that requests from different runs of a test match, and handles in recorded responses point to the messages of the current run.
+type handleIDs struct {
+	placeholders map[string]string
+	ids          map[string]string
+}
+
+// Find the message IDs in the handles of the messages, replacing them with placeholders.
+func newHandleIDs(msgs []CassetteMessage) *handleIDs {
+	h := &handleIDs{placeholders: make(map[string]string), ids: make(map[string]string)}
+	for i := range msgs {
+		msgs[i].Content = toolOutputHandle.ReplaceAllStringFunc(msgs[i].Content, func(handle string) string {
+			match := toolOutputHandle.FindStringSubmatch(handle)
+			ph, ok := h.placeholders[match[1]]
+			if !ok {
+				ph = fmt.Sprintf("message-%d", len(h.placeholders)+1)
+				h.placeholders[match[1]] = ph
+				h.ids[ph] = match[1]
+			}
+			return ph + match[2]
+		})
+	}
+	return h
+}
+
+// Replace the message IDs of the request in the text with their placeholders.
+func (h *handleIDs) normalise(text string) string {
+	return toolOutputHandle.ReplaceAllStringFunc(text, func(handle string) string {
+		match := toolOutputHandle.FindStringSubmatch(handle)
+		if ph, ok := h.placeholders[match[1]]; ok {
+			return ph + match[2]
+		}
+		return handle
+	})
+}
+
+// Replace the placeholders in the text with the message IDs of the request.
+func (h *handleIDs) restore(text string) string {
+	return normalisedToolOutputHandle.ReplaceAllStringFunc(text, func(handle string) string {
+		match := normalisedToolOutputHandle.FindStringSubmatch(handle)
+		if id, ok := h.ids["message-"+match[1]]; ok {
+			return id + match[2]
+		}
+		return handle
+	})
+}
+
+func requestHash(kind CallKind, schema json.RawMessage, msgs []CassetteMessage) string {
+	data, _ := json.Marshal(struct {
+		Kind     CallKind          `json:"kind"`
+		Schema   json.RawMessage   `json:"schema,omitempty"`
+		Messages []CassetteMessage `json:"messages"`
+	}{kind, schema, msgs})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Describe the json shape of the response type, or nil if there is none.
+func describeSchema(responseType any) json.RawMessage {
+	if responseType == nil {
+		return nil
+	}
+	data, _ := json.Marshal(schemaOf(reflect.TypeOf(responseType)))
+	return data
+}
+
+func schemaOf(t reflect.Type) any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return []any{schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"*": schemaOf(t.Elem())}
+	case reflect.Struct:
+		fields := make(map[string]any)
+		for i := range t.NumField() {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" || !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields[name] = schemaOf(f.Type)
+		}
+		return fields
+	default:
+		return t.Kind().String()
+	}
+}
+
+func lastContent(msgs []CassetteMessage) string {
+	if len(msgs) == 0 {
+		return ""
+	}
+	content := msgs[len(msgs)-1].Content
+	if len(content) > 200 {
+		content = content[:200] + "..."
+	}
+	return content
+}
+
+// An error replayed from a cassette, which is classified by [react.ClassifyModelError] as the recorded error was.
+type replayedError struct {
+	message string
+	class   react.ModelErrorClass
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+// Context errors are matched, so cancelled and timed out calls can be detected with [errors.Is].
+func (e *replayedError) Is(target error) bool {
+	switch e.class {
+	case react.ErrorClassCancelled:
+		return target == context.Canceled
+	case react.ErrorClassTimeout:
+		return target == context.DeadlineExceeded
+	default:
+		return false
+	}
+}
+
+// A status code of the recorded class, for the classes that come from http errors.
+func (e *replayedError) HTTPStatus() int {
+	switch e.class {
+	case react.ErrorClassRateLimit:
+		return 429
+	case react.ErrorClassServer:
+		return 500
+	case react.ErrorClassAuth:
+		return 401
+	case react.ErrorClassClient:
+		return 400
+	default:
+		return 0
+	}
+}
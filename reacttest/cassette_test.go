@@ -0,0 +1,168 @@
+package reacttest_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+type statusError struct{ status int }
+
+func (e statusError) Error() string   { return "request rejected" }
+func (e statusError) HTTPStatus() int { return e.status }
+
+func TestCassetteRoundTrip(t *testing.T) {
+	live := reacttest.NewModelBuilder(t)
+	live.QueueReAct("I need the time", reacttest.Call("time", nil))
+	live.QueueReAct("I have the time")
+	live.QueueFinalAnswer("It is 12:00")
+	cassette := reacttest.NewCassette()
+	ag := react.New(reacttest.NewRecorder(live, cassette), react.WithTools(reacttest.NewTool("time", "12:00")))
+	if _, err := ag.Send("What is the time?"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := cassette.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := reacttest.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chunks []string
+	replayed := react.New(reacttest.NewReplayer(t, loaded), react.WithTools(reacttest.NewTool("time", "12:00")))
+	if _, err := replayed.Send("What is the time?", react.WithResponseStreamer(chunkCollector{&chunks})); err != nil {
+		t.Fatal(err)
+	}
+	reacttest.AssertFinalAnswer(t, replayed, "It is 12:00")
+	if len(chunks) == 0 {
+		t.Fatal("expected the final answer to be streamed when replayed")
+	}
+	if n := len(loaded.Unreplayed()); n != 0 {
+		t.Fatalf("expected every interaction to be replayed, %d were not", n)
+	}
+}
+
+type chunkCollector struct{ chunks *[]string }
+
+func (c chunkCollector) TrySendTextChunk(chunk string) { *c.chunks = append(*c.chunks, chunk) }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestCassetteReplaysErrorClass(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		target error
+	}{
+		{"rate limit", errors.New("request failed with status 429: slow down"), nil},
+		{"typed status", statusError{503}, nil},
+		{"auth", statusError{401}, nil},
+		{"network timeout", timeoutError{}, context.DeadlineExceeded},
+		{"cancelled", context.Canceled, context.Canceled},
+		{"unknown", errors.New("something went wrong"), nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			live := reacttest.NewModelBuilder(t)
+			live.QueueError(reacttest.CallReAct, tc.err)
+			cassette := reacttest.NewCassette()
+			_, recordErr := react.New(reacttest.NewRecorder(live, cassette)).Send("Hello")
+			_, replayErr := react.New(reacttest.NewReplayer(t, cassette)).Send("Hello")
+			if want, got := react.ClassifyModelError(recordErr), react.ClassifyModelError(replayErr); got != want {
+				t.Fatalf("expected the replayed error to be classified as %s, got %s", want, got)
+			}
+			if tc.target != nil && !errors.Is(replayErr, tc.target) {
+				t.Fatalf("expected the replayed error to match %v, got %v", tc.target, replayErr)
+			}
+		})
+	}
+}
+
+// Records the cleanups of a test and reports it as failed.
+type failedTest struct {
+	testing.TB
+	cleanups []func()
+}
+
+func (f *failedTest) Helper()             {}
+func (f *failedTest) Failed() bool        { return true }
+func (f *failedTest) Logf(string, ...any) {}
+func (f *failedTest) Cleanup(fn func())   { f.cleanups = append(f.cleanups, fn) }
+
+func TestUseCassetteSkipsSavingFailedTests(t *testing.T) {
+	t.Setenv(reacttest.RecordEnvVar, "1")
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	failed := &failedTest{TB: t}
+	live := reacttest.NewModelBuilder(t)
+	live.QueueReAct("Done")
+	live.QueueFinalAnswer("Hi")
+	react.New(reacttest.UseCassette(failed, path, func() react.ModelBuilder { return live })).Send("Hello")
+	for _, fn := range failed.cleanups {
+		fn()
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the cassette of a failed test not to be saved, got %v", err)
+	}
+}
+
+// The tool responses in the history of the agent, in order.
+func toolResponses(ag *react.Agent) []react.SerialisedMessage {
+	var responses []react.SerialisedMessage
+	for _, sm := range react.SerialiseMessages(slices.Collect(ag.Messages())) {
+		if sm.Kind == react.KindToolResponse {
+			responses = append(responses, sm)
+		}
+	}
+	return responses
+}
+
+// Elided tool output is shown with handles containing random message IDs, which must not stop the cassette from replaying.
+func TestCassetteWithToolOutputElision(t *testing.T) {
+	newAgent := func(mb react.ModelBuilder) *react.Agent {
+		return react.New(mb, react.WithTools(reacttest.NewTool("time", "12:00")), react.WithToolOutputElision(1))
+	}
+	live := reacttest.NewModelBuilder(t)
+	cassette := reacttest.NewCassette()
+	recorded := newAgent(reacttest.NewRecorder(live, cassette))
+	live.QueueReAct("I need the time", reacttest.Call("time", nil))
+	live.QueueReAct("I have the time")
+	live.QueueFinalAnswer("It is 12:00")
+	if _, err := recorded.Send("What is the time?"); err != nil {
+		t.Fatal(err)
+	}
+	handle := toolResponses(recorded)[0].ID + ".0"
+	live.QueueReAct("I need the old output", reacttest.Call("retrieve_tool_output", map[string]any{"handle": handle}))
+	live.QueueReAct("I have the old output")
+	live.QueueFinalAnswer("It was 12:00")
+	if _, err := recorded.Send("What was the time?"); err != nil {
+		t.Fatal(err)
+	}
+	live.AssertPromptContains(reacttest.CallReAct, -1, handle)
+
+	replayed := newAgent(reacttest.NewReplayer(t, cassette))
+	for _, msg := range []string{"What is the time?", "What was the time?"} {
+		if _, err := replayed.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reacttest.AssertFinalAnswer(t, replayed, "It was 12:00")
+	responses := toolResponses(replayed)
+	if got := responses[len(responses)-1].Responses[0].Response; got != "12:00" {
+		t.Fatalf("expected the replayed handle to retrieve the output of the current run, got %q", got)
+	}
+	if n := len(cassette.Unreplayed()); n != 0 {
+		t.Fatalf("expected every interaction to be replayed, %d were not", n)
+	}
+}
@@ -0,0 +1,268 @@
+// Package reacttest provides fakes and assertions for testing code built on react, without calling a real LLM.
+//
+// A [ModelBuilder] serves responses from queues that the test fills in advance, and records every prompt it receives:
+//
+//	mb := reacttest.NewModelBuilder(t)
+//	mb.QueueReAct("I need the time", reacttest.Call("time", nil))
+//	mb.QueueReAct("I have the time")
+//	mb.QueueFinalAnswer("It is 12:00")
+//	clock := reacttest.NewTool("time", "12:00")
+//	agent := react.New(mb, react.WithTools(clock))
+//	agent.Send("What is the time?")
+//	reacttest.AssertFinalAnswer(t, agent, "It is 12:00")
+//	clock.AssertCalled(t, 1)
+//	mb.AssertExhausted()
+package reacttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/jpf"
+	"github.com/JoshPattman/react"
+)
+
+// CallKind is the kind of model call, each of which is served from its own queue.
+type CallKind string
+
+const (
+	// A reason-act step, where the agent chooses which tools to call.
+	CallReAct CallKind = "react"
+	// A plain text response that is streamed back, such as a final answer.
+	CallText CallKind = "text"
+	// A plain text response that is not streamed back, such as a summary written with [react.NewSummariser].
+	CallSummary CallKind = "summary"
+	// A choice of relevant skills.
+	CallSkillSelection CallKind = "skill_selection"
+)
+
+// RecordedCall is a call that a [ModelBuilder] received.
+type RecordedCall struct {
+	Kind     CallKind
+	Messages []jpf.Message
+}
+
+// Prompt returns the content of all of the messages of the call joined together, to check what the model was shown.
+func (c RecordedCall) Prompt() string {
+	contents := make([]string, len(c.Messages))
+	for i, m := range c.Messages {
+		contents[i] = m.Content
+	}
+	return strings.Join(contents, "\n")
+}
+
+type scriptedResponse struct {
+	content string
+	err     error
+}
+
+// ModelBuilder is a [react.ModelBuilder] that responds with scripted responses, in the order they were queued.
+// A call with nothing left in its queue fails the test and returns an error.
+// Text is streamed back a word at a time, so streamers can be tested too.
+type ModelBuilder struct {
+	t      testing.TB
+	lock   sync.Mutex
+	queues map[CallKind][]scriptedResponse
+	calls  []RecordedCall
+}
+
+// NewModelBuilder creates a [ModelBuilder] with empty queues, which reports failures to t.
+func NewModelBuilder(t testing.TB) *ModelBuilder {
+	return &ModelBuilder{t: t, queues: make(map[CallKind][]scriptedResponse)}
+}
+
+// Call creates a tool call, with the arguments in name order.
+func Call(toolName string, args map[string]any) react.ToolCall {
+	call := react.ToolCall{ToolName: toolName}
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		call.ToolArgs = append(call.ToolArgs, react.ToolCallArg{ArgName: name, ArgValue: args[name]})
+	}
+	return call
+}
+
+// Queue a reason-act step. Queueing a step with no calls ends the reason-act loop.
+func (mb *ModelBuilder) QueueReAct(reasoning string, calls ...react.ToolCall) {
+	type toolArg struct {
+		ArgName  string `json:"arg_name"`
+		ArgValue any    `json:"arg_value"`
+	}
+	type toolCall struct {
+		ToolName string    `json:"tool_name"`
+		ToolArgs []toolArg `json:"tool_args"`
+	}
+	resp := struct {
+		Reasoning string     `json:"reasoning"`
+		ToolCalls []toolCall `json:"tool_calls"`
+	}{Reasoning: reasoning, ToolCalls: []toolCall{}}
+	for _, c := range calls {
+		tc := toolCall{ToolName: c.ToolName, ToolArgs: []toolArg{}}
+		for _, a := range c.ToolArgs {
+			tc.ToolArgs = append(tc.ToolArgs, toolArg{a.ArgName, a.ArgValue})
+		}
+		resp.ToolCalls = append(resp.ToolCalls, tc)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		mb.t.Fatalf("reacttest: cannot encode reason-act step: %v", err)
+	}
+	mb.QueueRaw(CallReAct, string(data))
+}
+
+// Queue a final answer.
+func (mb *ModelBuilder) QueueFinalAnswer(answer string) {
+	mb.QueueRaw(CallText, answer)
+}
+
+// Queue a summary, used when the agent compacts its history.
+func (mb *ModelBuilder) QueueSummary(summary string) {
+	mb.QueueRaw(CallSummary, summary)
+}
+
+// Queue a choice of skills, by their keys.
+func (mb *ModelBuilder) QueueSkillSelection(keys ...string) {
+	data, _ := json.Marshal(map[string][]string{"relevant_fragment_ids": append([]string{}, keys...)})
+	mb.QueueRaw(CallSkillSelection, string(data))
+}
+
+// Queue an exact response, such as invalid json to test how it is handled.
+func (mb *ModelBuilder) QueueRaw(kind CallKind, content string) {
+	mb.push(kind, scriptedResponse{content: content})
+}
+
+// Queue a call of the kind that fails with the error.
+func (mb *ModelBuilder) QueueError(kind CallKind, err error) {
+	mb.push(kind, scriptedResponse{err: err})
+}
+
+func (mb *ModelBuilder) push(kind CallKind, resp scriptedResponse) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.queues[kind] = append(mb.queues[kind], resp)
+}
+
+// Calls returns every call received so far, in order.
+func (mb *ModelBuilder) Calls() []RecordedCall {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	return slices.Clone(mb.calls)
+}
+
+// CallsOf returns every call of the kind received so far, in order.
+func (mb *ModelBuilder) CallsOf(kind CallKind) []RecordedCall {
+	calls := make([]RecordedCall, 0)
+	for _, c := range mb.Calls() {
+		if c.Kind == kind {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// AssertPromptContains fails the test unless the prompt of the index-th call of the kind contains the text.
+// A negative index counts back from the latest call, so -1 is the latest.
+func (mb *ModelBuilder) AssertPromptContains(kind CallKind, index int, text string) {
+	mb.t.Helper()
+	calls := mb.CallsOf(kind)
+	if index < 0 {
+		index += len(calls)
+	}
+	if index < 0 || index >= len(calls) {
+		mb.t.Errorf("reacttest: wanted %s call %d, but there were only %d", kind, index, len(calls))
+		return
+	}
+	if prompt := calls[index].Prompt(); !strings.Contains(prompt, text) {
+		mb.t.Errorf("reacttest: %s call %d prompt does not contain %q:\n%s", kind, index, text, prompt)
+	}
+}
+
+// AssertExhausted fails the test if any queued responses were not used.
+func (mb *ModelBuilder) AssertExhausted() {
+	mb.t.Helper()
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	for _, kind := range slices.Sorted(maps.Keys(mb.queues)) {
+		if n := len(mb.queues[kind]); n > 0 {
+			mb.t.Errorf("reacttest: %d queued %s responses were not used", n, kind)
+		}
+	}
+}
+
+var _ react.ModelBuilder = (*ModelBuilder)(nil)
+
+func (mb *ModelBuilder) BuildAgentModel(responseType any, onInitFinalStream func(), onDataFinalStream func(string)) jpf.Model {
+	return &scriptedModel{mb, agentCallKind(responseType, onInitFinalStream, onDataFinalStream), onInitFinalStream, onDataFinalStream}
+}
+
+// The kind of a call to an agent model built with the arguments.
+// The agent streams every final answer, so a text call that is not streamed is a summary.
+func agentCallKind(responseType any, onInitFinalStream func(), onDataFinalStream func(string)) CallKind {
+	switch {
+	case responseType != nil:
+		return CallReAct
+	case onInitFinalStream == nil && onDataFinalStream == nil:
+		return CallSummary
+	default:
+		return CallText
+	}
+}
+
+func (mb *ModelBuilder) BuildFragmentSelectorModel(responseType any) jpf.Model {
+	return &scriptedModel{mb, CallSkillSelection, nil, nil}
+}
+
+// Record the call and take the next response for it.
+func (mb *ModelBuilder) respond(kind CallKind, msgs []jpf.Message) (scriptedResponse, error) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+	mb.calls = append(mb.calls, RecordedCall{Kind: kind, Messages: slices.Clone(msgs)})
+	queue := mb.queues[kind]
+	if len(queue) == 0 {
+		err := fmt.Errorf("reacttest: no scripted %s response left for call %d", kind, len(mb.calls)-1)
+		mb.t.Error(err)
+		return scriptedResponse{}, err
+	}
+	mb.queues[kind] = queue[1:]
+	return queue[0], nil
+}
+
+type scriptedModel struct {
+	builder *ModelBuilder
+	kind    CallKind
+	onInit  func()
+	onData  func(string)
+}
+
+func (m *scriptedModel) Respond(ctx context.Context, msgs []jpf.Message) (jpf.ModelResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return jpf.ModelResponse{}, err
+	}
+	resp, err := m.builder.respond(m.kind, msgs)
+	if err != nil {
+		return jpf.ModelResponse{}, err
+	}
+	if resp.err != nil {
+		return jpf.ModelResponse{}, resp.err
+	}
+	streamText(resp.content, m.onInit, m.onData)
+	return jpf.ModelResponse{PrimaryMessage: jpf.Message{Role: jpf.AssistantRole, Content: resp.content}}, nil
+}
+
+// Stream the text back a word at a time.
+func streamText(text string, onInit func(), onData func(string)) {
+	if onInit != nil {
+		onInit()
+	}
+	if onData != nil {
+		for _, word := range strings.SplitAfter(text, " ") {
+			if word != "" {
+				onData(word)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package reacttest_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+// Records the failures of a test, so they can be checked instead of failing the real test.
+type failureRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *failureRecorder) Helper() {}
+func (r *failureRecorder) Error(args ...any) {
+	r.failures = append(r.failures, fmt.Sprint(args...))
+}
+func (r *failureRecorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// The example from the package documentation.
+func TestDocExample(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueReAct("I need the time", reacttest.Call("time", nil))
+	mb.QueueReAct("I have the time")
+	mb.QueueFinalAnswer("It is 12:00")
+	clock := reacttest.NewTool("time", "12:00")
+	agent := react.New(mb, react.WithTools(clock))
+	agent.Send("What is the time?")
+	reacttest.AssertFinalAnswer(t, agent, "It is 12:00")
+	clock.AssertCalled(t, 1)
+	mb.AssertExhausted()
+}
+
+// The scripted responses must have the shape the agent parses.
+func TestQueuedResponseShapes(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	args := map[string]any{"query": "weather", "limit": 3.0, "filters": map[string]any{"recent": true}}
+	mb.QueueSkillSelection("weather")
+	mb.QueueReAct("Searching", reacttest.Call("search", args))
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("It is sunny")
+	mb.QueueSummary("The user asked about the weather")
+	mb.QueueSkillSelection()
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("You asked about the weather")
+	search := reacttest.NewTool("search", "sunny")
+	ag := react.New(mb,
+		react.WithTools(search),
+		react.WithSkills(react.Skill{Key: "weather", When: "the user asks about the weather", Content: "Use celsius"}),
+		react.WithCompaction(react.NewSummariser(mb), 1, 0),
+	)
+	for _, msg := range []string{"What is the weather?", "What did I ask?"} {
+		if _, err := ag.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search.AssertCalledWith(t, args)
+	reacttest.AssertFinalAnswer(t, ag, "You asked about the weather")
+	mb.AssertPromptContains(reacttest.CallReAct, 0, "Use celsius")
+	mb.AssertPromptContains(reacttest.CallReAct, -1, "The user asked about the weather")
+	mb.AssertExhausted()
+	if n := len(mb.CallsOf(reacttest.CallSummary)); n != 1 {
+		t.Fatalf("expected 1 summary call, got %d", n)
+	}
+}
+
+func TestModelBuilderFailures(t *testing.T) {
+	cases := []struct {
+		name    string
+		run     func(tb testing.TB, mb *reacttest.ModelBuilder)
+		wantErr string
+	}{
+		{"unused response", func(tb testing.TB, mb *reacttest.ModelBuilder) {
+			mb.QueueFinalAnswer("Unused")
+			mb.AssertExhausted()
+		}, "1 queued text responses were not used"},
+		{"missing response", func(tb testing.TB, mb *reacttest.ModelBuilder) {
+			mb.QueueReAct("Done")
+			react.New(mb).Send("Hello")
+		}, "no scripted text response left"},
+		{"prompt missing text", func(tb testing.TB, mb *reacttest.ModelBuilder) {
+			mb.QueueReAct("Done")
+			mb.QueueFinalAnswer("Hi")
+			react.New(mb).Send("Hello")
+			mb.AssertPromptContains(reacttest.CallText, 0, "Goodbye")
+		}, `prompt does not contain "Goodbye"`},
+		{"tool not called", func(tb testing.TB, mb *reacttest.ModelBuilder) {
+			mb.QueueReAct("Done")
+			mb.QueueFinalAnswer("Hi")
+			ag := react.New(mb)
+			ag.Send("Hello")
+			reacttest.AssertToolCalled(tb, ag, "search")
+		}, "search"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &failureRecorder{TB: t}
+			tc.run(rec, reacttest.NewModelBuilder(rec))
+			if !strings.Contains(strings.Join(rec.failures, "\n"), tc.wantErr) {
+				t.Fatalf("expected a failure containing %q, got %v", tc.wantErr, rec.failures)
+			}
+		})
+	}
+}
+
+func TestQueueError(t *testing.T) {
+	errModel := errors.New("model unavailable")
+	mb := reacttest.NewModelBuilder(t)
+	mb.QueueError(reacttest.CallReAct, errModel)
+	failing := reacttest.NewFailingTool("search", errModel)
+	ag := react.New(mb, react.WithTools(failing))
+	if _, err := ag.Send("Hello"); !errors.Is(err, errModel) {
+		t.Fatalf("expected the queued error, got %v", err)
+	}
+	reacttest.AssertToolNotCalled(t, ag, "search")
+	failing.AssertCalled(t, 0)
+	mb.AssertExhausted()
+}
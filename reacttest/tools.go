@@ -0,0 +1,83 @@
+package reacttest
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/JoshPattman/react"
+)
+
+// Tool is a fake [react.Tool] that records every call made to it.
+type Tool struct {
+	name        string
+	description []string
+	fn          func(map[string]any) (string, error)
+	lock        sync.Mutex
+	calls       []map[string]any
+}
+
+// NewTool creates a [Tool] that always responds with the response.
+func NewTool(name string, response string) *Tool {
+	return NewToolFunc(name, func(map[string]any) (string, error) { return response, nil })
+}
+
+// NewFailingTool creates a [Tool] that always fails with the error.
+func NewFailingTool(name string, err error) *Tool {
+	return NewToolFunc(name, func(map[string]any) (string, error) { return "", err })
+}
+
+// NewToolFunc creates a [Tool] that responds by calling the function.
+func NewToolFunc(name string, fn func(args map[string]any) (string, error)) *Tool {
+	return &Tool{
+		name:        name,
+		description: []string{fmt.Sprintf("The %s tool, for testing", name)},
+		fn:          fn,
+	}
+}
+
+var _ react.Tool = (*Tool)(nil)
+
+func (t *Tool) Name() string {
+	return t.name
+}
+
+func (t *Tool) Description() []string {
+	return t.description
+}
+
+func (t *Tool) Call(args map[string]any) (string, error) {
+	t.lock.Lock()
+	t.calls = append(t.calls, args)
+	t.lock.Unlock()
+	return t.fn(args)
+}
+
+// Calls returns the arguments of every call made to the tool so far, in order.
+func (t *Tool) Calls() []map[string]any {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return slices.Clone(t.calls)
+}
+
+// AssertCalled fails the test unless the tool was called exactly n times.
+func (t *Tool) AssertCalled(tb testing.TB, n int) {
+	tb.Helper()
+	if calls := t.Calls(); len(calls) != n {
+		tb.Errorf("reacttest: wanted tool '%s' to be called %d times, but it was called %d times", t.name, n, len(calls))
+	}
+}
+
+// AssertCalledWith fails the test unless the tool was called at least once with exactly the arguments.
+func (t *Tool) AssertCalledWith(tb testing.TB, args map[string]any) {
+	tb.Helper()
+	calls := t.Calls()
+	for _, c := range calls {
+		if reflect.DeepEqual(c, args) {
+			return
+		}
+	}
+	tb.Errorf("reacttest: tool '%s' was never called with %v, calls were %v", t.name, args, calls)
+}
@@ -0,0 +1,240 @@
+package react
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Redactor removes sensitive content from text before it is sent to a model.
+type Redactor interface {
+	// Replace any sensitive content in the text with placeholders.
+	// The same content should always be replaced with the same placeholder, so the model can still tell values apart.
+	Redact(text string) string
+	// Replace any placeholders in the text with the content they replaced.
+	Rehydrate(text string) string
+}
+
+// RedactionPattern finds one kind of sensitive content for [NewRegexRedactor].
+type RedactionPattern struct {
+	// Used in placeholders, such as EMAIL in [EMAIL_1].
+	Label  string
+	Regexp *regexp.Regexp
+	// If set, a match is only redacted if this returns true.
+	Validate func(match string) bool
+}
+
+// EmailPattern matches email addresses.
+func EmailPattern() RedactionPattern {
+	return RedactionPattern{
+		Label:  "EMAIL",
+		Regexp: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	}
+}
+
+// PhonePattern matches phone numbers of 9 to 15 digits, optionally with a country code and separators.
+func PhonePattern() RedactionPattern {
+	return RedactionPattern{
+		Label:  "PHONE",
+		Regexp: regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{1,4}\)[\s.\-]?)?\d{2,5}(?:[\s.\-]?\d{2,5}){1,4}`),
+		Validate: func(match string) bool {
+			n := countDigits(match)
+			return n >= 9 && n <= 15
+		},
+	}
+}
+
+// CardPattern matches payment card numbers of 13 to 19 digits, optionally separated by spaces or dashes, that pass the Luhn check.
+func CardPattern() RedactionPattern {
+	return RedactionPattern{
+		Label:    "CARD",
+		Regexp:   regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: luhnValid,
+	}
+}
+
+// DefaultRedactionPatterns returns the patterns for card numbers, email addresses, and phone numbers.
+func DefaultRedactionPatterns() []RedactionPattern {
+	// Cards must come before phones, as long phone numbers look like short card numbers
+	return []RedactionPattern{CardPattern(), EmailPattern(), PhonePattern()}
+}
+
+// NewRegexRedactor creates a [Redactor] that replaces every match of the patterns (applied in order) with a placeholder such as [EMAIL_1].
+// To add custom patterns to the built-in ones, pass append(DefaultRedactionPatterns(), custom...).
+// The redactor remembers every value it has redacted, so it should not be shared between unrelated conversations.
+// This memory is not saved with the conversation. When an agent is recreated (such as with [NewFromStore]) with a new redactor,
+// the placeholders are assigned again in the order the values appear in the history, so usually match the old ones,
+// but a placeholder for a value that no longer appears in the history (such as one compacted into a summary) cannot be rehydrated.
+func NewRegexRedactor(patterns ...RedactionPattern) Redactor {
+	return &regexRedactor{
+		patterns:      patterns,
+		placeholders:  make(map[string]string),
+		originals:     make(map[string]string),
+		labelCounters: make(map[string]int),
+	}
+}
+
+type regexRedactor struct {
+	lock     sync.Mutex
+	patterns []RedactionPattern
+	// Maps each redacted value to its placeholder
+	placeholders map[string]string
+	// Maps each placeholder to the value it replaced
+	originals     map[string]string
+	labelCounters map[string]int
+}
+
+var redactionPlaceholder = regexp.MustCompile(`\[[A-Z][A-Z0-9_]*_\d+\]`)
+
+func (r *regexRedactor) Redact(text string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, p := range r.patterns {
+		text = p.Regexp.ReplaceAllStringFunc(text, func(match string) string {
+			if p.Validate != nil && !p.Validate(match) {
+				return match
+			}
+			return r.placeholder(p.Label, match)
+		})
+	}
+	return text
+}
+
+func (r *regexRedactor) placeholder(label, value string) string {
+	key := label + "\x00" + value
+	if ph, ok := r.placeholders[key]; ok {
+		return ph
+	}
+	r.labelCounters[label]++
+	ph := fmt.Sprintf("[%s_%d]", label, r.labelCounters[label])
+	r.placeholders[key] = ph
+	r.originals[ph] = value
+	return ph
+}
+
+func (r *regexRedactor) Rehydrate(text string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return redactionPlaceholder.ReplaceAllStringFunc(text, func(ph string) string {
+		if original, ok := r.originals[ph]; ok {
+			return original
+		}
+		return ph
+	})
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+func luhnValid(s string) bool {
+	digits := strings.Map(func(c rune) rune {
+		if c >= '0' && c <= '9' {
+			return c
+		}
+		return -1
+	}, s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// Redact user messages, tool responses, and other messages that may contain sensitive content, before they are sent to a model.
+// The original messages are not modified.
+func redactMessages(r Redactor, msgs []Message) []Message {
+	if r == nil {
+		return msgs
+	}
+	redacted := make([]Message, len(msgs))
+	for i, m := range msgs {
+		switch m := m.(type) {
+		case userMessage:
+			m.Content = r.Redact(m.Content)
+			m.Parts = redactParts(r, m.Parts)
+			redacted[i] = m
+		case agentMessage:
+			m.Content = r.Redact(m.Content)
+			redacted[i] = m
+		case toolCallsMessage:
+			m.Reasoning = r.Redact(m.Reasoning)
+			redacted[i] = m
+		case toolResponseMessage:
+			responses := make([]ToolResponse, len(m.Responses))
+			for j, resp := range m.Responses {
+				resp.Response = r.Redact(resp.Response)
+				resp.Parts = redactParts(r, resp.Parts)
+				responses[j] = resp
+			}
+			m.Responses = responses
+			redacted[i] = m
+		case notificationMessage:
+			m.Notification.Content = r.Redact(m.Notification.Content)
+			redacted[i] = m
+		case summaryMessage:
+			m.Summary = r.Redact(m.Summary)
+			redacted[i] = m
+		default:
+			redacted[i] = m
+		}
+	}
+	return redacted
+}
+
+// Redact any text parts, and any text files that are shown to the model inline.
+// Other parts are left as they are, as they cannot be redacted.
+func redactParts(r Redactor, parts []ContentPart) []ContentPart {
+	if len(parts) == 0 {
+		return parts
+	}
+	redacted := make([]ContentPart, len(parts))
+	for i, p := range parts {
+		if p.Kind == PartText {
+			p.Text = r.Redact(p.Text)
+		} else if text := inlineTextFile(p); text != "" {
+			p.Data = []byte(r.Redact(text))
+		}
+		redacted[i] = p
+	}
+	return redacted
+}
+
+// Rehydrate any placeholders in the strings of a decoded json value.
+func rehydrateValue(r Redactor, v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.Rehydrate(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			out[i] = rehydrateValue(r, x)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			out[k] = rehydrateValue(r, x)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,77 @@
+package react
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactionPatterns(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"email", "mail ada@example.com now", "mail [EMAIL_1] now"},
+		{"phone", "call +44 20 7946 0958", "call [PHONE_1]"},
+		{"short number", "order 12345 is late", "order 12345 is late"},
+		{"card", "card 4111 1111 1111 1111 ok", "card [CARD_1] ok"},
+		// Too long to be a phone number, and fails the Luhn check
+		{"invalid card", "ref 4111 1111 1111 1112", "ref 4111 1111 1111 1112"},
+		{"repeated value", "a@b.io and a@b.io and c@d.io", "[EMAIL_1] and [EMAIL_1] and [EMAIL_2]"},
+		{"no match", "nothing to see", "nothing to see"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRegexRedactor(DefaultRedactionPatterns()...)
+			got := r.Redact(tc.text)
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			if back := r.Rehydrate(got); back != tc.text {
+				t.Errorf("rehydrated to %q, want %q", back, tc.text)
+			}
+		})
+	}
+}
+
+func TestRehydrateUnknownPlaceholder(t *testing.T) {
+	r := NewRegexRedactor(EmailPattern())
+	if got := r.Rehydrate("hi [EMAIL_3]"); got != "hi [EMAIL_3]" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRedactMessages(t *testing.T) {
+	const email = "ada@example.com"
+	cases := []struct {
+		name string
+		msg  Message
+	}{
+		{"user", userMessage{Content: email}},
+		{"user text part", userMessage{Parts: []ContentPart{TextPart(email)}}},
+		{"user text file", userMessage{Parts: []ContentPart{FilePart("a.txt", "text/plain", []byte(email))}}},
+		{"agent", agentMessage{Content: email}},
+		{"reasoning", toolCallsMessage{Reasoning: email}},
+		{"tool response", toolResponseMessage{Responses: []ToolResponse{{Response: email}}}},
+		{"tool response file", toolResponseMessage{Responses: []ToolResponse{{Parts: []ContentPart{FilePart("a.json", "application/json", []byte(email))}}}}},
+		{"notification", notificationMessage{Notification: Notification{Kind: "k", Content: email}}},
+		{"summary", summaryMessage{Summary: email}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msgs := []Message{tc.msg}
+			redacted := redactMessages(NewRegexRedactor(EmailPattern()), msgs)
+			prompt := (&messagesEncoder{}).encode(redacted)
+			for _, m := range prompt {
+				if strings.Contains(m.Content, email) {
+					t.Errorf("the prompt contains the email: %q", m.Content)
+				}
+			}
+			// The original messages are not modified
+			original := (&messagesEncoder{}).encode(msgs)
+			if !strings.Contains(original[len(original)-1].Content, email) {
+				t.Error("the original message was modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package react
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+type RenderOpt func(*renderKwargs)
+
+// Collapse any output or other block of text longer than maxChars, so that it is hidden until expanded.
+func WithRenderCollapse(maxChars int) func(kw *renderKwargs) {
+	return func(kw *renderKwargs) { kw.collapseAfter = maxChars }
+}
+
+// Include the system prompt template, which is left out by default as it is long and rarely changes.
+func WithRenderSystemPrompt() func(kw *renderKwargs) {
+	return func(kw *renderKwargs) { kw.systemPrompt = true }
+}
+
+// Include the ID, creation time, and metadata of each message.
+func WithRenderMessageInfo() func(kw *renderKwargs) {
+	return func(kw *renderKwargs) { kw.messageInfo = true }
+}
+
+// Set the title of the transcript.
+func WithRenderTitle(title string) func(kw *renderKwargs) {
+	return func(kw *renderKwargs) { kw.title = title }
+}
+
+type renderKwargs struct {
+	collapseAfter int
+	systemPrompt  bool
+	messageInfo   bool
+	title         string
+}
+
+func getRenderKwargs(opts []RenderOpt) renderKwargs {
+	kwargs := renderKwargs{title: "Conversation"}
+	for _, o := range opts {
+		o(&kwargs)
+	}
+	return kwargs
+}
+
+// RenderMarkdown renders the history as a human-readable markdown transcript, intended for debugging.
+// Collapsed blocks use html details tags, which most markdown viewers support.
+func RenderMarkdown(msgs []Message, opts ...RenderOpt) string {
+	kwargs := getRenderKwargs(opts)
+	sections := getRenderSections(msgs, kwargs)
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# %s\n", kwargs.title)
+	for _, s := range sections {
+		b.WriteString("\n")
+		switch s.class {
+		case "turn":
+			fmt.Fprintf(b, "## %s\n", s.title)
+		case "mode":
+			fmt.Fprintf(b, "*%s*\n", s.title)
+		default:
+			fmt.Fprintf(b, "### %s\n", s.title)
+		}
+		if s.info != "" {
+			fmt.Fprintf(b, "\n<sub>%s</sub>\n", s.info)
+		}
+		for _, block := range s.blocks {
+			b.WriteString("\n")
+			if block.title != "" {
+				fmt.Fprintf(b, "**%s**\n\n", block.title)
+			}
+			body := block.text
+			if block.code {
+				fence := markdownFence(body)
+				body = fmt.Sprintf("%s%s\n%s\n%s", fence, block.lang, body, fence)
+			}
+			if kwargs.collapseAfter > 0 && len(block.text) > kwargs.collapseAfter {
+				fmt.Fprintf(b, "<details><summary>Show %d characters</summary>\n\n%s\n\n</details>\n", len(block.text), body)
+			} else {
+				fmt.Fprintf(b, "%s\n", body)
+			}
+		}
+	}
+	return b.String()
+}
+
+// RenderHTML renders the history as a self-contained, human-readable html page, intended for debugging.
+func RenderHTML(msgs []Message, opts ...RenderOpt) string {
+	kwargs := getRenderKwargs(opts)
+	sections := getRenderSections(msgs, kwargs)
+	b := &strings.Builder{}
+	title := html.EscapeString(kwargs.title)
+	fmt.Fprintf(b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>%s</style>\n</head>\n<body>\n<h1>%s</h1>\n", title, renderHTMLStyle, title)
+	for _, s := range sections {
+		switch s.class {
+		case "turn":
+			fmt.Fprintf(b, "<h2>%s</h2>\n", html.EscapeString(s.title))
+			writeHTMLInfo(b, s.info)
+			continue
+		case "mode":
+			fmt.Fprintf(b, "<div class=\"mode\">%s</div>\n", html.EscapeString(s.title))
+			writeHTMLInfo(b, s.info)
+			continue
+		}
+		fmt.Fprintf(b, "<section class=\"%s\">\n<h3>%s</h3>\n", s.class, html.EscapeString(s.title))
+		writeHTMLInfo(b, s.info)
+		for _, block := range s.blocks {
+			if block.title != "" {
+				fmt.Fprintf(b, "<div class=\"block-title\">%s</div>\n", html.EscapeString(block.title))
+			}
+			tag := "div"
+			if block.code {
+				tag = "pre"
+			}
+			body := fmt.Sprintf("<%s class=\"text\">%s</%s>", tag, html.EscapeString(block.text), tag)
+			if kwargs.collapseAfter > 0 && len(block.text) > kwargs.collapseAfter {
+				fmt.Fprintf(b, "<details><summary>Show %d characters</summary>%s</details>\n", len(block.text), body)
+			} else {
+				fmt.Fprintf(b, "%s\n", body)
+			}
+		}
+		b.WriteString("</section>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func writeHTMLInfo(b *strings.Builder, info string) {
+	if info != "" {
+		fmt.Fprintf(b, "<div class=\"info\">%s</div>\n", html.EscapeString(info))
+	}
+}
+
+const renderHTMLStyle = `
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; color: #222; }
+section { border-left: 4px solid #ccc; margin: 0.8em 0; padding: 0.2em 0.8em; background: #fafafa; }
+section h3 { margin: 0.3em 0; font-size: 1em; }
+section.user { border-color: #3b82f6; }
+section.agent { border-color: #10b981; }
+section.reasoning { border-color: #a855f7; }
+section.tool-responses { border-color: #f59e0b; }
+section.notification, section.summary { border-color: #ef4444; }
+.mode { color: #777; font-style: italic; margin: 0.5em 0; }
+.info { color: #777; font-size: 0.8em; }
+.block-title { font-weight: bold; margin-top: 0.5em; }
+.text { white-space: pre-wrap; margin: 0.3em 0; }
+pre.text { background: #eee; padding: 0.5em; overflow-x: auto; }
+`
+
+// A chunk of the transcript, such as a single message.
+type renderSection struct {
+	// Describes what the section shows, and is used as the css class in html.
+	class  string
+	title  string
+	info   string
+	blocks []renderBlock
+}
+
+type renderBlock struct {
+	title string
+	text  string
+	code  bool
+	// The language of a code block, for syntax highlighting in markdown.
+	lang string
+}
+
+func getRenderSections(msgs []Message, kwargs renderKwargs) []renderSection {
+	conv := &renderConverter{kwargs: kwargs}
+	for _, m := range msgs {
+		before := len(conv.sections)
+		conv.messageTurn, _ = m.Info().MetaInt(MetaTurn)
+		VisitMessages(conv, m)
+		if kwargs.messageInfo && len(conv.sections) > before {
+			conv.sections[len(conv.sections)-1].info = describeMessageInfo(m.Info())
+		}
+	}
+	return conv.sections
+}
+
+func describeMessageInfo(info MessageInfo) string {
+	parts := make([]string, 0)
+	if info.ID != "" {
+		parts = append(parts, "id "+info.ID)
+	}
+	if !info.CreatedAt.IsZero() {
+		parts = append(parts, info.CreatedAt.Format(time.RFC3339))
+	}
+	for _, k := range slices.Sorted(maps.Keys(info.Metadata)) {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, info.Metadata[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Use a fence longer than any run of backticks in the text.
+func markdownFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+type renderConverter struct {
+	kwargs        renderKwargs
+	sections      []renderSection
+	turn          int
+	lastToolCalls []ToolCall
+	// The turn recorded on the message being rendered, or 0 if it has none
+	messageTurn int
+}
+
+func (conv *renderConverter) add(class, title string, blocks ...renderBlock) {
+	conv.sections = append(conv.sections, renderSection{class: class, title: title, blocks: blocks})
+}
+
+func (conv *renderConverter) AddSystem(template string) {
+	if conv.kwargs.systemPrompt {
+		conv.add("system", "System prompt template", renderBlock{text: template, code: true})
+	}
+}
+func (conv *renderConverter) AddUser(content string, parts []ContentPart) {
+	// The recorded turn is used where possible, as earlier turns may have been compacted into a summary
+	if conv.messageTurn > 0 {
+		conv.turn = conv.messageTurn
+	} else {
+		conv.turn++
+	}
+	// Notifications for a turn are added just before its user message, so the turn starts before them
+	start := len(conv.sections)
+	for start > 0 && conv.sections[start-1].class == "notification" {
+		start--
+	}
+	conv.sections = slices.Insert(conv.sections, start, renderSection{class: "turn", title: fmt.Sprintf("Turn %d", conv.turn)})
+	blocks := []renderBlock{{text: content}}
+	for _, p := range parts {
+		if p.Kind == PartText {
+			blocks = append(blocks, renderBlock{title: "Attached text", text: p.Text})
+		} else {
+			blocks = append(blocks, renderBlock{text: describePart(p)})
+		}
+	}
+	conv.add("user", "User", blocks...)
+}
+func (conv *renderConverter) AddAgent(content string) {
+	conv.add("agent", "Agent", renderBlock{text: content})
+}
+func (conv *renderConverter) AddToolCalls(reasoning string, toolCalls []ToolCall) {
+	conv.lastToolCalls = toolCalls
+	blocks := make([]renderBlock, 0)
+	if reasoning != "" {
+		blocks = append(blocks, renderBlock{text: reasoning})
+	}
+	for _, tc := range toolCalls {
+		args, _ := json.MarshalIndent(toolArgsToMap(tc.ToolArgs), "", "  ")
+		blocks = append(blocks, renderBlock{title: fmt.Sprintf("Tool call: %s", tc.ToolName), text: string(args), code: true, lang: "json"})
+	}
+	title := "Reasoning"
+	if len(toolCalls) == 0 {
+		title = "Reasoning (no more tool calls)"
+	}
+	conv.add("reasoning", title, blocks...)
+}
+func (conv *renderConverter) AddToolResponse(responses []ToolResponse) {
+	blocks := make([]renderBlock, 0)
+	for i, r := range responses {
+		toolName := "unknown"
+		if i < len(conv.lastToolCalls) {
+			toolName = conv.lastToolCalls[i].ToolName
+		}
+		blocks = append(blocks, renderBlock{title: fmt.Sprintf("Response from %s", toolName), text: r.Response, code: true})
+		for _, p := range r.Parts {
+			blocks = append(blocks, renderBlock{text: describePart(p)})
+		}
+	}
+	conv.add("tool-responses", "Tool responses", blocks...)
+}
+func (conv *renderConverter) AddModeSwitch(mode AgentMode) {
+	conv.add("mode", fmt.Sprintf("Mode: %s", mode))
+}
+func (conv *renderConverter) AddNotification(kind string, content string) {
+	conv.add("notification", fmt.Sprintf("Notification (%s)", kind), renderBlock{text: content})
+}
+func (conv *renderConverter) AddPersonality(personality string) {
+	conv.add("personality", "Personality", renderBlock{text: personality})
+}
+func (conv *renderConverter) AddSkills(skills []InsertedSkill) {
+	if len(skills) == 0 {
+		conv.add("skills", "Active skills (none)")
+		return
+	}
+	blocks := make([]renderBlock, len(skills))
+	for i, s := range skills {
+		title := s.Key
+		if s.When != "" {
+			title += fmt.Sprintf(" (when %s, for %d more turns)", s.When, s.NowRemainFor)
+		}
+		blocks[i] = renderBlock{title: title, text: s.Content}
+	}
+	conv.add("skills", "Active skills", blocks...)
+}
+func (conv *renderConverter) AddToolDefs(defs []AvailableToolDefinition) {
+	blocks := make([]renderBlock, len(defs))
+	for i, d := range defs {
+		blocks[i] = renderBlock{title: d.Name, text: "- " + strings.Join(d.Description, "\n- ")}
+	}
+	conv.add("tools", fmt.Sprintf("Available tools (%d)", len(defs)), blocks...)
+}
+func (conv *renderConverter) AddSummary(summary string, replaced []Message) {
+	conv.add("summary", fmt.Sprintf("Summary of %d earlier messages", len(replaced)), renderBlock{text: summary})
+}
+func (conv *renderConverter) AddUnknown(msg SerialisedMessage) {
+	data, _ := json.MarshalIndent(msg, "", "  ")
+	conv.add("unknown", fmt.Sprintf("Unknown message (%s)", msg.Kind), renderBlock{text: string(data), code: true, lang: "json"})
+}
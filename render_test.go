@@ -0,0 +1,58 @@
+package react
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTurns(t *testing.T) {
+	withTurn := func(m Message, turn int) Message { return stampMessage(m, map[string]any{MetaTurn: turn}) }
+	compacted := []Message{
+		summaryMessage{Summary: "They talked about cats"},
+		withTurn(userMessage{Content: "And dogs?"}, 5),
+		withTurn(modeSwitchMessage{Mode: ModeAnswerUser}, 5),
+		withTurn(agentMessage{Content: "Dogs are great"}, 5),
+	}
+	imported := []Message{
+		userMessage{Content: "one"},
+		agentMessage{Content: "1"},
+		userMessage{Content: "two"},
+	}
+	renderers := []struct {
+		name   string
+		render func([]Message, ...RenderOpt) string
+	}{
+		{"markdown", RenderMarkdown},
+		{"html", RenderHTML},
+	}
+	cases := []struct {
+		name string
+		msgs []Message
+		want []string
+	}{
+		{"recorded turns", compacted, []string{"Turn 5", "turn=5"}},
+		{"counted turns", imported, []string{"Turn 1", "Turn 2"}},
+	}
+	for _, r := range renderers {
+		for _, tc := range cases {
+			t.Run(r.name+" "+tc.name, func(t *testing.T) {
+				out := r.render(tc.msgs, WithRenderMessageInfo())
+				for _, want := range tc.want {
+					if !strings.Contains(out, want) {
+						t.Errorf("expected the transcript to contain %q:\n%s", want, out)
+					}
+				}
+				if strings.Contains(out, "Turn 1") && tc.name == "recorded turns" {
+					t.Error("the turn was counted from the start of the compacted history")
+				}
+			})
+		}
+	}
+	// The info of mode switches is shown by both renderers
+	for _, r := range renderers {
+		out := r.render(compacted, WithRenderMessageInfo())
+		if got := strings.Count(out, "turn=5"); got != 3 {
+			t.Errorf("%s: expected the info of all 3 messages, got %d", r.name, got)
+		}
+	}
+}
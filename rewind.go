@@ -0,0 +1,104 @@
+package react
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+var ErrTurnNotFound = errors.New("turn is not in the history (it may have been compacted)")
+
+// Rewind removes the given turn (numbered from 1, as in [MetaTurn]) and every turn after it from the history.
+// As the skills, tools, and personality of the agent are tracked in the history, they are restored to how they were before that turn.
+// If the tools of the agent have since changed, it is told about the current tools.
+func (ag *Agent) Rewind(turn int) error {
+	start, _, _, err := ag.findTurn(turn)
+	if err != nil {
+		return err
+	}
+	ag.messages = slices.Clone(ag.messages[:start])
+	ag.turn = turn - 1
+	ag.persistence.rewrite = true
+	if toolsHaveChanged(ag.messages, ag.tools) {
+		return ag.addMessages(nil, toolsMessage{Tools: getToolDefs(ag.tools)})
+	}
+	return ag.persist()
+}
+
+// Regenerate rewinds to before the given turn, then sends the same user message (with its attachments and notifications) again.
+func (ag *Agent) Regenerate(turn int, opts ...SendMessageOpt) (string, error) {
+	_, user, notifications, err := ag.findTurn(turn)
+	if err != nil {
+		return "", err
+	}
+	resendOpts := []SendMessageOpt{
+		WithNotifications(notifications...),
+		WithAttachments(user.Parts...),
+	}
+	return ag.Edit(turn, user.Content, append(resendOpts, opts...)...)
+}
+
+// Edit rewinds to before the given turn, then sends the new message in place of the original user message.
+func (ag *Agent) Edit(turn int, msg string, opts ...SendMessageOpt) (string, error) {
+	if err := ag.Rewind(turn); err != nil {
+		return "", err
+	}
+	return ag.Send(msg, opts...)
+}
+
+// Fork creates an independent copy of the agent, so that alternative continuations of the conversation can be explored side by side.
+// The history, tools list, skills, and skill variables are copied, so changes to the fork do not affect the original.
+// Anything the agent was created with that keeps its own state is shared with the original rather than copied:
+// the model builder and router (including the circuit breakers of a fallback builder), the skill selector, the summariser, the tokenizer,
+// the tools themselves, and the redactor (so a value is given the same placeholder in both).
+// The fork is not connected to any conversation store, use [Agent.PersistTo] to save it.
+func (ag *Agent) Fork() *Agent {
+	fork := &Agent{}
+	*fork = *ag
+	fork.persistence = conversationPersistence{}
+	fork.messages = slices.Clone(ag.messages)
+	fork.dynamicFragments = slices.Clone(ag.dynamicFragments)
+	fork.persistentSkills = slices.Clone(ag.persistentSkills)
+	fork.pendingSkills = slices.Clone(ag.pendingSkills)
+	fork.skillVars = maps.Clone(ag.skillVars)
+	fork.tools = slices.Clone(ag.tools)
+	for i, t := range fork.tools {
+		if _, ok := t.(*retrieveToolOutputTool); ok {
+			fork.tools[i] = &retrieveToolOutputTool{agent: fork}
+		}
+	}
+	return fork
+}
+
+// Find where the turn starts in the history (including its notifications), its user message, and its notifications.
+func (ag *Agent) findTurn(turn int) (int, userMessage, []Notification, error) {
+	seen := 0
+	for i, m := range ag.messages {
+		user, ok := m.(userMessage)
+		if !ok {
+			continue
+		}
+		seen++
+		userTurn, ok := user.Info().MetaInt(MetaTurn)
+		if !ok {
+			userTurn = seen
+		}
+		if userTurn != turn {
+			continue
+		}
+		// Notifications for a turn are added just before its user message
+		start := i
+		for start > 0 {
+			if _, ok := ag.messages[start-1].(notificationMessage); !ok {
+				break
+			}
+			start--
+		}
+		notifications := make([]Notification, 0)
+		for _, n := range ag.messages[start:i] {
+			notifications = append(notifications, n.(notificationMessage).Notification)
+		}
+		return start, user, notifications, nil
+	}
+	return 0, userMessage{}, nil, ErrTurnNotFound
+}
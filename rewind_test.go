@@ -0,0 +1,147 @@
+package react_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func messageIDs(msgs []react.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.Info().ID
+	}
+	return ids
+}
+
+// The content of the user messages in the history, in order.
+func userMessages(ag *react.Agent) []string {
+	var contents []string
+	for _, sm := range react.SerialiseMessages(slices.Collect(ag.Messages())) {
+		if sm.Kind == react.KindUser {
+			contents = append(contents, sm.Content)
+		}
+	}
+	return contents
+}
+
+func TestRewind(t *testing.T) {
+	clock := react.Notification{Kind: "time", Content: "It is 9am"}
+	cases := []struct {
+		name      string
+		change    func(ag *react.Agent, mb *reacttest.ModelBuilder) error
+		wantTurn  int
+		wantUsers []string
+		// The number of turns kept from the history before the change
+		wantKept int
+		// Whether the notifications of the rewound turn are sent again
+		wantResent bool
+		wantErr    error
+	}{
+		{"rewind the last turn", func(ag *react.Agent, mb *reacttest.ModelBuilder) error {
+			return ag.Rewind(3)
+		}, 2, []string{"One", "Two"}, 2, false, nil},
+		{"rewind to the start", func(ag *react.Agent, mb *reacttest.ModelBuilder) error {
+			return ag.Rewind(1)
+		}, 0, nil, 0, false, nil},
+		{"edit", func(ag *react.Agent, mb *reacttest.ModelBuilder) error {
+			mb.QueueReAct("Done")
+			mb.QueueFinalAnswer("Edited")
+			_, err := ag.Edit(2, "Second")
+			return err
+		}, 2, []string{"One", "Second"}, 1, false, nil},
+		{"regenerate", func(ag *react.Agent, mb *reacttest.ModelBuilder) error {
+			mb.QueueReAct("Done")
+			mb.QueueFinalAnswer("Regenerated")
+			_, err := ag.Regenerate(2)
+			return err
+		}, 2, []string{"One", "Two"}, 1, true, nil},
+		{"missing turn", func(ag *react.Agent, mb *reacttest.ModelBuilder) error {
+			return ag.Rewind(4)
+		}, 3, []string{"One", "Two", "Three"}, 3, false, react.ErrTurnNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := react.NewFileConversationStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			mb := reacttest.NewModelBuilder(t)
+			ag := react.New(mb, react.WithConversationStore(store, "c"))
+			// The history at the end of each turn, starting with before the first
+			histories := [][]react.Message{slices.Collect(ag.Messages())}
+			for _, msg := range []string{"One", "Two", "Three"} {
+				mb.QueueReAct("Done")
+				mb.QueueFinalAnswer("Answer to " + msg)
+				if _, err := ag.Send(msg, react.WithNotifications(clock)); err != nil {
+					t.Fatal(err)
+				}
+				histories = append(histories, slices.Collect(ag.Messages()))
+			}
+
+			if err := tc.change(ag, mb); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			mb.AssertExhausted()
+
+			if ag.Turn() != tc.wantTurn {
+				t.Errorf("expected turn %d, got %d", tc.wantTurn, ag.Turn())
+			}
+			if got := userMessages(ag); !slices.Equal(got, tc.wantUsers) {
+				t.Errorf("expected user messages %v, got %v", tc.wantUsers, got)
+			}
+			msgs := slices.Collect(ag.Messages())
+			kept := messageIDs(histories[tc.wantKept])
+			if got := messageIDs(msgs); len(got) < len(kept) || !slices.Equal(got[:len(kept)], kept) {
+				t.Errorf("expected the history to start with the first %d turns", tc.wantKept)
+			}
+			resent := false
+			for _, sm := range react.SerialiseMessages(msgs[len(kept):]) {
+				resent = resent || (sm.Kind == react.KindNotification && sm.Content == clock.Content)
+			}
+			if resent != tc.wantResent {
+				t.Errorf("expected the notifications to be sent again: %v, got %v", tc.wantResent, resent)
+			}
+			stored, err := store.Load("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(messageIDs(stored), messageIDs(msgs)) {
+				t.Errorf("expected the stored conversation to be rewritten to match the history")
+			}
+		})
+	}
+}
+
+func TestFork(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	ag := react.New(mb, react.WithSkillVars(map[string]any{"name": "Ada"}))
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("Hello")
+	if _, err := ag.Send("Hi"); err != nil {
+		t.Fatal(err)
+	}
+	before := messageIDs(slices.Collect(ag.Messages()))
+
+	fork := ag.Fork()
+	mb.QueueReAct("Done")
+	mb.QueueFinalAnswer("Goodbye")
+	if _, err := fork.Send("Bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fork.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+	if got := messageIDs(slices.Collect(ag.Messages())); !slices.Equal(got, before) || ag.Turn() != 1 {
+		t.Fatalf("expected the original to be unchanged by the fork, got turn %d with %d messages", ag.Turn(), len(got))
+	}
+	// The snapshot exposes the skill variables, which must not be shared
+	fork.Snapshot().Options.SkillVars["name"] = "Bob"
+	if got := ag.Snapshot().Options.SkillVars["name"]; got != "Ada" {
+		t.Fatalf("expected the skill variables of the original to be unchanged, got %v", got)
+	}
+	mb.AssertExhausted()
+}
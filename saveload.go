@@ -1,5 +1,13 @@
 package react
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrUnknownMessageKind = errors.New("unknown message kind")
+
 type SerialisedMessageKind string
 
 const (
@@ -13,11 +21,13 @@ const (
 	KindAvailableTools SerialisedMessageKind = "available_tools"
 	KindModeSwitch     SerialisedMessageKind = "mode_switch"
 	KindPersonality    SerialisedMessageKind = "personality"
+	KindSummary        SerialisedMessageKind = "summary"
 )
 
 type SerialisedMessage struct {
 	Kind             SerialisedMessageKind     `json:"kind"`
 	Content          string                    `json:"content,omitempty"`
+	Parts            []ContentPart             `json:"parts,omitempty"`
 	Reasoning        string                    `json:"reasoning,omitempty"`
 	NotificationKind string                    `json:"notification_kind,omitempty"`
 	ToolCalls        []ToolCall                `json:"tool_calls,omitempty"`
@@ -26,51 +36,97 @@ type SerialisedMessage struct {
 	AvailableTools   []AvailableToolDefinition `json:"available_tools,omitempty"`
 	Mode             AgentMode                 `json:"mode,omitempty"`
 	Personality      string                    `json:"personality,omitempty"`
+	Replaced         []SerialisedMessage       `json:"replaced,omitempty"`
+	ID               string                    `json:"id,omitempty"`
+	CreatedAt        time.Time                 `json:"created_at,omitzero"`
+	Metadata         map[string]any            `json:"metadata,omitempty"`
 }
 
 func SerialiseMessages(msgs []Message) []SerialisedMessage {
 	converter := &serialisingConverter{}
-	convertMessages(converter, msgs)
+	for _, m := range msgs {
+		VisitMessages(converter, m)
+		info := m.Info()
+		last := &converter.out[len(converter.out)-1]
+		last.ID = info.ID
+		last.CreatedAt = info.CreatedAt
+		last.Metadata = info.Metadata
+	}
 	return converter.out
 }
 
+// KindOf returns the kind of the message, as it would be serialised.
+func KindOf(msg Message) SerialisedMessageKind {
+	return SerialiseMessages([]Message{msg})[0].Kind
+}
+
+// DeserialiseMessages converts serialised messages back into messages.
+// Messages of unknown kinds (for example, written by a newer version of this package) are kept as opaque messages,
+// which the agent ignores but which are serialised again unchanged.
 func DeserialiseMessages(smsgs []SerialisedMessage) []Message {
+	msgs, _ := deserialiseMessages(smsgs, false)
+	return msgs
+}
+
+// DeserialiseMessagesStrict is like [DeserialiseMessages], but returns [ErrUnknownMessageKind] if any message is of an unknown kind.
+func DeserialiseMessagesStrict(smsgs []SerialisedMessage) ([]Message, error) {
+	return deserialiseMessages(smsgs, true)
+}
+
+func deserialiseMessages(smsgs []SerialisedMessage, strict bool) ([]Message, error) {
 	msgs := make([]Message, len(smsgs))
 	for i, sm := range smsgs {
-		msgs[i] = dtoToMessage(sm)
+		msg, err := dtoToMessage(sm, strict)
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = msg.withInfo(MessageInfo{
+			ID:        sm.ID,
+			CreatedAt: sm.CreatedAt,
+			Metadata:  sm.Metadata,
+		})
 	}
-	return msgs
+	return msgs, nil
 }
 
-func dtoToMessage(d SerialisedMessage) Message {
+func dtoToMessage(d SerialisedMessage, strict bool) (Message, error) {
 	switch d.Kind {
 	case KindSystem:
-		return systemMessage{Template: d.Content}
+		return systemMessage{Template: d.Content}, nil
 	case KindUser:
-		return userMessage{Content: d.Content}
+		return userMessage{Content: d.Content, Parts: d.Parts}, nil
 	case KindAgent:
-		return agentMessage{Content: d.Content}
+		return agentMessage{Content: d.Content}, nil
 	case KindToolCalls:
 		return toolCallsMessage{
 			Reasoning: d.Reasoning,
 			ToolCalls: d.ToolCalls,
-		}
+		}, nil
 	case KindToolResponse:
 		return toolResponseMessage{
 			Responses: d.Responses,
-		}
+		}, nil
 	case KindNotification:
-		return notificationMessage{Notification{Content: d.Content, Kind: d.NotificationKind}}
+		return notificationMessage{Notification: Notification{Content: d.Content, Kind: d.NotificationKind}}, nil
 	case KindSkills:
-		return skillMessage{Skills: d.Skills}
+		return skillMessage{Skills: d.Skills}, nil
 	case KindAvailableTools:
-		return toolsMessage{Tools: d.AvailableTools}
+		return toolsMessage{Tools: d.AvailableTools}, nil
 	case KindModeSwitch:
-		return modeSwitchMessage{Mode: d.Mode}
+		return modeSwitchMessage{Mode: d.Mode}, nil
 	case KindPersonality:
-		return personalityMessage{d.Personality}
+		return personalityMessage{Personality: d.Personality}, nil
+	case KindSummary:
+		replaced, err := deserialiseMessages(d.Replaced, strict)
+		if err != nil {
+			return nil, err
+		}
+		return summaryMessage{Summary: d.Content, Replaced: replaced}, nil
 	default:
-		panic("unknown message kind")
+		if strict {
+			return nil, fmt.Errorf("%w: '%s'", ErrUnknownMessageKind, d.Kind)
+		}
+		return unknownMessage{Serialised: d}, nil
 	}
 }
 
@@ -85,10 +141,11 @@ func (c *serialisingConverter) AddSystem(template string) {
 	})
 }
 
-func (c *serialisingConverter) AddUser(content string) {
+func (c *serialisingConverter) AddUser(content string, parts []ContentPart) {
 	c.out = append(c.out, SerialisedMessage{
 		Kind:    KindUser,
 		Content: content,
+		Parts:   parts,
 	})
 }
 
@@ -149,3 +206,15 @@ func (c *serialisingConverter) AddToolDefs(defs []AvailableToolDefinition) {
 		AvailableTools: defs,
 	})
 }
+
+func (c *serialisingConverter) AddSummary(summary string, replaced []Message) {
+	c.out = append(c.out, SerialisedMessage{
+		Kind:     KindSummary,
+		Content:  summary,
+		Replaced: SerialiseMessages(replaced),
+	})
+}
+
+func (c *serialisingConverter) AddUnknown(msg SerialisedMessage) {
+	c.out = append(c.out, msg)
+}
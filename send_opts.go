@@ -1,5 +1,7 @@
 package react
 
+import "context"
+
 type SendMessageOpt func(*sendMessageKwargs)
 
 // In addition to other message streamers, use the provided streamer.
@@ -9,6 +11,13 @@ func WithMessageStreamer(streamer MessageStreamer) SendMessageOpt {
 	}
 }
 
+// In addition to other reason-act streamers, use the provided streamer.
+func WithReActStreamer(streamer ReActStreamer) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.reactStreamers = append(s.reactStreamers, streamer)
+	}
+}
+
 // In addition to other response streamers, use the provided streamer.
 func WithResponseStreamer(streamer TextStreamer) SendMessageOpt {
 	return func(s *sendMessageKwargs) {
@@ -22,14 +31,75 @@ func WithNotifications(notifications ...Notification) SendMessageOpt {
 	}
 }
 
+// Attach content, such as images or files, to the user message.
+func WithAttachments(parts ...ContentPart) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.attachments = append(s.attachments, parts...)
+	}
+}
+
+// Set variables used to render templated skills inserted during this turn.
+// These take precedence over the variables the agent was created with.
+// Persistent skills are already rendered, so these only reach them if they could not be rendered when the agent was created,
+// in which case the variables of the first turn are used for the rest of the conversation.
+func WithTurnSkillVars(vars map[string]any) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.skillVars = mergeSkillVars(s.skillVars, vars)
+	}
+}
+
+// Use the context for calls to the models, so the turn can be cancelled.
+func WithContext(ctx context.Context) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.ctx = ctx
+	}
+}
+
+// Call the handler with each [Event] of the turn as it happens.
+// The handler is called synchronously, so the agent waits for it to return.
+func WithEventHandler(handler func(Event)) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.eventHandlers = append(s.eventHandlers, handler)
+	}
+}
+
+// If the final answer fails part way through streaming, keep the text streamed so far as the final answer (with [MetaPartial] set),
+// instead of leaving the turn without an answer.
+// Send then returns the partial answer along with an error wrapping [ErrPartialAnswer] (both are non-empty),
+// and the events of the turn end with a [FinalAnswerEndEvent] with Partial set, followed by that error instead of a [TurnCompleteEvent].
+func WithPartialAnswerRecovery() SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.recoverPartial = true
+	}
+}
+
+// Deliver to every streamer and event handler on its own goroutine through a queue of up to queueSize callbacks,
+// so that slow streamers do not slow down the agent.
+// Each streamer still receives its callbacks in order, and Send waits for all queued callbacks to be delivered before returning.
+// The policy decides what happens when a queue is full. Dropping policies can drop any callback, including messages and lifecycle callbacks.
+// If the context of the turn (see [WithContext]) is cancelled, any queued callbacks are dropped, and Send stops waiting for the streamers,
+// so it can return while a callback is still running. Without a context that can be cancelled, a streamer that never returns blocks Send forever.
+func WithAsyncStreamers(queueSize int, policy StreamOverflowPolicy) SendMessageOpt {
+	return func(s *sendMessageKwargs) {
+		s.async = &asyncStreamConfig{queueSize, policy}
+	}
+}
+
 type sendMessageKwargs struct {
-	msgStreamers  []MessageStreamer
-	respStreamers []TextStreamer
-	notifications []Notification
+	async          *asyncStreamConfig
+	recoverPartial bool
+	ctx            context.Context
+	eventHandlers  []func(Event)
+	msgStreamers   []MessageStreamer
+	respStreamers  []TextStreamer
+	reactStreamers []ReActStreamer
+	notifications  []Notification
+	skillVars      map[string]any
+	attachments    []ContentPart
 }
 
 func getKwargs(opts []SendMessageOpt) sendMessageKwargs {
-	s := sendMessageKwargs{}
+	s := sendMessageKwargs{ctx: context.Background()}
 	for _, opt := range opts {
 		opt(&s)
 	}
@@ -37,5 +107,9 @@ func getKwargs(opts []SendMessageOpt) sendMessageKwargs {
 }
 
 func (kw sendMessageKwargs) Streamers() multiStreamers {
-	return multiStreamers{kw.msgStreamers, kw.respStreamers}
+	streamers := multiStreamers{msgStreamers: kw.msgStreamers, respStreamers: kw.respStreamers, reactStreamers: kw.reactStreamers, eventHandlers: kw.eventHandlers}
+	if kw.async != nil {
+		return streamers.async(kw.ctx, *kw.async)
+	}
+	return streamers
 }
@@ -0,0 +1,318 @@
+package react
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// NewUnionSkillSelector creates a [SkillSelector] that selects every skill chosen by any of the selectors.
+// Each skill is only selected once, in the order it was first chosen.
+func NewUnionSkillSelector(selectors ...SkillSelector) SkillSelector {
+	return &unionSkillSelector{selectors}
+}
+
+type unionSkillSelector struct {
+	selectors []SkillSelector
+}
+
+func (s *unionSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	return s.SelectSkillsContext(context.Background(), skills, messages)
+}
+
+func (s *unionSkillSelector) SelectSkillsContext(ctx context.Context, skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(ctx, skills, messages)
+	return selected, err
+}
+
+func (s *unionSkillSelector) SelectSkillsReportingModel(ctx context.Context, skills []Skill, messages []Message) ([]Skill, string, error) {
+	selected := make([]Skill, 0)
+	models := make([]string, 0)
+	for _, selector := range s.selectors {
+		result, model, err := selectSkills(ctx, selector, skills, messages)
+		if err != nil {
+			return nil, "", err
+		}
+		models = append(models, model)
+		for _, skill := range result {
+			if !containsSkill(selected, skill.Key) {
+				selected = append(selected, skill)
+			}
+		}
+	}
+	return selected, joinModelNames(models...), nil
+}
+
+// NewIntersectionSkillSelector creates a [SkillSelector] that only selects skills chosen by all of the selectors.
+func NewIntersectionSkillSelector(selectors ...SkillSelector) SkillSelector {
+	return &intersectionSkillSelector{selectors}
+}
+
+type intersectionSkillSelector struct {
+	selectors []SkillSelector
+}
+
+func (s *intersectionSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	return s.SelectSkillsContext(context.Background(), skills, messages)
+}
+
+func (s *intersectionSkillSelector) SelectSkillsContext(ctx context.Context, skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(ctx, skills, messages)
+	return selected, err
+}
+
+func (s *intersectionSkillSelector) SelectSkillsReportingModel(ctx context.Context, skills []Skill, messages []Message) ([]Skill, string, error) {
+	if len(s.selectors) == 0 {
+		return nil, "", nil
+	}
+	selected, model, err := selectSkills(ctx, s.selectors[0], skills, messages)
+	if err != nil {
+		return nil, "", err
+	}
+	models := []string{model}
+	// The result may be owned by the selector, so filter a copy of it
+	selected = slices.Clone(selected)
+	for _, selector := range s.selectors[1:] {
+		result, model, err := selectSkills(ctx, selector, skills, messages)
+		if err != nil {
+			return nil, "", err
+		}
+		models = append(models, model)
+		selected = slices.DeleteFunc(selected, func(skill Skill) bool {
+			return !containsSkill(result, skill.Key)
+		})
+	}
+	return selected, joinModelNames(models...), nil
+}
+
+// NewPrefilteredSkillSelector creates a [SkillSelector] that first uses a (usually cheap) prefilter to choose candidate skills,
+// then only passes those candidates to the (usually expensive) selector.
+// If the prefilter chooses no skills, the selector is not called.
+func NewPrefilteredSkillSelector(prefilter, selector SkillSelector) SkillSelector {
+	return &prefilteredSkillSelector{prefilter, selector}
+}
+
+type prefilteredSkillSelector struct {
+	prefilter SkillSelector
+	selector  SkillSelector
+}
+
+func (s *prefilteredSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	return s.SelectSkillsContext(context.Background(), skills, messages)
+}
+
+func (s *prefilteredSkillSelector) SelectSkillsContext(ctx context.Context, skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(ctx, skills, messages)
+	return selected, err
+}
+
+func (s *prefilteredSkillSelector) SelectSkillsReportingModel(ctx context.Context, skills []Skill, messages []Message) ([]Skill, string, error) {
+	candidates, prefilterModel, err := selectSkills(ctx, s.prefilter, skills, messages)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(candidates) == 0 {
+		return nil, prefilterModel, nil
+	}
+	selected, model, err := selectSkills(ctx, s.selector, candidates, messages)
+	if err != nil {
+		return nil, "", err
+	}
+	return selected, joinModelNames(prefilterModel, model), nil
+}
+
+// NewFallbackSkillSelector creates a [SkillSelector] that uses the primary selector,
+// but uses the fallback selector if the primary one errors.
+func NewFallbackSkillSelector(primary, fallback SkillSelector) SkillSelector {
+	return &fallbackSkillSelector{primary, fallback}
+}
+
+type fallbackSkillSelector struct {
+	primary  SkillSelector
+	fallback SkillSelector
+}
+
+func (s *fallbackSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	return s.SelectSkillsContext(context.Background(), skills, messages)
+}
+
+func (s *fallbackSkillSelector) SelectSkillsContext(ctx context.Context, skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(ctx, skills, messages)
+	return selected, err
+}
+
+func (s *fallbackSkillSelector) SelectSkillsReportingModel(ctx context.Context, skills []Skill, messages []Message) ([]Skill, string, error) {
+	selected, model, err := selectSkills(ctx, s.primary, skills, messages)
+	if err == nil {
+		return selected, model, nil
+	}
+	// Falling back would not help if the turn was cancelled
+	if ctx.Err() != nil {
+		return nil, "", err
+	}
+	selected, model, fallbackErr := selectSkills(ctx, s.fallback, skills, messages)
+	if fallbackErr != nil {
+		return nil, "", errors.Join(err, fallbackErr)
+	}
+	return selected, model, nil
+}
+
+// NewKeywordSkillSelector creates a [SkillSelector] that selects a skill if the last user message
+// contains any of the keywords (case-insensitive) listed for that skill's key.
+// Skills without any keywords are never selected.
+func NewKeywordSkillSelector(keywords map[string][]string) SkillSelector {
+	return &keywordSkillSelector{keywords}
+}
+
+type keywordSkillSelector struct {
+	keywords map[string][]string
+}
+
+func (s *keywordSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	return s.SelectSkillsContext(context.Background(), skills, messages)
+}
+
+func (s *keywordSkillSelector) SelectSkillsContext(ctx context.Context, skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(ctx, skills, messages)
+	return selected, err
+}
+
+// Keywords are matched without a model, so no model is reported.
+func (s *keywordSkillSelector) SelectSkillsReportingModel(_ context.Context, skills []Skill, messages []Message) ([]Skill, string, error) {
+	lastUser := strings.ToLower(getLastUserMessage(messages))
+	selected := make([]Skill, 0)
+	for _, skill := range skills {
+		for _, kw := range s.keywords[skill.Key] {
+			if strings.Contains(lastUser, strings.ToLower(kw)) {
+				selected = append(selected, skill)
+				break
+			}
+		}
+	}
+	return selected, "", nil
+}
+
+// NewKeywordOverrideSkillSelector creates a [SkillSelector] that always selects a skill if the last user message
+// contains one of its keywords, and otherwise defers to the given selector.
+func NewKeywordOverrideSkillSelector(selector SkillSelector, keywords map[string][]string) SkillSelector {
+	return NewUnionSkillSelector(NewKeywordSkillSelector(keywords), selector)
+}
+
+func containsSkill(skills []Skill, key string) bool {
+	return slices.ContainsFunc(skills, func(s Skill) bool { return s.Key == key })
+}
+
+// SkillSelectorConfig describes how a built-in [SkillSelector] was built, so that it can be recorded and rebuilt later.
+type SkillSelectorConfig struct {
+	// One of the SelectorKind... constants.
+	Kind     string                `json:"kind"`
+	Children []SkillSelectorConfig `json:"children,omitempty"`
+	Keywords map[string][]string   `json:"keywords,omitempty"`
+}
+
+const (
+	SelectorKindNone         = "none"
+	SelectorKindLLM          = "llm"
+	SelectorKindUnion        = "union"
+	SelectorKindIntersection = "intersection"
+	SelectorKindPrefiltered  = "prefiltered"
+	SelectorKindFallback     = "fallback"
+	SelectorKindKeyword      = "keyword"
+	// A selector that does not implement [ConfigurableSkillSelector], so cannot be rebuilt.
+	SelectorKindCustom = "custom"
+)
+
+// ConfigurableSkillSelector is a [SkillSelector] that can describe how it was built.
+// All of the built-in selectors implement this.
+type ConfigurableSkillSelector interface {
+	SkillSelector
+	SelectorConfig() SkillSelectorConfig
+}
+
+// GetSkillSelectorConfig describes the selector, using [SelectorKindCustom] for selectors that cannot describe themselves.
+func GetSkillSelectorConfig(selector SkillSelector) SkillSelectorConfig {
+	if s, ok := selector.(ConfigurableSkillSelector); ok {
+		return s.SelectorConfig()
+	}
+	return SkillSelectorConfig{Kind: SelectorKindCustom}
+}
+
+func getSkillSelectorConfigs(selectors ...SkillSelector) []SkillSelectorConfig {
+	configs := make([]SkillSelectorConfig, len(selectors))
+	for i, s := range selectors {
+		configs[i] = GetSkillSelectorConfig(s)
+	}
+	return configs
+}
+
+// BuildSkillSelector rebuilds a [SkillSelector] from its config, using the model builder for any LLM-based selectors.
+func BuildSkillSelector(config SkillSelectorConfig, modelBuilder FragmentSelectorModelBuilder) (SkillSelector, error) {
+	children := make([]SkillSelector, len(config.Children))
+	for i, c := range config.Children {
+		child, err := BuildSkillSelector(c, modelBuilder)
+		if err != nil {
+			return nil, err
+		}
+		children[i] = child
+	}
+	needChildren := func(n int) error {
+		if len(children) != n {
+			return fmt.Errorf("a '%s' skill selector needs %d child selectors, got %d", config.Kind, n, len(children))
+		}
+		return nil
+	}
+	switch config.Kind {
+	case SelectorKindNone:
+		return &noSkillSelector{}, nil
+	case SelectorKindLLM:
+		return NewSkillSelector(modelBuilder), nil
+	case SelectorKindUnion:
+		return NewUnionSkillSelector(children...), nil
+	case SelectorKindIntersection:
+		return NewIntersectionSkillSelector(children...), nil
+	case SelectorKindPrefiltered:
+		if err := needChildren(2); err != nil {
+			return nil, err
+		}
+		return NewPrefilteredSkillSelector(children[0], children[1]), nil
+	case SelectorKindFallback:
+		if err := needChildren(2); err != nil {
+			return nil, err
+		}
+		return NewFallbackSkillSelector(children[0], children[1]), nil
+	case SelectorKindKeyword:
+		return NewKeywordSkillSelector(config.Keywords), nil
+	default:
+		return nil, fmt.Errorf("cannot build a skill selector of kind '%s'", config.Kind)
+	}
+}
+
+func (*noSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindNone}
+}
+
+func (*conversationLLMSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindLLM}
+}
+
+func (s *unionSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindUnion, Children: getSkillSelectorConfigs(s.selectors...)}
+}
+
+func (s *intersectionSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindIntersection, Children: getSkillSelectorConfigs(s.selectors...)}
+}
+
+func (s *prefilteredSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindPrefiltered, Children: getSkillSelectorConfigs(s.prefilter, s.selector)}
+}
+
+func (s *fallbackSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindFallback, Children: getSkillSelectorConfigs(s.primary, s.fallback)}
+}
+
+func (s *keywordSkillSelector) SelectorConfig() SkillSelectorConfig {
+	return SkillSelectorConfig{Kind: SelectorKindKeyword, Keywords: s.keywords}
+}
@@ -0,0 +1,115 @@
+package react
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// A selector that always returns the same slice.
+type staticSkillSelector struct {
+	skills []Skill
+}
+
+func (s *staticSkillSelector) SelectSkills([]Skill, []Message) ([]Skill, error) {
+	return s.skills, nil
+}
+
+func skillKeys(skills []Skill) []string {
+	keys := make([]string, len(skills))
+	for i, s := range skills {
+		keys[i] = s.Key
+	}
+	return keys
+}
+
+func TestIntersectionSkillSelector(t *testing.T) {
+	a, b, c := Skill{Key: "a", When: "a"}, Skill{Key: "b", When: "b"}, Skill{Key: "c", When: "c"}
+	cases := []struct {
+		name   string
+		first  []Skill
+		others [][]Skill
+		want   []string
+	}{
+		{"no others", []Skill{a, b}, nil, []string{"a", "b"}},
+		{"overlap", []Skill{a, b, c}, [][]Skill{{b, c}}, []string{"b", "c"}},
+		{"several", []Skill{a, b, c}, [][]Skill{{a, b}, {b, c}}, []string{"b"}},
+		{"disjoint", []Skill{a}, [][]Skill{{b}}, []string{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			first := &staticSkillSelector{slices.Clone(tc.first)}
+			selectors := []SkillSelector{first}
+			for _, o := range tc.others {
+				selectors = append(selectors, &staticSkillSelector{o})
+			}
+			got, err := NewIntersectionSkillSelector(selectors...).SelectSkills([]Skill{a, b, c}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(skillKeys(got), tc.want) {
+				t.Errorf("got %v, want %v", skillKeys(got), tc.want)
+			}
+			// The slice owned by the first selector must not be modified
+			if !slices.Equal(skillKeys(first.skills), skillKeys(tc.first)) {
+				t.Errorf("first selector's skills were modified to %v", skillKeys(first.skills))
+			}
+		})
+	}
+}
+
+// A selector that always returns the same slice, reporting the name of a model.
+type modelSkillSelector struct {
+	skills []Skill
+	model  string
+	err    error
+}
+
+func (s *modelSkillSelector) SelectSkills(skills []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := s.SelectSkillsReportingModel(context.Background(), skills, messages)
+	return selected, err
+}
+
+func (s *modelSkillSelector) SelectSkillsReportingModel(context.Context, []Skill, []Message) ([]Skill, string, error) {
+	if s.err != nil {
+		return nil, "", s.err
+	}
+	return s.skills, s.model, nil
+}
+
+func TestCombinedSkillSelectorsReportModels(t *testing.T) {
+	a, b := Skill{Key: "a", When: "a"}, Skill{Key: "b", When: "b"}
+	fast := &modelSkillSelector{[]Skill{a}, "fast", nil}
+	smart := &modelSkillSelector{[]Skill{a, b}, "smart", nil}
+	failing := &modelSkillSelector{nil, "", errors.New("unavailable")}
+	none := &modelSkillSelector{nil, "fast", nil}
+	keywords := NewKeywordSkillSelector(map[string][]string{"a": {"hello"}})
+	cases := []struct {
+		name     string
+		selector SkillSelector
+		want     string
+	}{
+		{"llm selector", NewSkillSelector(nil), ""},
+		{"union", NewUnionSkillSelector(fast, keywords, smart), "fast, smart"},
+		{"union of one model", NewUnionSkillSelector(fast, fast), "fast"},
+		{"intersection", NewIntersectionSkillSelector(smart, fast), "smart, fast"},
+		{"prefiltered", NewPrefilteredSkillSelector(fast, smart), "fast, smart"},
+		{"prefiltered to nothing", NewPrefilteredSkillSelector(none, smart), "fast"},
+		{"fallback", NewFallbackSkillSelector(fast, smart), "fast"},
+		{"fallen back", NewFallbackSkillSelector(failing, smart), "smart"},
+		{"keyword override", NewKeywordOverrideSkillSelector(smart, nil), "smart"},
+		{"nested", NewUnionSkillSelector(NewFallbackSkillSelector(failing, smart), keywords), "smart"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, model, err := selectSkills(context.Background(), tc.selector, []Skill{a, b}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if model != tc.want {
+				t.Errorf("expected the model %q to be reported, got %q", tc.want, model)
+			}
+		})
+	}
+}
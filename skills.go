@@ -1,9 +1,12 @@
 package react
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"slices"
 	"strings"
+	"text/template"
 
 	"github.com/JoshPattman/jpf"
 )
@@ -14,6 +17,48 @@ type SkillSelector interface {
 	SelectSkills([]Skill, []Message) ([]Skill, error)
 }
 
+// ContextSkillSelector is a [SkillSelector] that can be cancelled.
+// If a selector implements this, SelectSkillsContext is used instead of SelectSkills, with the context of the turn.
+// All of the built-in selectors implement this.
+type ContextSkillSelector interface {
+	SkillSelector
+	SelectSkillsContext(context.Context, []Skill, []Message) ([]Skill, error)
+}
+
+// ModelReportingSkillSelector is a [SkillSelector] that reports the name of the model that selected the skills,
+// which is recorded as [MetaModel] on the skills message.
+// If a selector implements this, SelectSkillsReportingModel is used instead of SelectSkillsContext or SelectSkills.
+// All of the built-in selectors implement this, and the ones that combine other selectors report the models of the selectors they used.
+type ModelReportingSkillSelector interface {
+	SkillSelector
+	SelectSkillsReportingModel(context.Context, []Skill, []Message) (selected []Skill, model string, err error)
+}
+
+// Select skills with the selector, passing the context if it accepts one, and returning the name of the model if it reports one.
+func selectSkills(ctx context.Context, selector SkillSelector, skills []Skill, messages []Message) ([]Skill, string, error) {
+	switch s := selector.(type) {
+	case ModelReportingSkillSelector:
+		return s.SelectSkillsReportingModel(ctx, skills, messages)
+	case ContextSkillSelector:
+		selected, err := s.SelectSkillsContext(ctx, skills, messages)
+		return selected, "", err
+	default:
+		selected, err := s.SelectSkills(skills, messages)
+		return selected, "", err
+	}
+}
+
+// Join the names of the models used by a selector that combines other selectors, leaving out any that are empty or repeated.
+func joinModelNames(names ...string) string {
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != "" && !slices.Contains(unique, name) {
+			unique = append(unique, name)
+		}
+	}
+	return strings.Join(unique, ", ")
+}
+
 func NewSkillSelector(modelBuilder FragmentSelectorModelBuilder) SkillSelector {
 	if modelBuilder == nil {
 		return &noSkillSelector{}
@@ -28,6 +73,14 @@ func (*noSkillSelector) SelectSkills([]Skill, []Message) ([]Skill, error) {
 	return nil, nil
 }
 
+func (*noSkillSelector) SelectSkillsContext(context.Context, []Skill, []Message) ([]Skill, error) {
+	return nil, nil
+}
+
+func (*noSkillSelector) SelectSkillsReportingModel(context.Context, []Skill, []Message) ([]Skill, string, error) {
+	return nil, "", nil
+}
+
 type conversationLLMSkillSelector struct {
 	modelBuilder FragmentSelectorModelBuilder
 }
@@ -42,13 +95,22 @@ type conversationLLMSkillSelectorOutput struct {
 }
 
 func (selector *conversationLLMSkillSelector) SelectSkills(frags []Skill, messages []Message) ([]Skill, error) {
+	return selector.SelectSkillsContext(context.Background(), frags, messages)
+}
+
+func (selector *conversationLLMSkillSelector) SelectSkillsContext(ctx context.Context, frags []Skill, messages []Message) ([]Skill, error) {
+	selected, _, err := selector.SelectSkillsReportingModel(ctx, frags, messages)
+	return selected, err
+}
+
+func (selector *conversationLLMSkillSelector) SelectSkillsReportingModel(ctx context.Context, frags []Skill, messages []Message) ([]Skill, string, error) {
 	model := selector.modelBuilder.BuildFragmentSelectorModel(conversationLLMSkillSelectorOutput{})
 	encoder := selector
 	decoder := jpf.NewJsonParser[conversationLLMSkillSelectorOutput]()
 	mf := jpf.NewOneShotPipeline(encoder, decoder, nil, model)
-	result, _, err := mf.Call(context.Background(), conversationLLMSkillSelectorInput{frags, messages})
+	result, _, err := mf.Call(ctx, conversationLLMSkillSelectorInput{frags, messages})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	fragLookup := make(map[string]Skill)
 	for _, f := range frags {
@@ -62,12 +124,12 @@ func (selector *conversationLLMSkillSelector) SelectSkills(frags []Skill, messag
 		}
 		relevantFrags = append(relevantFrags, frag)
 	}
-	return relevantFrags, nil
+	return relevantFrags, builtModelName(model, selector.modelBuilder), nil
 }
 
 func (selector *conversationLLMSkillSelector) BuildInputMessages(input conversationLLMSkillSelectorInput) ([]jpf.Message, error) {
 	enc := &xmlMessageConverter{}
-	convertMessages(enc, input.Messages)
+	VisitMessages(enc, input.Messages...)
 	conv := enc.lines
 	if len(conv) > 10 {
 		conv = conv[len(conv)-10:]
@@ -103,16 +165,19 @@ func (selector *conversationLLMSkillSelector) BuildInputMessages(input conversat
 
 // An encoder that converts user and assistant messages to xml lines
 type xmlMessageConverter struct {
-	baseMessageConverter
+	BaseMessageVisitor
 	lines []string
 }
 
-func (conv *xmlMessageConverter) AddUser(content string) {
-	conv.lines = append(conv.lines, fmt.Sprintf("<user-message>%s</user-message>", content))
+func (conv *xmlMessageConverter) AddUser(content string, parts []ContentPart) {
+	conv.lines = append(conv.lines, fmt.Sprintf("<user-message>%s</user-message>", joinContent(content, describeParts(parts))))
 }
 func (conv *xmlMessageConverter) AddAgent(content string) {
 	conv.lines = append(conv.lines, fmt.Sprintf("<agent-message>%s</agent-message>", content))
 }
+func (conv *xmlMessageConverter) AddSummary(summary string, replaced []Message) {
+	conv.lines = append(conv.lines, fmt.Sprintf("<conversation-summary>%s</conversation-summary>", summary))
+}
 
 func getDynamicAndPersistent(fragments []Skill) (dynamic, persistent []Skill) {
 	for _, f := range fragments {
@@ -124,3 +189,64 @@ func getDynamicAndPersistent(fragments []Skill) (dynamic, persistent []Skill) {
 	}
 	return dynamic, persistent
 }
+
+// Render the content of a templated skill with the given variables.
+// Skills that are not templated are returned unchanged.
+func renderSkill(s Skill, vars map[string]any) (Skill, error) {
+	if !s.Template {
+		return s, nil
+	}
+	tmp, err := template.New(s.Key).Option("missingkey=error").Parse(s.Content)
+	if err != nil {
+		return Skill{}, fmt.Errorf("failed to parse template of skill '%s': %w", s.Key, err)
+	}
+	result := bytes.NewBuffer(nil)
+	err = tmp.Execute(result, vars)
+	if err != nil {
+		return Skill{}, fmt.Errorf("failed to render template of skill '%s': %w", s.Key, err)
+	}
+	s.Content = result.String()
+	s.Template = false
+	return s, nil
+}
+
+// Render the persistent skills, to be inserted for the rest of the conversation.
+func renderPersistentSkills(skills []Skill, vars map[string]any) ([]InsertedSkill, error) {
+	inserted := make([]InsertedSkill, len(skills))
+	for i, s := range skills {
+		s, err := renderSkill(s, vars)
+		if err != nil {
+			return nil, err
+		}
+		inserted[i] = InsertedSkill{s, 999999999999999999}
+	}
+	return inserted, nil
+}
+
+// The skills that make the rendered persistent skills active, along with any dynamic skills that are still active at the end of the history.
+func withPersistentSkills(history []Message, persistent []InsertedSkill) []InsertedSkill {
+	skills := slices.Clone(persistent)
+	for _, s := range getLastInsertedSkills(history) {
+		if s.IsConditional() {
+			skills = append(skills, s)
+		}
+	}
+	return skills
+}
+
+// Check if the same skills are inserted, ignoring how long they remain for (which counts down for persistent skills too).
+func sameSkills(a, b []InsertedSkill) bool {
+	return slices.EqualFunc(a, b, func(x, y InsertedSkill) bool { return x.Skill == y.Skill })
+}
+
+// Merge two sets of skill variables, with the values in override taking precedence.
+func mergeSkillVars(base, override map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,78 @@
+package react_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func TestPersistentSkillMissingVariable(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	skill := react.Skill{Key: "greeting", Content: "Greet the user as {{.name}}", Template: true}
+	// Creating the agent must not fail, even though the variable is missing
+	ag := react.New(mb, react.WithSkills(skill))
+
+	if _, err := ag.Send("Hi"); err == nil {
+		t.Fatal("expected an error rendering the skill without its variable")
+	}
+
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("Hello Ada")
+	if _, err := ag.Send("Hi", react.WithTurnSkillVars(map[string]any{"name": "Ada"})); err != nil {
+		t.Fatal(err)
+	}
+	mb.AssertPromptContains(reacttest.CallReAct, 0, "Greet the user as Ada")
+	mb.AssertExhausted()
+}
+
+func TestPersistentSkillRenderedOnce(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	skill := react.Skill{Key: "greeting", Content: "Greet the user as {{.name}}", Template: true}
+	ag := react.New(mb, react.WithSkills(skill), react.WithSkillVars(map[string]any{"name": "Ada"}))
+
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("Hello Ada")
+	// The skill was rendered when the agent was created, so the turn variables do not reach it
+	if _, err := ag.Send("Hi", react.WithTurnSkillVars(map[string]any{"name": "Bob"})); err != nil {
+		t.Fatal(err)
+	}
+	mb.AssertPromptContains(reacttest.CallReAct, 0, "Greet the user as Ada")
+	if prompt := mb.CallsOf(reacttest.CallReAct)[0].Prompt(); strings.Contains(prompt, "Bob") {
+		t.Errorf("expected the persistent skill not to be rendered again with the turn variables:\n%s", prompt)
+	}
+	mb.AssertExhausted()
+}
+
+func TestCombinedSkillSelectorRecordsModel(t *testing.T) {
+	mb := reacttest.NewModelBuilder(t)
+	selector := react.NewUnionSkillSelector(
+		react.NewKeywordSkillSelector(map[string][]string{"weather": {"rain"}}),
+		react.NewSkillSelector(namedBuilder{mb, "selector-model"}),
+	)
+	ag := react.New(mb,
+		react.WithSkills(react.Skill{Key: "weather", When: "The user asks about the weather", Content: "Check the forecast"}),
+		react.WithSkillSelector(selector),
+	)
+	mb.QueueSkillSelection("weather")
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("Bring an umbrella")
+	if _, err := ag.Send("Will it rain?"); err != nil {
+		t.Fatal(err)
+	}
+	mb.AssertExhausted()
+	found := false
+	for msg := range ag.Messages() {
+		if turn, _ := msg.Info().MetaInt(react.MetaTurn); react.KindOf(msg) != react.KindSkills || turn != 1 {
+			continue
+		}
+		found = true
+		if got, _ := msg.Info().MetaString(react.MetaModel); got != "selector-model" {
+			t.Errorf("expected the skills to be selected by %q, got %q", "selector-model", got)
+		}
+	}
+	if !found {
+		t.Fatal("expected skills to be selected in the first turn")
+	}
+}
@@ -0,0 +1,382 @@
+package react
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// AgentSnapshot records the history of an agent along with the configuration needed to recreate it.
+// It can be saved with json.Marshal, read with [UnmarshalSnapshot], and restored with [RestoreSnapshot].
+// Tools cannot be saved, so only their names (and versions, see [VersionedTool]) are recorded.
+type AgentSnapshot struct {
+	// The version of the message format, as in [SerialisedConversation].
+	Version  int                 `json:"version"`
+	Messages []SerialisedMessage `json:"messages"`
+	// The persistent skills are recorded before they were rendered, so they can be rendered again when restoring.
+	// Snapshots taken before they were recorded leave the skills in the history as they are.
+	PersistentSkills []Skill             `json:"persistent_skills,omitempty"`
+	DynamicSkills    []Skill             `json:"dynamic_skills,omitempty"`
+	Tools            []ToolRef           `json:"tools,omitempty"`
+	SkillSelector    SkillSelectorConfig `json:"skill_selector"`
+	Options          SnapshotOptions     `json:"options"`
+}
+
+// ToolRef identifies a tool that the agent had.
+type ToolRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// SnapshotOptions records the options the agent was created with.
+type SnapshotOptions struct {
+	SkillVars              map[string]any      `json:"skill_vars,omitempty"`
+	ToolOutputElisionTurns int                 `json:"tool_output_elision_turns,omitempty"`
+	ContextBudget          *BudgetSnapshot     `json:"context_budget,omitempty"`
+	Compaction             *CompactionSnapshot `json:"compaction,omitempty"`
+	Redaction              *RedactionSnapshot  `json:"redaction,omitempty"`
+	// Whether the agent had a model router, which cannot be saved so must be passed again when restoring.
+	ModelRouter bool `json:"model_router,omitempty"`
+}
+
+type BudgetSnapshot struct {
+	MaxTokens int `json:"max_tokens"`
+	// One of the BudgetStrategy... constants.
+	Strategy string `json:"strategy"`
+}
+
+type CompactionSnapshot struct {
+	ThresholdTokens int `json:"threshold_tokens"`
+	KeepTurns       int `json:"keep_turns"`
+	// Either [SummariserLLM] or [SummariserCustom].
+	Summariser string `json:"summariser"`
+}
+
+type RedactionSnapshot struct {
+	// Either [RedactorRegex] or [RedactorCustom].
+	Redactor string `json:"redactor"`
+	// The patterns of a regex redactor, in the order they are applied.
+	Patterns  []RedactionPatternRef `json:"patterns,omitempty"`
+	Rehydrate bool                  `json:"rehydrate"`
+}
+
+// RedactionPatternRef identifies a [RedactionPattern] by its label and expression.
+type RedactionPatternRef struct {
+	Label  string `json:"label"`
+	Regexp string `json:"regexp"`
+}
+
+const (
+	BudgetStrategyError                 = "error"
+	BudgetStrategyDropOldestToolOutputs = "drop_oldest_tool_outputs"
+	BudgetStrategyTruncateToolResponses = "truncate_tool_responses"
+	// A strategy that is not built in to this package, so cannot be restored.
+	BudgetStrategyCustom = "custom"
+)
+
+const (
+	SummariserLLM = "llm"
+	// A summariser that is not built in to this package, so cannot be restored.
+	SummariserCustom = "custom"
+)
+
+const (
+	RedactorRegex = "regex"
+	// A redactor that is not built in to this package, so cannot be restored.
+	RedactorCustom = "custom"
+)
+
+// VersionedTool is a [Tool] that reports a version, so that restoring a snapshot can detect when a tool has changed.
+type VersionedTool interface {
+	Tool
+	Version() string
+}
+
+// ToolRegistry finds tools by name when restoring a snapshot.
+type ToolRegistry interface {
+	LookupTool(name string) (Tool, bool)
+}
+
+// NewToolRegistry creates a [ToolRegistry] containing the tools.
+func NewToolRegistry(tools ...Tool) ToolRegistry {
+	reg := &mapToolRegistry{tools: make(map[string]Tool)}
+	for _, t := range tools {
+		reg.tools[t.Name()] = t
+	}
+	return reg
+}
+
+type mapToolRegistry struct {
+	tools map[string]Tool
+}
+
+func (r *mapToolRegistry) LookupTool(name string) (Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+type SnapshotMismatchKind string
+
+const (
+	// The tool was not in the registry, so the agent no longer has it.
+	MismatchMissingTool SnapshotMismatchKind = "missing_tool"
+	// The tool in the registry has a different version to the one recorded.
+	MismatchToolVersion SnapshotMismatchKind = "tool_version"
+	// The skill selector was custom, so the default selector is used instead.
+	MismatchSkillSelector SnapshotMismatchKind = "skill_selector"
+	// The budget strategy was custom, so the error strategy is used instead.
+	MismatchBudgetStrategy SnapshotMismatchKind = "budget_strategy"
+	// The summariser was custom, so an LLM summariser is used instead.
+	MismatchSummariser SnapshotMismatchKind = "summariser"
+	// The redaction could not be restored, or differs from the redaction passed in when restoring.
+	// If it could not be restored, messages are sent to the model unredacted.
+	MismatchRedaction SnapshotMismatchKind = "redaction"
+	// The agent had a model router that was not passed in when restoring, so every call uses the model builder.
+	MismatchModelRouter SnapshotMismatchKind = "model_router"
+)
+
+// SnapshotMismatch describes a way in which a restored agent differs from the agent that the snapshot was taken of.
+type SnapshotMismatch struct {
+	Kind SnapshotMismatchKind
+	// The name of the tool, for tool mismatches.
+	Name   string
+	Detail string
+}
+
+func (m SnapshotMismatch) String() string {
+	if m.Name != "" {
+		return fmt.Sprintf("%s '%s': %s", m.Kind, m.Name, m.Detail)
+	}
+	return fmt.Sprintf("%s: %s", m.Kind, m.Detail)
+}
+
+// Snapshot records the history and configuration of the agent.
+// The tokenizer and conversation store of the agent are not recorded.
+// Custom skill selectors, budget strategies, summarisers and redactors, as well as model routers, are only recorded as being present,
+// so must be passed again when restoring (see [RestoreSnapshot]).
+func (ag *Agent) Snapshot() AgentSnapshot {
+	tools := make([]ToolRef, 0)
+	for _, t := range ag.tools {
+		// The retrieve tool is added back by the elision option
+		if _, ok := t.(*retrieveToolOutputTool); ok {
+			continue
+		}
+		ref := ToolRef{Name: t.Name()}
+		if vt, ok := t.(VersionedTool); ok {
+			ref.Version = vt.Version()
+		}
+		tools = append(tools, ref)
+	}
+	opts := SnapshotOptions{
+		SkillVars:              ag.skillVars,
+		ToolOutputElisionTurns: ag.elideAfterTurns,
+		Redaction:              redactionSnapshot(ag.redaction),
+		ModelRouter:            ag.router != nil,
+	}
+	if ag.budget.maxTokens > 0 {
+		opts.ContextBudget = &BudgetSnapshot{
+			MaxTokens: ag.budget.maxTokens,
+			Strategy:  budgetStrategyName(ag.budget.strategy),
+		}
+	}
+	if ag.compaction.summariser != nil {
+		summariser := SummariserCustom
+		if _, ok := ag.compaction.summariser.(*llmSummariser); ok {
+			summariser = SummariserLLM
+		}
+		opts.Compaction = &CompactionSnapshot{
+			ThresholdTokens: ag.compaction.threshold,
+			KeepTurns:       ag.compaction.keepTurns,
+			Summariser:      summariser,
+		}
+	}
+	return AgentSnapshot{
+		Version:          SerialisationVersion,
+		Messages:         SerialiseMessages(ag.messages),
+		PersistentSkills: ag.persistentSkills,
+		DynamicSkills:    ag.dynamicFragments,
+		Tools:            tools,
+		SkillSelector:    GetSkillSelectorConfig(ag.skillSelector),
+		Options:          opts,
+	}
+}
+
+// UnmarshalSnapshot reads a snapshot from json, migrating its messages from older versions of the format.
+func UnmarshalSnapshot(data []byte) (AgentSnapshot, error) {
+	var raw struct {
+		AgentSnapshot
+		Messages json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return AgentSnapshot{}, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	conv, err := migrateSerialisedMessages(raw.Version, raw.Messages)
+	if err != nil {
+		return AgentSnapshot{}, err
+	}
+	snap := raw.AgentSnapshot
+	snap.Version = conv.Version
+	snap.Messages = conv.Messages
+	return snap, nil
+}
+
+// RestoreSnapshot creates an agent from the snapshot, finding its tools by name in the registry (which may be nil).
+// Anything that could not be restored exactly is reported as a mismatch, and replaced with the default where possible.
+// The opts are applied after the options from the snapshot, so can be used to override them or to replace anything that could not be restored.
+// Tools passed in the opts are used instead of tools with the same name in the registry.
+func RestoreSnapshot(mb ModelBuilder, snap AgentSnapshot, registry ToolRegistry, opts ...NewOpt) (*Agent, []SnapshotMismatch, error) {
+	overrides := getNewKwargs(opts)
+	overriddenTools := make(map[string]bool)
+	for _, t := range overrides.tools {
+		overriddenTools[t.Name()] = true
+	}
+	mismatches := make([]SnapshotMismatch, 0)
+	restoreOpts := []NewOpt{
+		WithSkills(snap.PersistentSkills...),
+		WithSkills(snap.DynamicSkills...),
+		WithSkillVars(snap.Options.SkillVars),
+		WithToolOutputElision(snap.Options.ToolOutputElisionTurns),
+	}
+
+	for _, ref := range snap.Tools {
+		if overriddenTools[ref.Name] {
+			continue
+		}
+		var tool Tool
+		var ok bool
+		if registry != nil {
+			tool, ok = registry.LookupTool(ref.Name)
+		}
+		if !ok {
+			mismatches = append(mismatches, SnapshotMismatch{MismatchMissingTool, ref.Name, "tool is not in the registry"})
+			continue
+		}
+		version := ""
+		if vt, ok := tool.(VersionedTool); ok {
+			version = vt.Version()
+		}
+		if version != ref.Version {
+			mismatches = append(mismatches, SnapshotMismatch{
+				MismatchToolVersion,
+				ref.Name,
+				fmt.Sprintf("snapshot has version '%s' but registry has version '%s'", ref.Version, version),
+			})
+		}
+		restoreOpts = append(restoreOpts, WithTools(tool))
+	}
+
+	if overrides.skillSelector == nil {
+		if snap.SkillSelector.Kind == SelectorKindCustom {
+			mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchSkillSelector, Detail: "custom skill selector replaced with the default"})
+		} else if snap.SkillSelector.Kind != "" {
+			selector, err := BuildSkillSelector(snap.SkillSelector, skillSelectionModelBuilder(mb, overrides.router))
+			if err != nil {
+				return nil, nil, err
+			}
+			restoreOpts = append(restoreOpts, WithSkillSelector(selector))
+		}
+	}
+
+	if b := snap.Options.ContextBudget; b != nil && overrides.budget.maxTokens == 0 {
+		strategy, ok := budgetStrategyFromName(b.Strategy)
+		if !ok {
+			mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchBudgetStrategy, Detail: fmt.Sprintf("budget strategy '%s' replaced with the error strategy", b.Strategy)})
+		}
+		restoreOpts = append(restoreOpts, WithContextBudget(b.MaxTokens, strategy))
+	}
+
+	if c := snap.Options.Compaction; c != nil && overrides.compaction.summariser == nil {
+		if c.Summariser != SummariserLLM {
+			mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchSummariser, Detail: fmt.Sprintf("summariser '%s' replaced with an LLM summariser", c.Summariser)})
+		}
+		var summaryBuilder AgentModelBuilder = mb
+		if overrides.router != nil {
+			summaryBuilder = NewRoutedModelBuilder(overrides.router, PhaseSummary)
+		}
+		restoreOpts = append(restoreOpts, WithCompaction(NewSummariser(summaryBuilder), c.ThresholdTokens, c.KeepTurns))
+	}
+
+	if r := snap.Options.Redaction; r != nil {
+		if overrides.redaction.redactor == nil {
+			if patterns, ok := builtinRedactionPatterns(*r); ok {
+				restoreOpts = append(restoreOpts, WithRedaction(NewRegexRedactor(patterns...), r.Rehydrate))
+			} else {
+				mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchRedaction, Detail: "redaction with custom rules was not restored, so messages are sent unredacted"})
+			}
+		} else if !redactionSnapshotsEqual(*r, *redactionSnapshot(overrides.redaction)) {
+			mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchRedaction, Detail: "redaction differs from the snapshot"})
+		}
+	}
+
+	if snap.Options.ModelRouter && overrides.router == nil {
+		mismatches = append(mismatches, SnapshotMismatch{Kind: MismatchModelRouter, Detail: "model router not restored, so every call uses the model builder"})
+	}
+
+	ag := NewFromSaved(mb, DeserialiseMessages(snap.Messages), append(restoreOpts, opts...)...)
+	return ag, mismatches, nil
+}
+
+func redactionSnapshot(cfg redactionConfig) *RedactionSnapshot {
+	if cfg.redactor == nil {
+		return nil
+	}
+	snap := &RedactionSnapshot{Redactor: RedactorCustom, Rehydrate: cfg.rehydrate}
+	if r, ok := cfg.redactor.(*regexRedactor); ok {
+		snap.Redactor = RedactorRegex
+		for _, p := range r.patterns {
+			snap.Patterns = append(snap.Patterns, RedactionPatternRef{p.Label, p.Regexp.String()})
+		}
+	}
+	return snap
+}
+
+func redactionSnapshotsEqual(a, b RedactionSnapshot) bool {
+	return a.Redactor == b.Redactor && a.Rehydrate == b.Rehydrate && slices.Equal(a.Patterns, b.Patterns)
+}
+
+// Find the built-in patterns that the snapshot of a regex redactor was taken with.
+// Custom patterns cannot be restored, as their validation functions are not recorded.
+func builtinRedactionPatterns(snap RedactionSnapshot) ([]RedactionPattern, bool) {
+	if snap.Redactor != RedactorRegex {
+		return nil, false
+	}
+	defaults := DefaultRedactionPatterns()
+	patterns := make([]RedactionPattern, len(snap.Patterns))
+	for i, ref := range snap.Patterns {
+		j := slices.IndexFunc(defaults, func(p RedactionPattern) bool {
+			return p.Label == ref.Label && p.Regexp.String() == ref.Regexp
+		})
+		if j < 0 {
+			return nil, false
+		}
+		patterns[i] = defaults[j]
+	}
+	return patterns, true
+}
+
+func budgetStrategyName(strategy BudgetStrategy) string {
+	switch strategy.(type) {
+	case *errorBudgetStrategy:
+		return BudgetStrategyError
+	case *dropOldestToolOutputsStrategy:
+		return BudgetStrategyDropOldestToolOutputs
+	case *truncateToolResponsesStrategy:
+		return BudgetStrategyTruncateToolResponses
+	default:
+		return BudgetStrategyCustom
+	}
+}
+
+// Create the budget strategy with the name, or nil if it is not a built-in strategy.
+func budgetStrategyFromName(name string) (BudgetStrategy, bool) {
+	switch name {
+	case BudgetStrategyError:
+		return NewErrorBudgetStrategy(), true
+	case BudgetStrategyDropOldestToolOutputs:
+		return NewDropOldestToolOutputsStrategy(), true
+	case BudgetStrategyTruncateToolResponses:
+		return NewTruncateToolResponsesStrategy(), true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,148 @@
+package react_test
+
+import (
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	persistent := react.Skill{Key: "polite", Content: "Always address {{.name}} politely", Template: true}
+	dynamic := react.Skill{Key: "weather", When: "the user asks about the weather", Content: "Use celsius", RemainFor: 2}
+	selector := react.NewKeywordSkillSelector(map[string][]string{"weather": {"rain"}})
+
+	cases := []struct {
+		name    string
+		restore func(t *testing.T, mb *reacttest.ModelBuilder, ag *react.Agent) *react.Agent
+	}{
+		{"snapshot", func(t *testing.T, mb *reacttest.ModelBuilder, ag *react.Agent) *react.Agent {
+			data, err := json.Marshal(ag.Snapshot())
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap, err := react.UnmarshalSnapshot(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, mismatches, err := react.RestoreSnapshot(mb, snap, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mismatches) > 0 {
+				t.Fatalf("unexpected mismatches %v", mismatches)
+			}
+			return restored
+		}},
+		{"snapshot without persistent skills", func(t *testing.T, mb *reacttest.ModelBuilder, ag *react.Agent) *react.Agent {
+			snap := ag.Snapshot()
+			snap.PersistentSkills = nil
+			restored, _, err := react.RestoreSnapshot(mb, snap, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return restored
+		}},
+		{"saved without skills", func(t *testing.T, mb *reacttest.ModelBuilder, ag *react.Agent) *react.Agent {
+			return react.NewFromSaved(mb, slices.Collect(ag.Messages()), react.WithSkillSelector(selector))
+		}},
+		{"saved with skills", func(t *testing.T, mb *reacttest.ModelBuilder, ag *react.Agent) *react.Agent {
+			return react.NewFromSaved(mb, slices.Collect(ag.Messages()),
+				react.WithSkills(persistent, dynamic),
+				react.WithSkillVars(map[string]any{"name": "Ada"}),
+				react.WithSkillSelector(selector),
+			)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mb := reacttest.NewModelBuilder(t)
+			ag := react.New(mb,
+				react.WithSkills(persistent, dynamic),
+				react.WithSkillVars(map[string]any{"name": "Ada"}),
+				react.WithSkillSelector(selector),
+			)
+			mb.QueueReAct("Nothing to do")
+			mb.QueueFinalAnswer("It will rain")
+			if _, err := ag.Send("Will it rain?"); err != nil {
+				t.Fatal(err)
+			}
+
+			restored := tc.restore(t, mb, ag)
+			// Restoring does not change which skills are active, so adds nothing to the history
+			if got, want := len(slices.Collect(restored.Messages())), len(slices.Collect(ag.Messages())); got != want {
+				t.Errorf("restored history has %d messages, want %d", got, want)
+			}
+
+			mb.QueueReAct("Nothing to do")
+			mb.QueueFinalAnswer("Hello")
+			if _, err := restored.Send("Hello"); err != nil {
+				t.Fatal(err)
+			}
+			mb.AssertPromptContains(reacttest.CallReAct, 1, "Always address Ada politely")
+			mb.AssertPromptContains(reacttest.CallReAct, 1, "Use celsius")
+			mb.AssertExhausted()
+		})
+	}
+}
+
+func TestSnapshotRedactionAndRouter(t *testing.T) {
+	custom := react.RedactionPattern{Label: "ID", Regexp: regexp.MustCompile(`ID-\d+`)}
+	router := react.ModelRouterFunc(func(rc react.RouteContext) react.ModelRoute { return react.ModelRoute{Name: "only"} })
+	cases := []struct {
+		name           string
+		opts           []react.NewOpt
+		restoreOpts    []react.NewOpt
+		wantMismatches []react.SnapshotMismatchKind
+		wantRedacted   bool
+	}{
+		{"built-in patterns", []react.NewOpt{react.WithRedaction(react.NewRegexRedactor(react.DefaultRedactionPatterns()...), true)}, nil, nil, true},
+		{"custom patterns", []react.NewOpt{react.WithRedaction(react.NewRegexRedactor(custom), true)}, nil, []react.SnapshotMismatchKind{react.MismatchRedaction}, false},
+		{"custom patterns passed again", []react.NewOpt{react.WithRedaction(react.NewRegexRedactor(custom), true)},
+			[]react.NewOpt{react.WithRedaction(react.NewRegexRedactor(custom), true)}, nil, true},
+		{"different patterns passed", []react.NewOpt{react.WithRedaction(react.NewRegexRedactor(custom), true)},
+			[]react.NewOpt{react.WithRedaction(react.NewRegexRedactor(react.EmailPattern()), true)}, []react.SnapshotMismatchKind{react.MismatchRedaction}, false},
+		{"router", []react.NewOpt{react.WithModelRouter(router)}, nil, []react.SnapshotMismatchKind{react.MismatchModelRouter}, false},
+		{"router passed again", []react.NewOpt{react.WithModelRouter(router)}, []react.NewOpt{react.WithModelRouter(router)}, nil, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(react.New(reacttest.NewModelBuilder(t), tc.opts...).Snapshot())
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap, err := react.UnmarshalSnapshot(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mb := reacttest.NewModelBuilder(t)
+			restored, mismatches, err := react.RestoreSnapshot(mb, snap, nil, tc.restoreOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kinds []react.SnapshotMismatchKind
+			for _, m := range mismatches {
+				kinds = append(kinds, m.Kind)
+			}
+			if !slices.Equal(kinds, tc.wantMismatches) {
+				t.Fatalf("expected mismatches %v, got %v", tc.wantMismatches, mismatches)
+			}
+			if !tc.wantRedacted {
+				return
+			}
+			mb.QueueReAct("Nothing to do")
+			mb.QueueFinalAnswer("Hello")
+			if _, err := restored.Send("My email is ada@example.com and my id is ID-42"); err != nil {
+				t.Fatal(err)
+			}
+			prompt := mb.CallsOf(reacttest.CallReAct)[0].Prompt()
+			if strings.Contains(prompt, "ada@example.com") && strings.Contains(prompt, "ID-42") {
+				t.Fatalf("expected the restored agent to redact the message, got prompt %q", prompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package react
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrConversationNotFound = errors.New("conversation not found")
+var ErrConversationExists = errors.New("conversation already exists")
+
+// ConversationStore persists the histories of conversations, each identified by an ID.
+type ConversationStore interface {
+	// Load all messages of the conversation, returning [ErrConversationNotFound] if it does not exist.
+	Load(id string) ([]Message, error)
+	// Append messages to the end of the conversation, creating it if it does not exist.
+	Append(id string, msgs ...Message) error
+	// Replace all messages of the conversation, creating it if it does not exist.
+	// This must be atomic, so if it fails part way through, the old conversation is left intact.
+	Rewrite(id string, msgs []Message) error
+	// List the IDs of all stored conversations.
+	List() ([]string, error)
+	// Delete the conversation. Deleting a conversation that does not exist is not an error.
+	Delete(id string) error
+}
+
+// ConversationUpdater is a [ConversationStore] that can load, change, and rewrite a conversation as one operation,
+// so that messages appended at the same time are not lost.
+type ConversationUpdater interface {
+	ConversationStore
+	// Load the conversation, returning [ErrConversationNotFound] if it does not exist, and rewrite it with the messages returned by update.
+	Update(id string, update func([]Message) ([]Message, error)) error
+}
+
+// NewFromStore creates an agent that continues the conversation loaded from the store,
+// and appends any new messages back to the store as they are created.
+func NewFromStore(mb ModelBuilder, store ConversationStore, id string, opts ...NewOpt) (*Agent, error) {
+	messages, err := store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(slices.Clone(opts), WithConversationStore(store, id))
+	return NewFromSaved(mb, messages, opts...), nil
+}
+
+// NewFileConversationStore creates a [ConversationStore] that keeps each conversation in an append-only jsonl file in the directory.
+// The first line of each file records the format version, and every following line is a [SerialisedMessage].
+func NewFileConversationStore(dir string) (ConversationStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileConversationStore{dir: dir, appended: make(map[string]appendState)}, nil
+}
+
+type fileConversationStore struct {
+	lock sync.Mutex
+	dir  string
+	// If set, each message line is encrypted
+	keys KeyProvider
+	// The state of each encrypted conversation after it was last written by this store,
+	// so appending does not need to count the lines of the file again unless something else has changed it
+	appended map[string]appendState
+}
+
+type appendState struct {
+	// The size of the file after it was written
+	size     int64
+	header   []byte
+	numLines int
+}
+
+type fileConversationHeader struct {
+	Version   int  `json:"version"`
+	Encrypted bool `json:"encrypted,omitempty"`
+	// A random value that is new each time an encrypted file is written from scratch.
+	// It is authenticated with every line, along with the rest of the header, so lines cannot be moved between versions of the file.
+	// Files encrypted before this was added do not have it, and their lines are only bound to the conversation.
+	Nonce []byte `json:"nonce,omitempty"`
+}
+
+// The additional data authenticated with the encrypted line at the index (counting from 0 after the header).
+func lineAdditionalData(id string, header []byte, nonce []byte, index int) []byte {
+	if len(nonce) == 0 {
+		return []byte(id)
+	}
+	data, _ := json.Marshal(struct {
+		ID     string `json:"id"`
+		Header string `json:"header"`
+		Index  int    `json:"index"`
+	}{id, string(header), index})
+	return data
+}
+
+const conversationFileExt = ".jsonl"
+
+var validConversationID = regexp.MustCompile(`^[A-Za-z0-9_\-][A-Za-z0-9_.\-]*$`)
+
+func (s *fileConversationStore) path(id string) (string, error) {
+	if !validConversationID.MatchString(id) {
+		return "", fmt.Errorf("invalid conversation id '%s'", id)
+	}
+	return filepath.Join(s.dir, id+conversationFileExt), nil
+}
+
+func (s *fileConversationStore) Load(id string) ([]Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.load(id)
+}
+
+func (s *fileConversationStore) load(id string) ([]Message, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrConversationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	lines, err := readConversationLines(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	header, err := s.readHeader(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	msgLines := lines[1:]
+	if s.keys != nil {
+		for i, line := range msgLines {
+			if msgLines[i], err = decryptData(s.keys, line, lineAdditionalData(id, lines[0], header.Nonce, i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	conv, err := migrateSerialisedMessages(header.Version, joinJsonLines(msgLines))
+	if err != nil {
+		return nil, err
+	}
+	return DeserialiseMessages(conv.Messages), nil
+}
+
+func (s *fileConversationStore) Append(id string, msgs ...Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Remove any line torn by a crash, so the new lines are not appended onto it
+	if stat.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, stat.Size()-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := f.Truncate(int64(bytes.LastIndexByte(data, '\n') + 1)); err != nil {
+				return err
+			}
+			if stat, err = f.Stat(); err != nil {
+				return err
+			}
+		}
+	}
+	// Make sure the existing lines are in the same format as the new ones
+	var headerLine []byte
+	numLines := 0
+	if stat.Size() > 0 {
+		if headerLine, numLines, err = s.readAppendState(id, f, stat.Size()); err != nil {
+			return err
+		}
+	}
+	data, err := s.encodeLines(id, msgs, headerLine, numLines)
+	if err != nil {
+		return err
+	}
+	// Write everything at once so that a crash can at worst leave one torn line at the end
+	if _, err := f.Write(data); err != nil {
+		delete(s.appended, id)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		delete(s.appended, id)
+		return err
+	}
+	s.cacheAppendState(id, stat.Size(), data, headerLine, numLines+len(msgs))
+	return nil
+}
+
+// Read the header line of the file, and if the store encrypts, find the number of message lines after it, as their positions are encrypted with them.
+// The lines are only counted if the file has changed since this store last wrote it.
+func (s *fileConversationStore) readAppendState(id string, f *os.File, size int64) ([]byte, int, error) {
+	r := bufio.NewReader(io.NewSectionReader(f, 0, size))
+	var headerLine []byte
+	for len(headerLine) == 0 {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// There are no complete lines, so the header must be written with the messages
+			return nil, 0, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		headerLine = bytes.TrimSpace(line)
+	}
+	if _, err := s.readHeader(headerLine); err != nil {
+		return nil, 0, err
+	}
+	if s.keys == nil {
+		return headerLine, 0, nil
+	}
+	// Rewriting an encrypted file always creates a new header, so a file with the same size and header has only been appended to by this store
+	if cached, ok := s.appended[id]; ok && cached.size == size && bytes.Equal(cached.header, headerLine) {
+		return headerLine, cached.numLines, nil
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	lines, err := readConversationLines(rest)
+	if err != nil {
+		return nil, 0, err
+	}
+	return headerLine, len(lines), nil
+}
+
+// Remember the state of an encrypted conversation after data was written to the end of its file.
+func (s *fileConversationStore) cacheAppendState(id string, size int64, data []byte, headerLine []byte, numLines int) {
+	if s.keys == nil {
+		return
+	}
+	if headerLine == nil {
+		headerLine, _, _ = bytes.Cut(data, []byte("\n"))
+	}
+	s.appended[id] = appendState{size + int64(len(data)), headerLine, numLines}
+}
+
+// The whole conversation is written to a temporary file, which is then renamed over the original.
+func (s *fileConversationStore) Rewrite(id string, msgs []Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rewrite(id, msgs)
+}
+
+var _ ConversationUpdater = (*fileConversationStore)(nil)
+
+func (s *fileConversationStore) Update(id string, update func([]Message) ([]Message, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	msgs, err := s.load(id)
+	if err != nil {
+		return err
+	}
+	if msgs, err = update(msgs); err != nil {
+		return err
+	}
+	return s.rewrite(id, msgs)
+}
+
+func (s *fileConversationStore) rewrite(id string, msgs []Message) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := s.encodeLines(id, msgs, nil, 0)
+	if err != nil {
+		return err
+	}
+	// The temporary file does not have the conversation extension, so is never listed
+	f, err := os.CreateTemp(s.dir, "."+id+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		delete(s.appended, id)
+		return err
+	}
+	s.cacheAppendState(id, 0, data, nil, len(msgs))
+	return nil
+}
+
+// Encode the messages as lines of the file, starting at the index (counting from 0 after the header).
+// If the header line of the existing file is nil, a new header is created and included in the lines.
+func (s *fileConversationStore) encodeLines(id string, msgs []Message, headerLine []byte, first int) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	var header fileConversationHeader
+	if headerLine == nil {
+		header = fileConversationHeader{Version: SerialisationVersion, Encrypted: s.keys != nil}
+		if s.keys != nil {
+			header.Nonce = make([]byte, 16)
+			if _, err := rand.Read(header.Nonce); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if headerLine, err = json.Marshal(header); err != nil {
+			return nil, err
+		}
+		buf.Write(headerLine)
+		buf.WriteByte('\n')
+	} else if err := json.Unmarshal(headerLine, &header); err != nil {
+		return nil, fmt.Errorf("failed to read conversation header: %w", err)
+	}
+	for i, sm := range SerialiseMessages(msgs) {
+		line, err := json.Marshal(sm)
+		if err != nil {
+			return nil, err
+		}
+		if s.keys != nil {
+			// The conversation, header, and position are authenticated with the line,
+			// so lines cannot be moved between or within conversations, and the header cannot be changed
+			if line, err = encryptData(s.keys, line, lineAdditionalData(id, headerLine, header.Nonce, first+i)); err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Read the header line, checking that the file is encrypted if and only if this store encrypts.
+func (s *fileConversationStore) readHeader(line []byte) (fileConversationHeader, error) {
+	var header fileConversationHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, fmt.Errorf("failed to read conversation header: %w", err)
+	}
+	if header.Encrypted && s.keys == nil {
+		return header, errors.New("conversation is encrypted, but the store has no keys")
+	}
+	if !header.Encrypted && s.keys != nil {
+		return header, errors.New("conversation is not encrypted, but the store is")
+	}
+	return header, nil
+}
+
+func (s *fileConversationStore) List() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), conversationFileExt)
+		if ok && !e.IsDir() && validConversationID.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *fileConversationStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	delete(s.appended, id)
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Split the file into its non-empty lines.
+// A final line without a newline was torn by a crash during writing, so is ignored.
+func readConversationLines(data []byte) ([][]byte, error) {
+	if i := bytes.LastIndexByte(data, '\n'); i != len(data)-1 {
+		data = data[:i+1]
+	}
+	lines := make([][]byte, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			lines = append(lines, slices.Clone(line))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Join json lines into a single json list.
+func joinJsonLines(lines [][]byte) []byte {
+	return slices.Concat([]byte("["), bytes.Join(lines, []byte(",")), []byte("]"))
+}
+
+type conversationPersistence struct {
+	store ConversationStore
+	id    string
+	// How many messages at the start of the history are already in the store
+	persisted int
+	// Set when the history was changed other than by appending, so the stored conversation must be rewritten
+	rewrite bool
+	// Set for the conversation of an agent created by [New], which must not already be in the store
+	isNew bool
+}
+
+// PersistTo connects the agent to the conversation with the given ID in the store, replacing that conversation with the full history of the agent.
+// From then on, new messages are appended to the store as they are added to the history.
+func (ag *Agent) PersistTo(store ConversationStore, id string) error {
+	ag.persistence = conversationPersistence{store: store, id: id}
+	return ag.persistRewrite()
+}
+
+// Save any messages that are not yet in the store.
+func (ag *Agent) persist() error {
+	p := &ag.persistence
+	if p.store == nil {
+		return nil
+	}
+	if p.isNew {
+		existing, err := p.store.Load(p.id)
+		if err != nil && !errors.Is(err, ErrConversationNotFound) {
+			return err
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%w: '%s' (use NewFromStore to continue it)", ErrConversationExists, p.id)
+		}
+		p.isNew = false
+	}
+	if p.rewrite {
+		if err := p.store.Rewrite(p.id, ag.messages); err != nil {
+			return err
+		}
+		p.persisted = len(ag.messages)
+		p.rewrite = false
+	}
+	if p.persisted >= len(ag.messages) {
+		return nil
+	}
+	if err := p.store.Append(p.id, ag.messages[p.persisted:]...); err != nil {
+		return err
+	}
+	p.persisted = len(ag.messages)
+	return nil
+}
+
+// Mark that the history was changed other than by appending, then persist it.
+func (ag *Agent) persistRewrite() error {
+	ag.persistence.rewrite = true
+	return ag.persist()
+}
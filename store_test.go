@@ -0,0 +1,191 @@
+package react_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/react"
+	"github.com/JoshPattman/react/reacttest"
+)
+
+func newTestKeys(t *testing.T) react.KeyProvider {
+	keys, err := react.NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// The serialised contents of the messages, to compare histories.
+func contents(msgs []react.Message) []string {
+	out := make([]string, 0)
+	for _, sm := range react.SerialiseMessages(msgs) {
+		out = append(out, string(sm.Kind)+":"+sm.Content)
+	}
+	return out
+}
+
+// Build some messages by running an agent for the given number of turns.
+func conversationMessages(t *testing.T, turns int) []react.Message {
+	mb := reacttest.NewModelBuilder(t)
+	ag := react.New(mb)
+	for i := range turns {
+		mb.QueueReAct("Nothing to do")
+		mb.QueueFinalAnswer("answer " + string(rune('a'+i)))
+		if _, err := ag.Send("question " + string(rune('a'+i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return slices.Collect(ag.Messages())
+}
+
+func TestFileConversationStore(t *testing.T) {
+	cases := []struct {
+		name     string
+		newStore func(dir string) (react.ConversationStore, error)
+	}{
+		{"plain", react.NewFileConversationStore},
+		{"encrypted", func(dir string) (react.ConversationStore, error) {
+			return react.NewEncryptedFileConversationStore(dir, newTestKeys(t))
+		}},
+	}
+	msgs := conversationMessages(t, 2)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := tc.newStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Load("c"); !errors.Is(err, react.ErrConversationNotFound) {
+				t.Fatalf("expected ErrConversationNotFound, got %v", err)
+			}
+
+			// Appending in batches gives back the whole conversation
+			if err := store.Append("c", msgs[:3]...); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Append("c", msgs[3:]...); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := store.Load("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(contents(loaded), contents(msgs)) {
+				t.Fatalf("got %v, want %v", contents(loaded), contents(msgs))
+			}
+
+			// Rewriting replaces the conversation, leaving no temporary files
+			if err := store.Rewrite("c", msgs[:2]); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err = store.Load("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(contents(loaded), contents(msgs[:2])) {
+				t.Fatalf("after rewrite got %v, want %v", contents(loaded), contents(msgs[:2]))
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the conversation file, got %d entries", len(entries))
+			}
+
+			// A line torn by a crash is ignored, and the next append continues after it
+			path := dir + "/c.jsonl"
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(`{"kind":"us`)
+			f.Close()
+			if err := store.Append("c", msgs[2]); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err = store.Load("c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(contents(loaded), contents(msgs[:3])) {
+				t.Fatalf("after torn line got %v, want %v", contents(loaded), contents(msgs[:3]))
+			}
+
+			ids, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids, []string{"c"}) {
+				t.Errorf("listed %v", ids)
+			}
+			if err := store.Delete("c"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Load("c"); !errors.Is(err, react.ErrConversationNotFound) {
+				t.Errorf("expected ErrConversationNotFound after delete, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAgentPersistence(t *testing.T) {
+	store, err := react.NewFileConversationStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := reacttest.NewModelBuilder(t)
+	ag := react.New(mb, react.WithConversationStore(store, "c"))
+	for _, q := range []string{"one", "two"} {
+		mb.QueueReAct("Nothing to do")
+		mb.QueueFinalAnswer("answer " + q)
+		if _, err := ag.Send(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertStored := func(t *testing.T, ag *react.Agent) {
+		t.Helper()
+		stored, err := store.Load("c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := contents(slices.Collect(ag.Messages())); !slices.Equal(contents(stored), want) {
+			t.Errorf("stored %v, want %v", contents(stored), want)
+		}
+	}
+	assertStored(t, ag)
+
+	// Rewinding rewrites the stored conversation
+	if err := ag.Rewind(2); err != nil {
+		t.Fatal(err)
+	}
+	assertStored(t, ag)
+
+	// A new agent cannot take over the existing conversation
+	other := react.New(mb, react.WithConversationStore(store, "c"))
+	if _, err := other.Send("three"); !errors.Is(err, react.ErrConversationExists) {
+		t.Fatalf("expected ErrConversationExists, got %v", err)
+	}
+	assertStored(t, ag)
+
+	// But it can be continued from the store
+	continued, err := react.NewFromStore(mb, store, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb.QueueReAct("Nothing to do")
+	mb.QueueFinalAnswer("answer three")
+	if _, err := continued.Send("three"); err != nil {
+		t.Fatal(err)
+	}
+	assertStored(t, continued)
+	if stored, _ := store.Load("c"); !strings.Contains(strings.Join(contents(stored), "\n"), "answer three") {
+		t.Error("the continued conversation was not saved")
+	}
+	mb.AssertExhausted()
+}
@@ -1,5 +1,10 @@
 package react
 
+import "errors"
+
+// Wrapped by the error returned along with a partial final answer, when using [WithPartialAnswerRecovery].
+var ErrPartialAnswer = errors.New("the final answer was cut off part way through")
+
 // MessageStreamer defines a callback interface that can be used to listen to new messages that the agent creates.
 type MessageStreamer interface {
 	// Try to send a message, ignoring errors.
@@ -12,19 +17,88 @@ type TextStreamer interface {
 	TrySendTextChunk(chunk string)
 }
 
+// TextStreamLifecycle may optionally be implemented by a [TextStreamer] to be told when the final answer stream begins, ends, or fails.
+type TextStreamLifecycle interface {
+	// The final answer started streaming.
+	// If the model call is retried, this is called again, and any text already streamed should be discarded.
+	TryBeginTextStream()
+	// The final answer finished streaming successfully.
+	TryEndTextStream()
+	// The final answer failed part way through, or before it started.
+	TryFailTextStream(err error)
+}
+
 type multiStreamers struct {
-	msgStreamers  []MessageStreamer
-	respStreamers []TextStreamer
+	msgStreamers   []MessageStreamer
+	respStreamers  []TextStreamer
+	reactStreamers []ReActStreamer
+	eventHandlers  []func(Event)
+	// The queues of the streamers, if they are asynchronous
+	queues []*streamQueue
 }
 
 func (s multiStreamers) TrySendMessage(msg Message) {
 	for _, msgStreamer := range s.msgStreamers {
 		msgStreamer.TrySendMessage(msg)
 	}
+	s.emit(MessageAppendedEvent{Message: msg})
 }
 
 func (s multiStreamers) TrySendTextChunk(chunk string) {
 	for _, msgStreamer := range s.respStreamers {
 		msgStreamer.TrySendTextChunk(chunk)
 	}
+	s.emit(TextChunkEvent{Chunk: chunk})
+}
+
+func (s multiStreamers) beginTextStream() {
+	for _, respStreamer := range s.respStreamers {
+		if lifecycle, ok := respStreamer.(TextStreamLifecycle); ok {
+			lifecycle.TryBeginTextStream()
+		}
+	}
+	s.emit(TextStreamBeginEvent{})
+}
+
+func (s multiStreamers) endTextStream() {
+	for _, respStreamer := range s.respStreamers {
+		if lifecycle, ok := respStreamer.(TextStreamLifecycle); ok {
+			lifecycle.TryEndTextStream()
+		}
+	}
+	s.emit(TextStreamEndEvent{})
+}
+
+func (s multiStreamers) failTextStream(err error) {
+	for _, respStreamer := range s.respStreamers {
+		if lifecycle, ok := respStreamer.(TextStreamLifecycle); ok {
+			lifecycle.TryFailTextStream(err)
+		}
+	}
+	s.emit(TextStreamFailEvent{Err: err})
+}
+
+func (s multiStreamers) TrySendReasoningChunk(chunk string) {
+	for _, reactStreamer := range s.reactStreamers {
+		reactStreamer.TrySendReasoningChunk(chunk)
+	}
+	s.emit(ReasoningChunkEvent{Chunk: chunk})
+}
+
+func (s multiStreamers) TrySendToolCall(call ToolCall) {
+	for _, reactStreamer := range s.reactStreamers {
+		reactStreamer.TrySendToolCall(call)
+	}
+	s.emit(ToolCallGeneratedEvent{Call: call})
+}
+
+// Whether anything is listening to the reason-act stream.
+func (s multiStreamers) streamingReAct() bool {
+	return len(s.reactStreamers) > 0 || len(s.eventHandlers) > 0
+}
+
+func (s multiStreamers) emit(event Event) {
+	for _, handler := range s.eventHandlers {
+		handler(event)
+	}
 }
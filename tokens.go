@@ -0,0 +1,183 @@
+package react
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/JoshPattman/jpf"
+)
+
+var ErrContextBudgetExceeded = errors.New("prompt does not fit in the context budget")
+
+// Tokenizer defines an object that can count how many tokens a piece of text will use.
+type Tokenizer interface {
+	// Count (or estimate) the number of tokens in the text.
+	CountTokens(text string) int
+}
+
+// NewHeuristicTokenizer creates a [Tokenizer] that estimates roughly four bytes of text per token.
+// It is not exact, but it is close enough for most english text to enforce budgets.
+func NewHeuristicTokenizer() Tokenizer {
+	return &heuristicTokenizer{}
+}
+
+type heuristicTokenizer struct{}
+
+func (*heuristicTokenizer) CountTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// The estimated number of extra tokens used by the formatting of each message.
+const tokensPerMessage = 4
+
+// The estimated number of tokens used by each attached image.
+// This varies a lot between providers and image sizes, but is roughly what a medium sized image costs.
+const tokensPerImage = 768
+
+// CountMessageTokens counts the tokens used by a set of messages, including a small overhead for each message
+// and a fixed estimate for each image.
+func CountMessageTokens(tokenizer Tokenizer, msgs []jpf.Message) int {
+	total := 0
+	for _, m := range msgs {
+		total += tokensPerMessage + tokenizer.CountTokens(m.Content) + tokensPerImage*len(m.Images)
+	}
+	return total
+}
+
+// PromptTokens estimates the number of tokens the history would use in the next agent model call.
+// The prompt is built in the same way as for the model call, so values are redacted and the context budget is enforced.
+// If the history cannot be fitted in the budget, the tokens of the whole (redacted) history are counted instead.
+func (ag *Agent) PromptTokens() int {
+	enc := ag.encoder()
+	prompt, err := enc.BuildInputMessages(ag.messages)
+	if err != nil {
+		prompt = enc.encode(redactMessages(enc.redactor, ag.messages))
+	}
+	return CountMessageTokens(ag.tokenizer, prompt)
+}
+
+// BudgetStrategy defines how the history is reduced when its prompt does not fit in the context budget.
+type BudgetStrategy interface {
+	// Reduce the messages until fits returns true, returning [ErrContextBudgetExceeded] if that is not possible.
+	// The reduced messages are only used to build the prompt, so the input messages must not be modified.
+	FitBudget(msgs []Message, fits func([]Message) bool) ([]Message, error)
+}
+
+// NewErrorBudgetStrategy creates a [BudgetStrategy] that does not reduce the history,
+// instead erroring before the model is called.
+func NewErrorBudgetStrategy() BudgetStrategy {
+	return &errorBudgetStrategy{}
+}
+
+type errorBudgetStrategy struct{}
+
+func (*errorBudgetStrategy) FitBudget(msgs []Message, fits func([]Message) bool) ([]Message, error) {
+	return nil, ErrContextBudgetExceeded
+}
+
+// NewDropOldestToolOutputsStrategy creates a [BudgetStrategy] that removes tool responses,
+// oldest first, until the prompt fits.
+func NewDropOldestToolOutputsStrategy() BudgetStrategy {
+	return &dropOldestToolOutputsStrategy{}
+}
+
+type dropOldestToolOutputsStrategy struct{}
+
+func (*dropOldestToolOutputsStrategy) FitBudget(msgs []Message, fits func([]Message) bool) ([]Message, error) {
+	msgs = slices.Clone(msgs)
+	for i, m := range msgs {
+		m, ok := m.(toolResponseMessage)
+		if !ok {
+			continue
+		}
+		responses := make([]ToolResponse, len(m.Responses))
+		for j := range responses {
+			responses[j] = ToolResponse{Response: "[This tool response was removed to fit the context budget]"}
+		}
+		m.Responses = responses
+		msgs[i] = m
+		if fits(msgs) {
+			return msgs, nil
+		}
+	}
+	return nil, ErrContextBudgetExceeded
+}
+
+// NewTruncateToolResponsesStrategy creates a [BudgetStrategy] that truncates the longest tool responses,
+// progressively lowering the maximum length of a response until the prompt fits.
+// Text parts and text files attached to the responses are truncated in the same way.
+func NewTruncateToolResponsesStrategy() BudgetStrategy {
+	return &truncateToolResponsesStrategy{}
+}
+
+type truncateToolResponsesStrategy struct{}
+
+// Responses will never be truncated to shorter than this
+const minTruncatedResponseLength = 64
+
+func (*truncateToolResponsesStrategy) FitBudget(msgs []Message, fits func([]Message) bool) ([]Message, error) {
+	longest := 0
+	for _, m := range msgs {
+		if m, ok := m.(toolResponseMessage); ok {
+			for _, r := range m.Responses {
+				longest = max(longest, len(r.Response))
+				for _, p := range r.Parts {
+					longest = max(longest, len(p.Text), len(inlineTextFile(p)))
+				}
+			}
+		}
+	}
+	for limit := longest / 2; limit >= minTruncatedResponseLength; limit /= 2 {
+		truncated := slices.Clone(msgs)
+		for i, m := range truncated {
+			m, ok := m.(toolResponseMessage)
+			if !ok {
+				continue
+			}
+			responses := make([]ToolResponse, len(m.Responses))
+			for j, r := range m.Responses {
+				r.Response = truncateText(r.Response, limit)
+				r.Parts = truncateParts(r.Parts, limit)
+				responses[j] = r
+			}
+			m.Responses = responses
+			truncated[i] = m
+		}
+		if fits(truncated) {
+			return truncated, nil
+		}
+	}
+	return nil, ErrContextBudgetExceeded
+}
+
+// Truncate the text of every text part, and the data of every text file that would be inlined into the prompt.
+func truncateParts(parts []ContentPart, limit int) []ContentPart {
+	if len(parts) == 0 {
+		return parts
+	}
+	truncated := make([]ContentPart, len(parts))
+	for i, p := range parts {
+		switch {
+		case p.Kind == PartText:
+			p.Text = truncateText(p.Text, limit)
+		case inlineTextFile(p) != "":
+			p.Data = []byte(truncateText(string(p.Data), limit))
+		}
+		truncated[i] = p
+	}
+	return truncated
+}
+
+// Truncate the text to at most limit bytes (plus a note), without splitting any utf8 characters.
+func truncateText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n[... %d bytes truncated to fit the context budget]", text[:cut], len(text)-cut)
+}
@@ -0,0 +1,169 @@
+package react
+
+import (
+	"errors"
+	"image"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/JoshPattman/jpf"
+)
+
+func TestCountMessageTokensImages(t *testing.T) {
+	tok := NewHeuristicTokenizer()
+	img := jpf.ImageAttachment{Source: image.NewRGBA(image.Rect(0, 0, 1, 1))}
+	cases := []struct {
+		name string
+		msgs []jpf.Message
+		want int
+	}{
+		{"text", []jpf.Message{{Content: "abcd"}}, tokensPerMessage + 1},
+		{"one image", []jpf.Message{{Content: "abcd", Images: []jpf.ImageAttachment{img}}}, tokensPerMessage + 1 + tokensPerImage},
+		{"two images", []jpf.Message{{Images: []jpf.ImageAttachment{img, img}}}, tokensPerMessage + 2*tokensPerImage},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CountMessageTokens(tok, tc.msgs); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTruncateToolResponsesStrategyParts(t *testing.T) {
+	long := strings.Repeat("x", 4096)
+	cases := []struct {
+		name string
+		resp ToolResponse
+	}{
+		{"response", ToolResponse{Response: long}},
+		{"text part", ToolResponse{Response: "short", Parts: []ContentPart{TextPart(long)}}},
+		{"text file", ToolResponse{Response: "short", Parts: []ContentPart{FilePart("a.txt", "text/plain", []byte(long))}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := toolResponseMessage{Responses: []ToolResponse{tc.resp}}
+			msgs := []Message{userMessage{Content: "hi"}, original}
+			enc := &messagesEncoder{tokenizer: NewHeuristicTokenizer()}
+			fits := func(msgs []Message) bool {
+				return CountMessageTokens(enc.tokenizer, enc.encode(msgs)) <= 200
+			}
+			fitted, err := NewTruncateToolResponsesStrategy().FitBudget(msgs, fits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fits(fitted) {
+				t.Error("the fitted messages do not fit")
+			}
+			// The input messages are not modified
+			if got := responseText(original.Responses[0]); !strings.Contains(got, long) {
+				t.Error("the original response was modified")
+			}
+		})
+	}
+}
+
+// All of the text in a tool response, including its parts.
+func responseText(r ToolResponse) string {
+	text := r.Response
+	for _, p := range r.Parts {
+		text += p.Text + string(p.Data)
+	}
+	return text
+}
+
+// A history of two turns, each with a long tool response.
+func budgetTestHistory() []Message {
+	long := strings.Repeat("x", 2000)
+	return []Message{
+		systemMessage{Template: "You are an agent"},
+		userMessage{Content: "Email " + strings.Repeat("a", 200) + "@example.com the logs"},
+		toolCallsMessage{Reasoning: "Reading the logs"},
+		toolResponseMessage{Responses: []ToolResponse{{Response: long}}},
+		agentMessage{Content: "Sent"},
+		userMessage{Content: "And the next logs"},
+		toolCallsMessage{Reasoning: "Reading the logs"},
+		toolResponseMessage{Responses: []ToolResponse{{Response: long}}},
+		agentMessage{Content: "Sent"},
+	}
+}
+
+func TestPromptTokens(t *testing.T) {
+	// The agent adds some messages to the history, so count the history of an agent without a budget
+	plain := NewFromSaved(nil, budgetTestHistory())
+	full := CountMessageTokens(plain.tokenizer, (&messagesEncoder{}).encode(plain.messages))
+	cases := []struct {
+		name   string
+		opts   []NewOpt
+		check  func(got int) bool
+		expect string
+	}{
+		{"no budget", nil, func(got int) bool { return got == full }, "the whole history"},
+		{"redacted", []NewOpt{WithRedaction(NewRegexRedactor(EmailPattern()), false)},
+			func(got int) bool { return got < full && got > full-100 }, "the redacted history"},
+		{"dropped outputs", []NewOpt{WithContextBudget(full-100, NewDropOldestToolOutputsStrategy())},
+			func(got int) bool { return got <= full-100 && got > full/2 }, "only the oldest tool output to be dropped"},
+		{"truncated outputs", []NewOpt{WithContextBudget(full/2, NewTruncateToolResponsesStrategy())},
+			func(got int) bool { return got <= full/2 }, "the prompt to fit the budget"},
+		// The history cannot fit, so the whole of it is counted
+		{"over budget", []NewOpt{WithContextBudget(full/2, NewErrorBudgetStrategy())},
+			func(got int) bool { return got == full }, "the whole history"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ag := NewFromSaved(nil, budgetTestHistory(), tc.opts...)
+			if got := ag.PromptTokens(); !tc.check(got) {
+				t.Errorf("expected to count %s (%d tokens unreduced), got %d", tc.expect, full, got)
+			}
+		})
+	}
+}
+
+func TestBudgetStrategies(t *testing.T) {
+	enc := &messagesEncoder{tokenizer: NewHeuristicTokenizer()}
+	count := func(msgs []Message) int {
+		return CountMessageTokens(enc.tokenizer, enc.encode(msgs))
+	}
+	history := budgetTestHistory()
+	full := count(history)
+	cases := []struct {
+		name     string
+		strategy BudgetStrategy
+		budget   int
+		// The indexes of the tool responses that are expected to be reduced
+		wantReduced []int
+		wantErr     error
+	}{
+		{"error", NewErrorBudgetStrategy(), full - 1, nil, ErrContextBudgetExceeded},
+		{"drop the oldest output", NewDropOldestToolOutputsStrategy(), full - 100, []int{3}, nil},
+		{"drop every output", NewDropOldestToolOutputsStrategy(), full - 600, []int{3, 7}, nil},
+		{"drop too little", NewDropOldestToolOutputsStrategy(), 100, nil, ErrContextBudgetExceeded},
+		{"truncate", NewTruncateToolResponsesStrategy(), full - 100, []int{3, 7}, nil},
+		{"truncate too little", NewTruncateToolResponsesStrategy(), 100, nil, ErrContextBudgetExceeded},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fits := func(msgs []Message) bool { return count(msgs) <= tc.budget }
+			fitted, err := tc.strategy.FitBudget(history, fits)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !fits(fitted) {
+				t.Errorf("expected the prompt to fit in %d tokens, got %d", tc.budget, count(fitted))
+			}
+			var reduced []int
+			for i, m := range fitted {
+				if m, ok := m.(toolResponseMessage); ok && m.Responses[0].Response != history[i].(toolResponseMessage).Responses[0].Response {
+					reduced = append(reduced, i)
+				}
+			}
+			if !slices.Equal(reduced, tc.wantReduced) {
+				t.Errorf("expected the tool responses %v to be reduced, got %v", tc.wantReduced, reduced)
+			}
+		})
+	}
+}
@@ -42,23 +42,20 @@ func toolsHaveChanged(history []Message, tools []Tool) bool {
 	return false
 }
 
-// Compose on this to get no-op behaviour for all message types
-type baseMessageConverter struct{}
-
-func (*baseMessageConverter) AddSystem(template string)                           {}
-func (*baseMessageConverter) AddUser(content string)                              {}
-func (*baseMessageConverter) AddAgent(content string)                             {}
-func (*baseMessageConverter) AddToolCalls(reasoning string, toolCalls []ToolCall) {}
-func (*baseMessageConverter) AddToolResponse(responses []ToolResponse)            {}
-func (*baseMessageConverter) AddModeSwitch(mode AgentMode)                        {}
-func (*baseMessageConverter) AddNotification(kind string, content string)         {}
-func (*baseMessageConverter) AddPersonality(personality string)                   {}
-func (*baseMessageConverter) AddSkills(skills []InsertedSkill)                    {}
-func (*baseMessageConverter) AddToolDefs(defs []AvailableToolDefinition)          {}
+// Count the number of turns (user messages) in the history.
+func countTurns(history []Message) int {
+	turns := 0
+	for _, h := range history {
+		if _, ok := h.(userMessage); ok {
+			turns++
+		}
+	}
+	return turns
+}
 
 // An encoder that tracks current state of the agent without actually noting down messages
 type currentStateMessageConverter struct {
-	baseMessageConverter
+	BaseMessageVisitor
 	systemTemplate string
 	personality    string
 	skills         []InsertedSkill
@@ -84,10 +81,26 @@ func (conv *currentStateMessageConverter) AddToolDefs(defs []AvailableToolDefini
 
 func getCurrentState(msgs []Message) *currentStateMessageConverter {
 	enc := &currentStateMessageConverter{}
-	convertMessages(enc, msgs)
+	VisitMessages(enc, msgs...)
 	return enc
 }
 
 func getLastInsertedSkills(msgs []Message) []InsertedSkill {
 	return getCurrentState(msgs).skills
 }
+
+// An encoder that only keeps track of the content of the last user message
+type lastUserMessageConverter struct {
+	BaseMessageVisitor
+	content string
+}
+
+func (conv *lastUserMessageConverter) AddUser(content string, parts []ContentPart) {
+	conv.content = content
+}
+
+func getLastUserMessage(msgs []Message) string {
+	enc := &lastUserMessageConverter{}
+	VisitMessages(enc, msgs...)
+	return enc.content
+}
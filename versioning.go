@@ -0,0 +1,112 @@
+package react
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrUnsupportedVersion = errors.New("serialised conversation is from a newer, unsupported version")
+
+// The version of the conversation format written by [MarshalConversation].
+// Version 0 is a plain json list of [SerialisedMessage], as written before versioning was introduced.
+const SerialisationVersion = 1
+
+// SerialisedConversation is the versioned envelope that conversations are saved in.
+type SerialisedConversation struct {
+	Version  int                 `json:"version"`
+	Messages []SerialisedMessage `json:"messages"`
+}
+
+// A Migration upgrades the raw json objects of the messages from one version of the format to the next.
+// Working on raw objects allows fields that no longer exist to be read.
+type Migration func([]map[string]any) ([]map[string]any, error)
+
+// The migrations at index i upgrade from version i to version i+1, and are run in order.
+var migrations = [][]Migration{
+	// Version 1 only introduced the envelope, so the messages are unchanged
+	{func(msgs []map[string]any) ([]map[string]any, error) { return msgs, nil }},
+}
+
+// RegisterMigration adds a migration that is run when upgrading conversations from version from to version from+1,
+// after the built-in migration (and any previously registered ones) for that version.
+// This allows applications to upgrade their own data (such as message metadata) alongside the format.
+// It panics if from is not an older version than [SerialisationVersion].
+// It should be called during initialisation, as it is not safe to call while conversations are being unmarshalled.
+func RegisterMigration(from int, fn Migration) {
+	if from < 0 || from >= SerialisationVersion {
+		panic(fmt.Sprintf("cannot register a migration from version %d (it must be from 0 to %d)", from, SerialisationVersion-1))
+	}
+	migrations[from] = append(migrations[from], fn)
+}
+
+// MarshalConversation serialises the messages into json, in the current versioned format.
+func MarshalConversation(msgs []Message) ([]byte, error) {
+	return json.Marshal(SerialisedConversation{
+		Version:  SerialisationVersion,
+		Messages: SerialiseMessages(msgs),
+	})
+}
+
+// UnmarshalConversation reads messages from json written by [MarshalConversation] (of this or any older version),
+// or from a plain json list of [SerialisedMessage].
+// Older versions are migrated to the current version, and messages of unknown kinds are kept as in [DeserialiseMessages].
+func UnmarshalConversation(data []byte) ([]Message, error) {
+	conv, err := UnmarshalSerialisedConversation(data)
+	if err != nil {
+		return nil, err
+	}
+	return DeserialiseMessages(conv.Messages), nil
+}
+
+// UnmarshalSerialisedConversation is like [UnmarshalConversation], but stops before deserialising the messages.
+func UnmarshalSerialisedConversation(data []byte) (SerialisedConversation, error) {
+	var envelope struct {
+		Version  int             `json:"version"`
+		Messages json.RawMessage `json:"messages"`
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		envelope.Messages = data
+	} else if err := json.Unmarshal(data, &envelope); err != nil {
+		return SerialisedConversation{}, fmt.Errorf("failed to read conversation envelope: %w", err)
+	}
+	return migrateSerialisedMessages(envelope.Version, envelope.Messages)
+}
+
+// Upgrade the raw messages from the given version to the current version.
+func migrateSerialisedMessages(version int, data json.RawMessage) (SerialisedConversation, error) {
+	if version > SerialisationVersion {
+		return SerialisedConversation{}, fmt.Errorf("%w: version %d (the latest supported is %d)", ErrUnsupportedVersion, version, SerialisationVersion)
+	}
+	if version < 0 {
+		return SerialisedConversation{}, fmt.Errorf("invalid conversation version %d", version)
+	}
+	var raw []map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if len(data) > 0 {
+		if err := dec.Decode(&raw); err != nil {
+			return SerialisedConversation{}, fmt.Errorf("failed to read messages: %w", err)
+		}
+	}
+	for v := version; v < SerialisationVersion; v++ {
+		for _, migrate := range migrations[v] {
+			var err error
+			raw, err = migrate(raw)
+			if err != nil {
+				return SerialisedConversation{}, fmt.Errorf("failed to migrate from version %d to %d: %w", v, v+1, err)
+			}
+		}
+	}
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return SerialisedConversation{}, err
+	}
+	conv := SerialisedConversation{Version: SerialisationVersion}
+	if err := json.Unmarshal(migrated, &conv.Messages); err != nil {
+		return SerialisedConversation{}, fmt.Errorf("failed to read messages: %w", err)
+	}
+	return conv, nil
+}
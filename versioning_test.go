@@ -0,0 +1,110 @@
+package react
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestUnmarshalConversationVersions(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr error
+	}{
+		{"version 0 list", `[{"kind":"user","content":"hi"},{"kind":"agent","content":"hello"}]`, []string{"hi", "hello"}, nil},
+		{"version 0 envelope", `{"version":0,"messages":[{"kind":"user","content":"hi"}]}`, []string{"hi"}, nil},
+		{"current version", `{"version":1,"messages":[{"kind":"user","content":"hi"}]}`, []string{"hi"}, nil},
+		{"empty", `{"version":1}`, nil, nil},
+		{"newer version", `{"version":99,"messages":[]}`, nil, ErrUnsupportedVersion},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msgs, err := UnmarshalConversation([]byte(tc.data))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []string
+			for _, sm := range SerialiseMessages(msgs) {
+				got = append(got, sm.Content)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarshalConversationRoundTrip(t *testing.T) {
+	msgs := []Message{
+		stampMessage(userMessage{Content: "hi", Parts: []ContentPart{TextPart("extra")}}, map[string]any{MetaTurn: 1}),
+		stampMessage(agentMessage{Content: "hello"}, map[string]any{MetaTurn: 1}),
+	}
+	data, err := MarshalConversation(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalConversation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(msgs) {
+		t.Fatalf("got %d messages, want %d", len(got), len(msgs))
+	}
+	for i := range msgs {
+		if got[i].Info().ID != msgs[i].Info().ID {
+			t.Errorf("message %d has ID %q, want %q", i, got[i].Info().ID, msgs[i].Info().ID)
+		}
+		if turn, _ := got[i].Info().MetaInt(MetaTurn); turn != 1 {
+			t.Errorf("message %d has turn %d, want 1", i, turn)
+		}
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	original := slices.Clone(migrations[0])
+	t.Cleanup(func() { migrations[0] = original })
+	RegisterMigration(0, func(msgs []map[string]any) ([]map[string]any, error) {
+		for _, m := range msgs {
+			if m["kind"] == "user" {
+				m["content"] = "migrated " + m["content"].(string)
+			}
+		}
+		return msgs, nil
+	})
+	cases := []struct {
+		name string
+		data string
+		want string
+	}{
+		// Only conversations from before the migration's version are migrated
+		{"older version", `[{"kind":"user","content":"hi"}]`, "migrated hi"},
+		{"current version", `{"version":1,"messages":[{"kind":"user","content":"hi"}]}`, "hi"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conv, err := UnmarshalSerialisedConversation([]byte(tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := conv.Messages[0].Content; got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	for _, from := range []int{-1, SerialisationVersion} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected a panic registering a migration from version %d", from)
+				}
+			}()
+			RegisterMigration(from, nil)
+		}()
+	}
+}